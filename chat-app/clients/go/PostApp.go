@@ -11,6 +11,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -25,9 +26,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
 )
 
 type Configuration struct {
@@ -36,6 +35,33 @@ type Configuration struct {
     MaxMessages int
     RefreshSeconds int
     Debug bool
+    // The user pool and app client whose tokens whoami checks
+    UserPoolID string
+    ClientID   string
+    // Where we keep the user pool's keys; downloaded if it doesn't exist
+    JWKSFile string
+    // Where we keep registrations and password resets that aren't finished
+    PendingFile string
+    // Where we keep, for each user, the newest mention of them they've seen
+    MentionsFile string
+    // Where we keep every post we've gotten, to search
+    SearchIndexFile string
+    // The SQLite database where we keep every post we've gotten, to list them from
+    HistoryFile string
+    // Where we keep the posts we haven't sent yet
+    OutboxFile string
+    // Where we keep users and posts: lambda (the default) or memory
+    Backend string
+    // The user pool's password policy, so we can check passwords before sending them
+    PasswordPolicy chatlib.PasswordPolicy
+    // The user names the user pool accepts, and whether it ignores their case
+    UserNamePolicy chatlib.UserNamePolicy
+    // What authenticator apps call this app; defaults to Chat App
+    TOTPIssuer string
+    // The room the app starts in; defaults to general
+    DefaultRoom string
+    // Maps the function names used in this app to the deployed functions
+    Functions map[string]chatlib.FunctionConfig
 }
 
 // Configuration
@@ -44,7 +70,7 @@ var configuration Configuration
 func SetConfiguration() error {
     var myError error
 
-    if configuration == (Configuration{}) {
+    if configuration.Region == "" {
         // Get configuration values
         file, _ := os.Open("conf.json")
         decoder := json.NewDecoder(file)
@@ -67,19 +93,67 @@ func SetConfiguration() error {
     return myError
 }
 
-var client *lambda.Lambda
+// Where we keep users and posts
+var backend chatlib.Backend
 
-func getLambdaClient() (*lambda.Lambda)  {
-	if client == nil { // *(lambda.Lambda{}) {
-		// Create Lambda service client
-		sess := session.Must(session.NewSessionWithOptions(session.Options{
-			SharedConfigState: session.SharedConfigEnable,
-		}))
+// The passwords the user pool accepts
+func passwordPolicy() chatlib.PasswordPolicy {
+	return configuration.PasswordPolicy.OrDefault()
+}
+
+// The user names the user pool accepts
+func userNamePolicy() chatlib.UserNamePolicy {
+	return configuration.UserNamePolicy.OrDefault()
+}
+
+// What authenticator apps call this app
+func totpIssuer() string {
+	if configuration.TOTPIssuer == "" {
+		return chatlib.DefaultTOTPIssuer
+	}
+
+	return configuration.TOTPIssuer
+}
+
+// The room the app starts in
+func defaultRoom() string {
+	room := chatlib.NormalizeRoomName(configuration.DefaultRoom)
+
+	if room == "" {
+		return chatlib.DefaultRoom
+	}
+
+	return room
+}
+
+// Describe the problems with each field, one per line
+func validationMessage(validation *chatlib.ValidationError) string {
+	message := ""
+
+	for _, field := range []string{chatlib.UserNameField, chatlib.EmailField, chatlib.PasswordField} {
+		for _, problem := range validation.Fields[field] {
+			message += "\n  " + problem
+		}
+	}
 
-		client = lambda.New(sess, &aws.Config{Region: aws.String(configuration.Region)})
+	return message
+}
+
+// Check a new password against the policy, reporting every rule it breaks
+func checkNewPassword(password []byte) error {
+	var myError error
+
+	err := passwordPolicy().Validate(password)
+
+	if err != nil {
+		passwordError := err.(*chatlib.PasswordError)
+		myError = errors.New("Your password does not meet the password policy:\n  " + strings.Join(passwordError.Violations, "\n  ") + "\nTry again")
+		return myError
 	}
 
-	return client
+	fmt.Println("Password strength: " + passwordPolicy().Strength(password).String())
+
+	return myError
 }
 
 func clearScreen() {
@@ -123,93 +197,100 @@ func (t FormatAsDate) Equals(t2 FormatAsDate) bool {
 	return t.String() == t2.String()
 }
 
-type getPostsRequest struct {
-	SortBy     string
-	SortOrder  string
-	PostsToGet int
-}
+// Get the latest maxMessages posts, newest first (a negative maxMessages gets every one),
+// from the history, after adding the new posts to it.
+// If we can't get the new posts, list the ones we have.
+func getAllPosts(maxMessages int) ([]chatlib.Post, error) {
+	var myError error
 
-type getPostsResponseAlias struct {
-	S string
-}
+	if history == nil {
+		posts, err := backend.GetPosts(currentRoom, maxMessages)
 
-type getPostsResponseTimestamp struct {
-	S string
-}
+		if err != nil {
+			myError = errors.New("Error getting posts: " + err.Error())
+		} else {
+			indexPosts(posts)
+		}
 
-type getPostsResponseMessage struct {
-	S string
-}
+		return posts, myError
+	}
 
-type getPostsResponseData struct {
-	Alias     getPostsResponseAlias
-	Timestamp getPostsResponseTimestamp
-	Message   getPostsResponseMessage
-}
+	Debug.Println("Calling Sync")
+	result, syncErr := history.Sync(backend, currentRoom, configuration.MaxMessages)
 
-type getPostsResponseBody struct {
-	Result string                 `json:"result"`
-	Data   []getPostsResponseData `json:"data"`
-}
+	if syncErr == nil {
+		Debug.Println("Sync added " + strconv.Itoa(result.Added) + ", changed " + strconv.Itoa(result.Changed) + ", and deleted " + strconv.Itoa(result.Deleted) + " posts")
+	}
 
-type getPostsResponseHeaders struct {
-	ContentType string `json:"Content-Type"`
-}
+	posts, err := history.Posts(currentRoom, maxMessages)
+
+	switch {
+	case err != nil:
+		myError = errors.New("Error getting posts: " + err.Error())
+	case syncErr != nil && len(posts) == 0:
+		myError = errors.New("Error getting posts: " + syncErr.Error())
+	case syncErr != nil:
+		fmt.Println("Could not get the latest posts, so these are the ones we already had: " + syncErr.Error())
+		fmt.Println("")
+		indexPosts(posts)
+	default:
+		indexPosts(posts)
+	}
 
-type getPostsResponse struct {
-	StatusCode int                     `json:"statusCode"`
-	Headers    getPostsResponseHeaders `json:"headers"`
-	Body       getPostsResponseBody    `json:"body"`
+	return posts, myError
 }
 
-func getAllPosts(maxMessages int) (getPostsResponse, error) {
-	svc := getLambdaClient()
+// Every post we've gotten, newest first; see chatlib/history.go
+var history *chatlib.History
 
-	var myError error
-	var resp getPostsResponse
+// Every post we've gotten, to search; see chatlib/search.go
+var searchIndex = chatlib.NewSearchIndex()
 
-	// Get the latest maxMessages posts
-	request := getPostsRequest{"timestamp", "descending", maxMessages}
+// Add posts to the search index, and save it if they're new
+func indexPosts(posts []chatlib.Post) {
+	if searchIndex.Add(posts) == 0 {
+		return
+	}
 
-	payload, err := json.Marshal(request)
+	err := searchIndex.Save(searchIndexFile())
 
 	if err != nil {
-		myError = errors.New("Error marshalling GetPosts request")
-		return resp, myError
+		Debug.Println("Could not save search index: " + err.Error())
 	}
+}
 
-	result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("GetPosts"), Payload: payload})
+// Take a deleted post out of the search index
+func unindexPost(key chatlib.PostKey) {
+	searchIndex.Remove(key)
+
+	err := searchIndex.Save(searchIndexFile())
 
 	if err != nil {
-		myError = errors.New("Error calling GetPosts")
-		return resp, myError
+		Debug.Println("Could not save search index: " + err.Error())
 	}
+}
 
-	Debug.Println("")
-	Debug.Println("Raw response:")
-	Debug.Println(string(result.Payload))
-	Debug.Println("")
-
-	err = json.Unmarshal(result.Payload, &resp)
+// The room whose posts we list, and that we post to
+var currentRoom string
 
-	if err != nil {
-		myError = errors.New("Error unmarshalling GetPosts response")
-		return resp, myError
+// The prompt: who is signed in, and the room they're in
+func cursorFor(userName string) string {
+	if userName == "" {
+		userName = "anonymous"
 	}
 
-	return resp, myError
+	return "(" + userName + " #" + currentRoom + ")> "
 }
 
-func listAllPosts(resp getPostsResponse) error {
-	var myError error
+// The threads whose replies the user hid with thread ID
+var collapsedThreads = make(map[chatlib.PostKey]bool)
 
-	// Check the status code
-	if resp.StatusCode != 200 {
-		myError = errors.New("Error getting posts, StatusCode: " + strconv.Itoa(resp.StatusCode))
-		return myError
-	}
+// List posts, which are newest first, oldest first,
+// with replies indented under the posts they answer
+func listAllPosts(posts []chatlib.Post) error {
+	var myError error
 
-	numPosts := len(resp.Body.Data)
+	numPosts := len(posts)
 
 	if numPosts > 0 {
 		var origDate FormatAsDate
@@ -218,34 +299,47 @@ func listAllPosts(resp getPostsResponse) error {
 		// WAS: debugPrint(debug, msg)
 		Debug.Println(msg)
 
-		for i := range resp.Body.Data {
-			p := resp.Body.Data[len(resp.Body.Data)-i-1]
-			// Doug @ 4:45 PM PST <ID>:
-			// Where is the meeting today?
+		threads := chatlib.GroupThreads(posts)
+		collapsed := func(p chatlib.Post) bool { return collapsedThreads[p.Key()] }
+		known := knownUsers(posts)
 
-			// Convert date/time from UTC
-			numTime, err := strconv.ParseInt(p.Timestamp.S, 10, 64)
+		for i := range threads {
+			thread := threads[len(threads)-i-1]
 
-			if err == nil {
-				thisTime := time.Unix(numTime, 0)
+			for _, tp := range thread.Flatten(collapsed) {
+				p := tp.Post
+				indent := strings.Repeat("    ", tp.Depth)
+				// Doug @ 4:45 PM PST <ID>:
+				// Where is the meeting today?
+
+				// Convert date/time from UTC
+				numTime, err := strconv.ParseInt(p.Timestamp, 10, 64)
+
+				if err == nil {
+					thisTime := time.Unix(numTime, 0)
+
+					theDate := FormatAsDate(thisTime)
+					theTime := FormatAsTime(thisTime)
 
-				theDate := FormatAsDate(thisTime)
-				theTime := FormatAsTime(thisTime)
+					// If we have a new date, show it; replies stay with their thread
+					if tp.Depth == 0 && !origDate.Equals(theDate) {
+						fmt.Println("=== " + theDate.String() + " ===")
+						fmt.Println("")
 
-				// If we have a new date, show it
-				if !origDate.Equals(theDate) {
-					fmt.Println("=== " + theDate.String() + " ===")
-					fmt.Println("")
+						origDate = theDate
+					}
 
-					origDate = theDate
+					fmt.Println(indent + p.Alias + "@" + theTime.String() + " <" + p.Timestamp + ">" + editedSuffix(p) + threadSuffix(tp, thread) + ":")
+				} else {
+					fmt.Println(indent + p.Alias + "@??? <" + p.Timestamp + ">" + editedSuffix(p) + threadSuffix(tp, thread) + ":")
+				}
+
+				fmt.Println(indent + highlightMentions(p.Message, known))
+
+				if reactions := chatlib.ReactionSummary(p.ReactionCounts("")); reactions != "" {
+					fmt.Println(indent + reactions)
 				}
 
-				fmt.Println(p.Alias.S + "@" + theTime.String() + " <" + p.Timestamp.S + ">:")
-				fmt.Println(p.Message.S)
-				fmt.Println("")
-			} else {
-				fmt.Println(p.Alias.S + "@??? <" + p.Timestamp.S + ">:")
-				fmt.Println(p.Message.S)
 				fmt.Println("")
 			}
 		}
@@ -254,1160 +348,2559 @@ func listAllPosts(resp getPostsResponse) error {
 	return myError
 }
 
-type user struct {
-	UserName string
-	Password string
-}
+// Get the users that @names can mention: the ones the backend lists,
+// and, in case it can't, the authors of posts
+func knownUsers(posts []chatlib.Post) *chatlib.KnownUsers {
+	known := chatlib.NewKnownUsers(userNamePolicy())
 
-type userResponseHeaders struct {
-	ContentType string `json:"Content-Type"`
-}
+	names, err := backend.ListUserNames()
 
-type userResponseAuthenticationResult struct {
-	AccessToken  string
-	ExpiresIn    int
-	TokenType    string
-	RefreshToken string
-	IdToken      string
-}
+	if err != nil {
+		Debug.Println("Could not get user names: " + err.Error())
+	}
 
-type userResponseData struct {
-	ChallengeParameters  interface{} // {} is always returned
-	AuthenticationResult userResponseAuthenticationResult
-}
+	known.Add(names...)
 
-type userResponseBody struct {
-	Result string           `json:"result"`
-	Data   userResponseData `json:"data"`
-}
+	for _, p := range posts {
+		known.Add(p.Alias)
+	}
 
-type userResponseBodyFailureError struct {
-    Message string `json:"message"`
+	return known
 }
 
-type userResponseBodyFailure struct {
-	Result string `json:"result"`
-	Error  userResponseBodyFailureError `json:"error"`
-}
+// Show the mentions of known users in message in bold
+func highlightMentions(message string, known *chatlib.KnownUsers) string {
+	var text strings.Builder
 
-type userResponse struct {
-	StatusCode int                 `json:"statusCode"`
-	Headers    userResponseHeaders `json:"headers"`
-	Body       userResponseBody    `json:"body"`
-}
+	for _, part := range chatlib.SplitMentions(message, known.Has) {
+		if part.Mention != "" {
+			text.WriteString("\033[1m" + part.Text + "\033[0m")
+		} else {
+			text.WriteString(part.Text)
+		}
+	}
 
-type userResponseFailure struct {
-	StatusCode int                     `json:"statusCode"`
-	Headers    userResponseHeaders     `json:"headers"`
-	Body       userResponseBodyFailure `json:"body"`
+	return text.String()
 }
 
-func signInUser(userName string, password string) (string, error) {
-	svc := getLambdaClient()
+// Say how many replies a post has, whether they're hidden,
+// and, for replies whose parent we don't have, what they reply to
+func threadSuffix(tp chatlib.ThreadPost, thread *chatlib.Thread) string {
+	suffix := ""
 
-	var myError error
+	if tp.Depth == 0 && thread.Orphan {
+		suffix += " (reply to " + tp.Post.Parent.Alias + " <" + tp.Post.Parent.Timestamp + ">)"
+	}
 
-	Debug.Println("Creating payload for user " + userName)
+	switch {
+	case tp.Replies == 1:
+		suffix += " (1 reply"
+	case tp.Replies > 1:
+		suffix += " (" + strconv.Itoa(tp.Replies) + " replies"
+	default:
+		return suffix
+	}
 
-	user := user{userName, password}
+	if collapsedThreads[tp.Post.Key()] {
+		suffix += ", hidden"
+	}
 
-	payload, err := json.Marshal(user)
+	return suffix + ")"
+}
 
-	if err != nil {
-		myError = errors.New("Error marshalling SignInCognitoUser request")
-		return "", myError
+// Say when a post was last edited, after its ID
+func editedSuffix(p chatlib.Post) string {
+	if !p.Edited() {
+		return ""
 	}
 
-	Debug.Println("Signing in user:")
-	Debug.Println(string(payload))
-
-	result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("SignInCognitoUser"), Payload: payload})
+	numTime, err := strconv.ParseInt(p.EditedAt, 10, 64)
 
 	if err != nil {
-		myError = errors.New("Error calling SignInCognitoUser: " + err.Error())
-		return "", myError
+		return " (edited)"
 	}
 
-	Debug.Println("")
-	Debug.Println("Raw response:")
-	Debug.Println(string(result.Payload))
-	Debug.Println("")
+	return " (edited " + FormatAsTime(time.Unix(numTime, 0)).String() + ")"
+}
 
-	var resp userResponse
-	err = json.Unmarshal(result.Payload, &resp)
+func usage() {
+	fmt.Println("")
+	fmt.Println("Usage:")
+	fmt.Println("")
 
-	if err != nil {
-		myError = errors.New("Error unmarshalling SignInCognitoUser response")
-		return "", myError
-	}
+	// Re-enable once the functionality is added
+	//fmt.Println("go run PostApp.go [-t TIMEZONE] [-r REGION] [-d] [-h]")
 
-	// Did we not get a 200?
-	if resp.StatusCode != 200 {
-        var respFailure userResponseFailure
-        err = json.Unmarshal(result.Payload, &respFailure)
+	fmt.Println("go run PostApp.go [-r REGION] [-backend lambda|memory] [-d] [-h] [search QUERY]")
+	fmt.Println("")
 
-        if err != nil {
-            myError = errors.New("Got status code: " + strconv.Itoa(resp.StatusCode) + " trying to sign in user")
-            return "", myError
-        } else {
-            myError = errors.New("Got error: " + respFailure.Body.Error.Message + " trying to sign in user")
-            return "", myError
-        }
-	}
+	// Re-enable once the functionality is added
+	//fmt.Println("If TIMEZONE is omitted, defaults to UTC")
+	fmt.Println("If REGION is omitted, defaults to us-west-2")
+	fmt.Println("Use -backend memory to try the app without any AWS resources;")
+	fmt.Println("everything is lost when you quit")
 
-	if resp.Body.Result != "success" {
-		var respFailure userResponseFailure
+	fmt.Println("Use -d (debug) to display additional information")
+	fmt.Println("Use -h (help) to display this message and quit")
+	fmt.Println("Use search QUERY to search the posts, then quit, such as search \"from:bob lunch\"")
 
-		err = json.Unmarshal(result.Payload, &respFailure)
+	os.Exit(0)
+}
 
-		if err != nil {
-			myError = errors.New("Error unmarshalling SignInCognitoUser response")
-			return "", myError
-		}
+func getStringValue(scanner *bufio.Scanner, prompt string) string {
+	fmt.Println(prompt)
+	scanner.Scan()
+	value := scanner.Text()
+	value = strings.TrimSpace(value)
 
-		if respFailure.Body.Error.Message != "" {
-			myError = errors.New("Failed to sign in Cognito user. Error: " + respFailure.Body.Error.Message)
-			return "", myError
-		}
+	return value
+}
 
-		return "", myError
-	}
+// Get a password, or other secret, as bytes we can zero once we're done with it
+func getSecretValue(scanner *bufio.Scanner, prompt string) []byte {
+	fmt.Println(prompt)
+	scanner.Scan()
+	value := bytes.TrimSpace(scanner.Bytes())
+
+	// The scanner reuses its buffer, so keep our own copy
+	secret := make([]byte, len(value))
+	copy(secret, value)
+	chatlib.ZeroBytes(value)
+
+	return secret
+}
 
-	return resp.Body.Data.AuthenticationResult.AccessToken, myError
+func notifySignedIn(registered bool) {
+	if registered {
+		fmt.Println("You are already signed in, which means you are already registered")
+		fmt.Println("If you want to register or sign in as another user,")
+		fmt.Println("you must sign out first and then register or sign in")
+		fmt.Println("")
+	} else {
+		fmt.Println("You are already signed in")
+		fmt.Println("If you want to sign in as another user,")
+		fmt.Println("you must sign out first and then sign in")
+		fmt.Println("")
+	}
 }
 
-type deleteAccountRequest struct {
-	AccessToken string
+// True if the backend can set up an authenticator app
+func mfaSupported() bool {
+	return backend.Supports("AssociateCognitoSoftwareToken") && backend.Supports("VerifyCognitoSoftwareToken") &&
+		backend.Supports("SetCognitoUserMFAPreference")
 }
 
-func deleteUserAccount(accessToken string) error {
-	svc := getLambdaClient()
+// True if the backend can do function; otherwise tell them it isn't there.
+// The menu leaves out what needs a function that isn't, but they can still type it.
+func available(function string) bool {
+	if backend.Supports(function) {
+		return true
+	}
 
-	var myError error
+	fmt.Println("That isn't available, since the " + function + " Lambda function isn't deployed")
 
-	token := deleteAccountRequest{accessToken}
+	return false
+}
 
-	payload, err := json.Marshal(token)
+// List the posts, then the signed-in user's posts in the room that haven't been sent
+func getAndListAllPosts(maxMessages int, userName string) {
+	Debug.Println("Calling getAllPosts")
+	posts, err := getAllPosts(maxMessages)
 
-	if err != nil {
-		myError = errors.New("Error marshalling request for DeleteCognitoUser")
-		return myError
+	if err == nil {
+		listAllPosts(posts)
+	} else {
+		fmt.Println("Could not get posts: " + err.Error())
 	}
 
-	//payload = []byte(payload)
+	for _, p := range outbox.For(userName) {
+		if p.Room == currentRoom {
+			fmt.Println(p.Alias + " (not sent yet, " + p.State() + "; see /outbox):")
+			fmt.Println(p.Message)
+		}
+	}
+}
 
-	Debug.Println("Calling DeleteCognitoUser")
+type logInUserResult struct {
+	userName    string
+	accessToken  string
+	idToken      string
+	refreshToken string
+}
 
-	result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("DeleteCognitoUser"), Payload: payload})
+// How many times a user can get a challenge wrong before we give up on signing in
+const maxChallengeAttempts = 3
 
-	if err != nil {
-		myError = errors.New("Error calling DeleteCognitoUser")
-		return myError
+// Get the answer to a challenge: a code, or a new password that meets the policy
+func answerChallenge(scanner *bufio.Scanner, challenge *chatlib.Challenge) ([]byte, error) {
+	if !challenge.WantsPassword() {
+		return []byte(getStringValue(scanner, challenge.Prompt())), nil
 	}
 
-	Debug.Println("")
-	Debug.Println("Raw response:")
-	Debug.Println(string(result.Payload))
-	Debug.Println("")
+	fmt.Println(challenge.Prompt())
+	password := getSecretValue(scanner, "Enter a new password, with "+passwordPolicy().String())
+	fmt.Println("")
 
-	var resp userResponse
-	err = json.Unmarshal(result.Payload, &resp)
+	err := checkNewPassword(password)
 
 	if err != nil {
-		myError = errors.New("Error unmarshalling DeleteCognitoUser response")
-		return myError
-	}
-
-	// Did we not get a 200?
-	if resp.StatusCode != 200 {
-		myError = errors.New("Expected a 200 status code, but got: " + strconv.Itoa(resp.StatusCode))
+		chatlib.ZeroBytes(password)
+		return nil, err
 	}
 
-	return myError
+	return password, nil
 }
 
-type deletePostRequest struct {
-	AccessToken     string
-	TimestampOfPost string
-}
+// Sign in, then answer any challenges, such as an MFA code,
+// until the user pool gives us tokens
+func signIn(scanner *bufio.Scanner, name string, password []byte) (logInUserResult, error) {
+	var result logInUserResult
 
-func deletePost(accessToken string, timestamp string) error {
-	svc := getLambdaClient()
+	Debug.Println("Calling SignIn")
+	signedIn, err := backend.SignIn(name, password)
 
-	var myError error
+	attempts := 0
 
-	req := deletePostRequest{accessToken, timestamp}
+	for err == nil && signedIn.Challenge != nil {
+		challenge := signedIn.Challenge
+		Debug.Println("Got challenge " + challenge.Name)
 
-	payload, err := json.Marshal(req)
+		answer, answerErr := answerChallenge(scanner, challenge)
 
-	Debug.Println("Raw request:")
-	Debug.Println(string(payload))
-	Debug.Println("")
+		if answerErr == nil {
+			Debug.Println("Calling RespondToChallenge")
+			signedIn, answerErr = backend.RespondToChallenge(*challenge, answer)
+			chatlib.ZeroBytes(answer)
+		}
 
-	if err != nil {
-		myError = errors.New("Error marshalling request for DeletePost")
-		return myError
-	}
+		if answerErr != nil {
+			attempts++
+			fmt.Println(answerErr.Error())
+			fmt.Println("")
 
-	Debug.Println("Calling DeletePost")
+			// The same challenge is still open, unless it expired
+			code := chatlib.ErrorCode(answerErr)
+
+			if attempts >= maxChallengeAttempts || (code != "" && code != "CodeMismatchException" && code != "InvalidPasswordException") {
+				err = answerErr
+				break
+			}
+
+			signedIn.Challenge = challenge
+			continue
+		}
 
-	result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("DeletePost"), Payload: payload})
+		attempts = 0
+	}
 
 	if err != nil {
-		myError = errors.New("Error calling DeletePost")
-		return myError
+		return result, err
 	}
 
-	Debug.Println("")
-	Debug.Println("Raw response:")
-	Debug.Println(string(result.Payload))
-	Debug.Println("")
+	result.userName = name
+	result.accessToken = signedIn.Tokens.AccessToken
+	result.idToken = signedIn.Tokens.IdToken
+	result.refreshToken = signedIn.Tokens.RefreshToken
 
-	var resp userResponse
-	err = json.Unmarshal(result.Payload, &resp)
+	return result, nil
+}
 
-	if err != nil {
-		myError = errors.New("Error unmarshalling response from DeletePost")
-		return myError
-	}
+func logInUser(scanner *bufio.Scanner) (logInUserResult, error) {
+	var myError error
 
-	// Did we get anything but a 200?
-	if resp.StatusCode != 200 {
-		var respFailure userResponseFailure
-		err = json.Unmarshal(result.Payload, &respFailure)
+	// Get user name, as it was when they registered
+	name := userNamePolicy().Normalize(getStringValue(scanner, "Enter your user name"))
+	fmt.Println("")
+	password := getSecretValue(scanner, "Enter your password")
+	fmt.Println("")
 
-		if err != nil {
-			myError = errors.New(err.Error())
-			return myError
-		}
+	result, err := signIn(scanner, name, password)
+	chatlib.ZeroBytes(password)
 
-		myError = errors.New(respFailure.Body.Error.Message)
-		return myError
+	// err means something went wrong;
+	// err.Error() has details
+	if err != nil {
+		myError = errors.New("Could not sign in user: " + err.Error())
 	}
 
-	return myError
+	return result, myError
 }
 
-type postRequest struct {
-	AccessToken string
-	Message     string
-}
+// Where we save pending registrations and password resets
+func pendingFile() string {
+	if configuration.PendingFile == "" {
+		return "pending.json"
+	}
 
-type postResponseBody struct {
-	Result string
+	return configuration.PendingFile
 }
 
-type finishSigninResponse struct {
-	StatusCode int                 `json:"statusCode"`
-	Headers    userResponseHeaders `json:"headers"`
-	Body       postResponseBody    `json:"body"`
-}
+// Where we save which mentions each user has seen
+func mentionsFile() string {
+	if configuration.MentionsFile == "" {
+		return "mentions.json"
+	}
 
-type defaultHeaders struct {
-    ContentType string `json:"Content-Type"`
+	return configuration.MentionsFile
 }
 
-type defaultError struct {
-    Message string `json:"message"`
+// Where we keep the posts we haven't sent yet
+func outboxFile() string {
+	if configuration.OutboxFile == "" {
+		return "outbox.json"
+	}
+
+	return configuration.OutboxFile
 }
 
-type defaultFailureBody struct {
-    Result string `json:"result"`
-    Error defaultError `json:"error"`
+// Where we keep the history of posts
+func historyFile() string {
+	if configuration.HistoryFile == "" {
+		return "history.db"
+	}
+
+	return configuration.HistoryFile
 }
 
-type defaultFailureResponse struct {
-    StatusCode int `json:"statusCode"`
-    Headers defaultHeaders `json:"headers"`
-    Body defaultFailureBody `json:"body"`
+// Where we save the posts we search
+func searchIndexFile() string {
+	if configuration.SearchIndexFile == "" {
+		return "search.json"
+	}
+
+	return configuration.SearchIndexFile
 }
 
-func postFromSignedInUser(accessToken string, message string) error {
-	svc := getLambdaClient()
+func savePendingFlows(flows *chatlib.PendingFlows) {
+	err := flows.Save(pendingFile())
 
-	var myError error
+	if err != nil {
+		fmt.Println("Could not save where you are in registering or resetting your password: " + err.Error())
+	}
+}
 
-	request := postRequest{accessToken, message}
+// Show the menu entry for a two-step flow
+func flowPrompt(number string, name string, flow chatlib.Flow, start string, finish string) string {
+	if flow.Pending() {
+		resend := name + " resend: send a new code, "
 
-	payload, err := json.Marshal(request)
+		// Resetting again sends a new code, but registering needs its own function
+		if flow.Kind == chatlib.RegistrationFlow && !backend.Supports("ResendPendingCognitoUserCode") {
+			resend = ""
+		}
 
-	if err != nil {
-		myError = errors.New("Error marshalling request for AddPost")
-		return myError
+		return number + " (or " + name + " finish): " + finish + " for " + flow.UserName +
+			" (" + resend + name + " cancel: start over)"
 	}
 
-	Debug.Println("Raw request to AddPost:")
-	Debug.Println(string(payload))
+	return number + " (or " + name + "): " + start
+}
+
+// First step of registering: create the user, which sends them a code
+func startRegistration(scanner *bufio.Scanner, flow *chatlib.Flow) error {
+	var myError error
 
-	result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("AddPost"), Payload: payload})
+	err := flow.CanStart()
 
 	if err != nil {
-		myError = errors.New("Error calling AddPost")
-		return myError
+		return err
 	}
 
-	Debug.Println("")
-	Debug.Println("Raw response from AddPost:")
-	Debug.Println(string(result.Payload))
-	Debug.Println("")
+	Debug.Println("Calling StartRegistration")
 
-	var resp finishSigninResponse
+	name := getStringValue(scanner, "Enter your user name")
+	password := getSecretValue(scanner, "Enter a password with "+passwordPolicy().String())
+	defer chatlib.ZeroBytes(password)
+	email := getStringValue(scanner, "Enter your email address")
+	fmt.Println("")
 
-	err = json.Unmarshal(result.Payload, &resp)
+	// Check everything at once, so they can fix everything at once
+	name, email, err = chatlib.ValidateRegistration(userNamePolicy(), passwordPolicy(), name, email, password)
 
 	if err != nil {
-		myError = errors.New("Error unmarshalling AddPost response")
+		myError = errors.New("Could not start registering user:" + validationMessage(err.(*chatlib.ValidationError)) + "\nTry again")
 		return myError
 	}
 
-	// Make sure we got a 200 and success
-	if resp.StatusCode != 200 {
-		// Try to get the error
-        var respFailure defaultFailureResponse
-
-        err = json.Unmarshal(result.Payload, &respFailure)
+	fmt.Println("Password strength: " + passwordPolicy().Strength(password).String())
 
-        if err != nil {
-            myError = errors.New("Got unexpected status code: " + strconv.Itoa(resp.StatusCode))
-            return myError
-        } else {
-            myError = errors.New("Message not posted: " + respFailure.Body.Error.Message)
-            return myError
-        }
-	}
+	delivery, err := backend.StartRegistration(name, password, email)
 
-	if resp.Body.Result != "success" {
-		myError = errors.New("Got unexpected result: " + resp.Body.Result)
+	if err != nil {
+		myError = errors.New("Could not start registering user: " + err.Error())
 		return myError
 	}
 
-	return myError
-}
-
-type startRegisterUserRequest struct {
-	UserName string
-	Password string
-	Email    string
-}
+	fmt.Println("We sent a confirmation code to " + sentTo(delivery, email) + "; enter it to finish registering")
 
-type startRegisterUserResponseData struct {
-	UserConfirmed bool
+	return flow.Start(name, email)
 }
 
-type startRegisterUserResponseBody struct {
-	Result string                        `json:"result"`
-	Data   startRegisterUserResponseData `json:"data"`
-}
+// Second step of registering: confirm the code, then sign them in
+func finishRegistration(scanner *bufio.Scanner, flow *chatlib.Flow) (logInUserResult, error) {
+	var result logInUserResult
+	var myError error
 
-type registerUserResponse struct {
-	StatusCode int                           `json:"statusCode"`
-	Headers    userResponseHeaders           `json:"headers"`
-	Body       startRegisterUserResponseBody `json:"body"`
-}
+	name := flow.UserName
 
-type registerUserResponseError struct {
-	Message    string `json:"message"`
-	Code       string
-	Time       string
-	RequestId  string
-	StatusCode int
-	Retryable  bool
-	RetryDelay float64
-}
+	code := getStringValue(scanner, "Enter the confirmation code sent to "+flow.Email)
+	fmt.Println("")
 
-type registerUserResponseFailureBody struct {
-	Result string                    `json:"result"`
-	Error  registerUserResponseError `json:"error"`
-}
+	Debug.Println("Calling FinishRegistration")
 
-type registerUserResponseFailure struct {
-	StatusCode int                             `json:"statusCode"`
-	Headers    userResponseHeaders             `json:"headers"`
-	Body       registerUserResponseFailureBody `json:"body"`
-}
+	err := backend.FinishRegistration(name, code)
 
-func startRegisterUser(name string, password string, email string) error {
-	svc := getLambdaClient()
+	if err != nil {
+		myError = errors.New("Could not finish registering user: " + err.Error())
+		return result, myError
+	}
 
-	var myError error
+	flow.Finish()
 
-	request := startRegisterUserRequest{name, password, email}
+	// Sign them in; we didn't keep their password, so ask for it again
+	fmt.Println("You are registered")
+	password := getSecretValue(scanner, "Enter your password to sign in")
+	fmt.Println("")
 
-	payload, err := json.Marshal(request)
+	result, err = signIn(scanner, name, password)
+	chatlib.ZeroBytes(password)
 
 	if err != nil {
-		myError = errors.New("Error marshalling StartAddingPendingCognitoUser request")
-		return myError
+		// They're registered, they just aren't signed in
+		myError = errors.New("You are registered, but could not sign in: " + err.Error())
 	}
 
-	Debug.Println("Getting info about user:")
-	Debug.Println(string(payload))
+	return result, myError
+}
+
+// First step of resetting a password: send the user a code
+func startReset(scanner *bufio.Scanner, flow *chatlib.Flow) error {
+	var myError error
 
-	result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("StartAddingPendingCognitoUser"), Payload: payload})
+	err := flow.CanStart()
 
 	if err != nil {
-		myError = errors.New("Error calling StartAddingPendingCognitoUser")
-		return myError
+		return err
 	}
 
-	Debug.Println("")
-	Debug.Println("Raw response:")
-	Debug.Println(string(result.Payload))
-	Debug.Println("")
+	name := userNamePolicy().Normalize(getStringValue(scanner, "Enter your user name:"))
+	fmt.Println("")
+
+	Debug.Println("Calling StartPasswordReset")
+	Debug.Println("For user " + name)
 
-	var resp registerUserResponse
-	err = json.Unmarshal(result.Payload, &resp)
+	delivery, err := backend.StartPasswordReset(name)
 
 	if err != nil {
-		myError = errors.New("Error unmarshalling StartAddingPendingCognitoUser response")
+		myError = errors.New("Could not reset password: " + err.Error())
 		return myError
 	}
 
-	if resp.StatusCode == 200 {
-		// Got a valid response, was it success?
-		if resp.Body.Result == "success" {
-			return myError
-		} else {
-			myError = errors.New("Got result: " + resp.Body.Result)
-			return myError
-		}
-	} else {
-		var resp registerUserResponseFailure
-
-		err = json.Unmarshal(result.Payload, &resp)
-
-        if err != nil {
-            myError = errors.New("Could not register user")
-            return myError
-        } else {
-			myError = errors.New("Got status code: " + strconv.Itoa(resp.StatusCode) + " and error message: " +resp.Body.Error.Message)
-			return myError
-		}
-	}
+	fmt.Println("We sent a confirmation code to " + sentTo(delivery, "your email address") + "; enter it to finish resetting your password")
 
-	return myError
+	return flow.Start(name, "")
 }
 
-type finishRegisterRequest struct {
-	UserName         string
-	ConfirmationCode string
-}
-
-type finishRegisterResponseBody struct {
-	Result string `json:"result"`
-}
-
-type finishRegisterResponse struct {
-	StatusCode int                        `json:"statusCode"`
-	Headers    userResponseHeaders        `json:"headers"`
-	Body       finishRegisterResponseBody `json:"body"`
-}
+// Second step of resetting a password: set the new password, then sign them in with it
+func finishReset(scanner *bufio.Scanner, flow *chatlib.Flow) (logInUserResult, error) {
+	var result logInUserResult
+	var myError error
 
-func finishRegisterUser(name string, code string) error {
-	svc := getLambdaClient()
+	name := flow.UserName
 
-	var myError error
+	Debug.Println("Calling FinishPasswordReset")
 
-	request := finishRegisterRequest{name, code}
+	// Get confirmation code and new password
+	cc := getStringValue(scanner, "Enter the confirmation code")
+	fmt.Println("")
+	pw := getSecretValue(scanner, "Enter your new password, with "+passwordPolicy().String())
+	fmt.Println("")
+	defer chatlib.ZeroBytes(pw)
 
-	payload, err := json.Marshal(request)
+	// The flow is still pending, so they can try again
+	err := checkNewPassword(pw)
 
 	if err != nil {
-		myError = errors.New("Error marshalling request for FinishAddingPendingCognitoUser")
-		return myError
+		return result, err
 	}
 
-	Debug.Println("Raw payload to finish registering user:")
-	Debug.Println(string(payload))
-
-	result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("FinishAddingPendingCognitoUser"), Payload: payload})
+	err = backend.FinishPasswordReset(name, cc, pw)
 
 	if err != nil {
-		myError = errors.New("Error calling FinishAddingPendingCognitoUser")
-		return myError
+		myError = errors.New("Could not reset password: " + err.Error())
+		return result, myError
 	}
 
-	Debug.Println("")
-	Debug.Println("Raw response:")
-	Debug.Println(string(result.Payload))
-	Debug.Println("")
+	Debug.Println("Successfully reset password")
+	flow.Finish()
 
-	var resp finishRegisterResponse
-	err = json.Unmarshal(result.Payload, &resp)
+	result, err = signIn(scanner, name, pw)
 
 	if err != nil {
-		myError = errors.New("Error unmarshalling FinishAddingPendingCognitoUser response")
-		return myError
+		myError = errors.New("Your password is reset, but could not sign in: " + err.Error())
 	}
 
-	if resp.Body.Result != "success" {
-		var respFailure registerUserResponseFailure
-
-		err = json.Unmarshal(result.Payload, &respFailure)
+	return result, myError
+}
 
-		if err != nil {
-			myError = errors.New("Error unmarshalling FinishAddingPendingCognitoUser response")
-			return myError
-		}
+// Send a new code for a pending flow
+func resendCode(flow *chatlib.Flow) error {
+	var delivery chatlib.CodeDelivery
+	var err error
 
-		if respFailure.Body.Error.Message != "" {
-			myError = errors.New(respFailure.Body.Error.Message)
-			return myError
-		}
+	if !flow.Pending() {
+		return errors.New("There is no " + string(flow.Kind) + " to send a new code for")
 	}
 
-	return myError
-}
+	if flow.Kind == chatlib.RegistrationFlow {
+		Debug.Println("Calling ResendConfirmationCode")
+		delivery, err = backend.ResendConfirmationCode(flow.UserName)
+	} else {
+		// Starting again sends a new code
+		Debug.Println("Calling StartPasswordReset")
+		delivery, err = backend.StartPasswordReset(flow.UserName)
+	}
 
-type resetPasswordRequest struct {
-	UserName string
-}
+	if err != nil {
+		return errors.New("Could not send a new code: " + err.Error())
+	}
 
-type resetPasswordResponseHeaders struct {
-	ContentType string `json:"Conten-Type"`
-}
+	fmt.Println("We sent a new confirmation code for " + flow.UserName + " to " + sentTo(delivery, "their email address"))
 
-type resetPasswordResponseCodeDeliveryDetails struct {
-	Destination       string
-	DeliveryMechanism string
-	AttributeName     string
+	return nil
 }
 
-type resetPasswordResponseData struct {
-	CodeDeliveryDetails resetPasswordResponseCodeDeliveryDetails
-}
+// Where the backend says it sent a code, masked, or otherwise if it didn't say
+func sentTo(delivery chatlib.CodeDelivery, otherwise string) string {
+	destination := delivery.MaskedDestination()
 
-type resetPasswordResponseBody struct {
-	Result string                    `json:"result"`
-	Data   resetPasswordResponseData `json:"data"`
-}
+	if destination == "" {
+		return otherwise
+	}
 
-type resetPasswordResponse struct {
-	StatusCode int                       `json:"statusCode"`
-	Headers    userResponseHeaders       `json:"headers"`
-	Body       resetPasswordResponseBody `json:"body"`
+	return destination
 }
 
-func startResetPassword(userName string) error {
-	svc := getLambdaClient()
-
+// Email the user names registered with an email address.
+// We only show where it went, so nobody learns who has an account.
+func forgotUserName(scanner *bufio.Scanner) error {
 	var myError error
 
-	// Create request
-	request := resetPasswordRequest{userName}
+	email := chatlib.NormalizeEmail(getStringValue(scanner, "Enter the email address you registered with"))
+	fmt.Println("")
 
-	payload, err := json.Marshal(request)
+	problems := chatlib.CheckEmail(email)
 
-	if err != nil {
-		myError = errors.New("Error marshalling StartChangingForgottenCognitoUserPassword request")
+	if len(problems) > 0 {
+		myError = errors.New(strings.Join(problems, "\n"))
 		return myError
 	}
 
-	Debug.Println("Raw request for resetting password:")
-	Debug.Println(string(payload))
+	Debug.Println("Calling RemindUserName")
 
-	result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("StartChangingForgottenCognitoUserPassword"), Payload: payload})
+	delivery, err := backend.RemindUserName(email)
 
 	if err != nil {
-		myError = errors.New("Error calling StartChangingForgottenCognitoUserPassword")
+		myError = errors.New("Could not send your user name: " + err.Error())
 		return myError
 	}
 
-	Debug.Println("")
-	Debug.Println("Raw response:")
-	Debug.Println(string(result.Payload))
-	Debug.Println("")
+	fmt.Println("If that address belongs to an account, we sent its user name to " + sentTo(delivery, chatlib.MaskDestination(email)))
+
+	return myError
+}
+
+// Add an authenticator app: show its QR code, then check its first code
+func setUpMFA(scanner *bufio.Scanner, accessToken string, userName string) error {
+	var myError error
+
+	Debug.Println("Calling StartTOTPEnrollment")
 
-	var resp resetPasswordResponse
-	err = json.Unmarshal(result.Payload, &resp)
+	secret, err := backend.StartTOTPEnrollment(accessToken)
 
 	if err != nil {
-		myError = errors.New("Error unmarshalling StartChangingForgottenCognitoUserPassword response")
+		myError = errors.New("Could not set up an authenticator app: " + err.Error())
 		return myError
 	}
 
-	if resp.StatusCode == 200 {
-		if resp.Body.Result == "success" {
-			return myError
-		} else {
-			myError = errors.New("Result: " + resp.Body.Result)
-			return myError
-		}
+	qr, err := chatlib.QRCodeHalfBlocks(chatlib.TOTPURI(totpIssuer(), userName, secret))
+
+	if err == nil {
+		fmt.Println("Scan this QR code with your authenticator app:")
+		fmt.Println("")
+		fmt.Print(qr)
+		fmt.Println("")
+		fmt.Println("Or add this key to it: " + chatlib.FormatTOTPSecret(secret))
 	} else {
-		var respFailure registerUserResponseFailure
+		fmt.Println("Add this key to your authenticator app: " + chatlib.FormatTOTPSecret(secret))
+	}
 
-		err = json.Unmarshal(result.Payload, &respFailure)
+	fmt.Println("")
 
-		if err != nil {
-			myError = errors.New("Error unmarshalling StartChangingForgottenCognitoUserPassword failure response")
-			return myError
+	for attempts := 1; ; attempts++ {
+		code := strings.Join(strings.Fields(getStringValue(scanner, "Enter the code your app shows")), "")
+		fmt.Println("")
+
+		Debug.Println("Calling FinishTOTPEnrollment")
+
+		err = backend.FinishTOTPEnrollment(accessToken, code)
+
+		if err == nil {
+			break
 		}
 
-		if respFailure.Body.Error.Message != "" {
-			myError = errors.New(respFailure.Body.Error.Message)
+		// A code from the wrong period, or a typo; the secret is still good
+		if attempts >= maxChallengeAttempts || chatlib.ErrorCode(err) != "EnableSoftwareTokenMFAException" {
+			myError = errors.New("Could not set up an authenticator app: " + err.Error() + "\nEnter mfa to start over")
 			return myError
 		}
 
+		fmt.Println("That code didn't match; wait for the next one and try again")
 	}
 
+	fmt.Println("Your authenticator app is set up; signing in now asks for a code from it")
+
 	return myError
 }
 
-type finishResetRequest struct {
-	UserName         string
-	ConfirmationCode string
-	NewPassword      string
-}
+// Turn off MFA, once the user confirms it
+func disableMFA(scanner *bufio.Scanner, accessToken string) error {
+	var myError error
 
-func finishResetPassword(userName string, cc string, pw string) error {
-	svc := getLambdaClient()
+	answer := getStringValue(scanner, "Turn off MFA, so signing in only takes your password? (y/n)")
+	fmt.Println("")
 
-	var myError error
+	if answer != "y" && answer != "Y" {
+		fmt.Println("MFA is still on")
+		return myError
+	}
 
-	// Create request
-	request := finishResetRequest{userName, cc, pw}
+	Debug.Println("Calling DisableMFA")
 
-	payload, err := json.Marshal(request)
+	err := backend.DisableMFA(accessToken)
 
 	if err != nil {
-		myError = errors.New("Error marshalling request for FinishChangingForgottenCognitoUserPassword")
+		myError = errors.New("Could not turn off MFA: " + err.Error())
 		return myError
 	}
 
-	Debug.Println("Raw request for final step of resetting password:")
-	Debug.Println(string(payload))
+	fmt.Println("MFA is off; you can set up an authenticator app again with mfa setup")
+
+	return myError
+}
 
-	result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("FinishChangingForgottenCognitoUserPassword"), Payload: payload})
+// Posts we haven't sent yet; see chatlib/outbox.go
+var outbox = &chatlib.Outbox{}
+
+func saveOutbox() {
+	err := outbox.Save(outboxFile())
 
 	if err != nil {
-		myError = errors.New("Error calling FinishChangingForgottenCognitoUserPassword")
-		return myError
+		fmt.Println(err.Error())
 	}
+}
 
-	Debug.Println("")
-	Debug.Println("Raw response:")
-	Debug.Println(string(result.Payload))
-	Debug.Println("")
+// Send the signed-in user's posts that are waiting in the outbox, oldest first.
+// Returns how many were sent.
+func sendOutbox(accessToken string, userName string) (int, error) {
+	Debug.Println("Sending outbox")
+	sent, err := outbox.Send(backend, accessToken, userName)
+	saveOutbox()
 
-	var resp resetPasswordResponse
-	err = json.Unmarshal(result.Payload, &resp)
+	return sent, err
+}
 
-	if err != nil {
-		myError = errors.New("Error unmarshalling FinishChangingForgottenCognitoUserPassword response")
-		return myError
+// Put the message in the outbox, then send it, after any posts that are waiting
+func postMessage(scanner *bufio.Scanner, accessToken string, userName string) error {
+	var myError error
+
+	// Query for message to post
+	message := getStringValue(scanner, "Enter the message to post")
+
+	Debug.Println("Calling postMessage")
+
+	// Save it first, so it isn't lost if we can't send it
+	post := outbox.Add(userName, currentRoom, message)
+	saveOutbox()
+
+	sent, err := sendOutbox(accessToken, userName)
+
+	waiting, ok := outbox.Get(userName, post.ID)
+
+	switch {
+	case !ok && sent > 1:
+		fmt.Println("Message posted, after " + strconv.Itoa(sent-1) + " earlier posts from your outbox")
+	case !ok:
+		fmt.Println("Message posted")
+	case waiting.Failed:
+		myError = errors.New("Message not posted: " + waiting.LastError + "\nIt's in your outbox; enter /outbox to retry or discard it")
+	default:
+		myError = errors.New("Could not reach the server, so the message is in your outbox; it's sent once the server can be reached: " + err.Error())
 	}
 
-	if resp.StatusCode == 200 {
-		if resp.Body.Result == "success" {
-			return myError
-		} else {
-			myError = errors.New("Result: " + resp.Body.Result)
-			return myError
-		}
-	} else {
-		var respFailure registerUserResponseFailure
+	return myError
+}
 
-		err = json.Unmarshal(result.Payload, &respFailure)
+// List the signed-in user's posts that haven't been sent, numbered for /outbox retry and discard
+func listOutbox(userName string) {
+	posts := outbox.For(userName)
 
-		if err != nil {
-			myError = errors.New("Error unmarshalling FinishChangingForgottenCognitoUserPassword failure response")
-			return myError
+	if len(posts) == 0 {
+		fmt.Println("Your outbox is empty")
+		return
+	}
+
+	for i, p := range posts {
+		line := strconv.Itoa(i+1) + ": #" + p.Room + " " + p.State()
+
+		if numTime, err := strconv.ParseInt(p.Created, 10, 64); err == nil {
+			line += ", written " + FormatAsDate(time.Unix(numTime, 0)).String() + " " + FormatAsTime(time.Unix(numTime, 0)).String()
 		}
 
-		if respFailure.Body.Error.Message != "" {
-			myError = errors.New(respFailure.Body.Error.Message)
-			return myError
+		if p.Attempts > 0 {
+			line += " (" + strconv.Itoa(p.Attempts) + " tries: " + p.LastError + ")"
 		}
+
+		fmt.Println(line)
+		fmt.Println("    " + p.Message)
 	}
 
-	return myError
+	fmt.Println("")
+	fmt.Println("Pending posts are sent once the server can be reached.")
+	fmt.Println("Enter /outbox retry N to send post N now, or /outbox discard N to throw it away")
 }
 
-func usage() {
-	fmt.Println("")
-	fmt.Println("Usage:")
-	fmt.Println("")
+// Retry or discard the signed-in user's post number n in listOutbox
+func changeOutbox(accessToken string, userName string, action string, n string) error {
+	var myError error
 
-	// Re-enable once the functionality is added
-	//fmt.Println("go run PostApp.go [-t TIMEZONE] [-r REGION] [-d] [-h]")
+	posts := outbox.For(userName)
 
-	fmt.Println("go run PostApp.go [-r REGION] [-d] [-h]")
-	fmt.Println("")
+	if len(posts) == 0 {
+		myError = errors.New("Your outbox is empty")
+		return myError
+	}
 
-	// Re-enable once the functionality is added
-	//fmt.Println("If TIMEZONE is omitted, defaults to UTC")
-	fmt.Println("If REGION is omitted, defaults to us-west-2")
+	i, err := strconv.Atoi(n)
 
-	fmt.Println("Use -d (debug) to display additional information")
-	fmt.Println("Use -h (help) to display this message and quit")
+	if err != nil || i < 1 || i > len(posts) {
+		myError = errors.New("Enter the number of a post in your outbox, from 1 to " + strconv.Itoa(len(posts)))
+		return myError
+	}
 
-	os.Exit(0)
-}
+	post := posts[i-1]
 
-func getStringValue(scanner *bufio.Scanner, prompt string) string {
-	fmt.Println(prompt)
-	scanner.Scan()
-	value := scanner.Text()
-	value = strings.TrimSpace(value)
+	switch action {
+	case "discard":
+		outbox.Discard(userName, post.ID)
+		saveOutbox()
+		fmt.Println("Discarded your post to #" + post.Room)
+	case "retry":
+		outbox.Retry(userName, post.ID)
+		saveOutbox()
 
-	return value
+		sent, err := sendOutbox(accessToken, userName)
+
+		if waiting, ok := outbox.Get(userName, post.ID); ok {
+			if waiting.Failed {
+				myError = errors.New("Message not posted: " + waiting.LastError)
+			} else {
+				myError = errors.New("Could not reach the server; the message is still in your outbox: " + err.Error())
+			}
+		} else if sent > 1 {
+			fmt.Println("Message posted, with " + strconv.Itoa(sent-1) + " other posts from your outbox")
+		} else {
+			fmt.Println("Message posted")
+		}
+	default:
+		myError = errors.New("Enter /outbox retry N or /outbox discard N")
+	}
+
+	return myError
 }
 
-func notifySignedIn(registered bool) {
-	if registered {
-		fmt.Println("You are already signed in, which means you are already registered")
-		fmt.Println("If you want to register or sign in as another user,")
-		fmt.Println("you must sign out first and then register or sign in")
+// Delete the signed-in user, and their posts if they want,
+// once they enter their password or the deletion phrase.
+// Returns true if the account is gone.
+func deleteAccount(scanner *bufio.Scanner, userName string, accessToken string) (bool, error) {
+	var myError error
+
+	fmt.Println("Deleting your account can't be undone")
+
+	answer := ""
+
+	// Finding their posts needs GetUserPosts
+	if backend.Supports("GetUserPosts") {
+		answer = getStringValue(scanner, "Also delete all of your posts? (y/n)")
 		fmt.Println("")
 	} else {
-		fmt.Println("You are already signed in")
-		fmt.Println("If you want to sign in as another user,")
-		fmt.Println("you must sign out first and then sign in")
-		fmt.Println("")
+		fmt.Println("Your posts stay, since the GetUserPosts Lambda function isn't deployed")
 	}
-}
 
-func getAndListAllPosts(maxMessages int) {
-	Debug.Println("Calling getAllPosts")
-	posts, err := getAllPosts(maxMessages)
+	phrase := chatlib.DeletionPhrase(userName)
+	prompt := "Enter your password, or type '" + phrase + "', to delete your account (press Enter to cancel)"
 
-	if err == nil {
-		listAllPosts(posts)
-	} else {
-		fmt.Println("Could not get posts: " + err.Error())
+	if chatlib.DeletionNeedsPhrase(backend, accessToken) {
+		prompt = "Type '" + phrase + "' to delete your account (press Enter to cancel)"
 	}
-}
 
-type logInUserResult struct {
-	userName    string
-	accessToken string
+	confirmation := getSecretValue(scanner, prompt)
+	defer chatlib.ZeroBytes(confirmation)
+	fmt.Println("")
+
+	if len(confirmation) == 0 {
+		fmt.Println("Your account still exists")
+		return false, myError
+	}
+
+	Debug.Println("Calling DeleteAccount")
+
+	report, err := chatlib.DeleteAccount(backend, chatlib.DeletionRequest{
+		UserName:     userName,
+		AccessToken:  accessToken,
+		Confirmation: confirmation,
+		DeletePosts:  answer == "y" || answer == "Y",
+	})
+
+	// Say what we removed, even if we didn't get to the account
+	if err != chatlib.ErrDeletionNotConfirmed && err != chatlib.ErrDeletionNeedsPhrase {
+		for _, line := range report.Lines() {
+			fmt.Println(line)
+		}
+	}
+
+	if err != nil {
+		myError = err
+	}
+
+	return report.AccountDeleted, myError
 }
 
-func logInUser(scanner *bufio.Scanner) (logInUserResult, error) {
+// Change the password of the signed-in user, who must enter the old one
+func changePassword(scanner *bufio.Scanner, accessToken string) error {
 	var myError error
-	var result logInUserResult
 
-	// Get user name
-	name := getStringValue(scanner, "Enter your user name")
+	oldPassword := getSecretValue(scanner, "Enter your current password")
+	defer chatlib.ZeroBytes(oldPassword)
 	fmt.Println("")
-	password := getStringValue(scanner, "Enter your password")
+	newPassword := getSecretValue(scanner, "Enter your new password, with "+passwordPolicy().String())
+	defer chatlib.ZeroBytes(newPassword)
 	fmt.Println("")
 
-	Debug.Println("Calling signInUser")
-	token, err := signInUser(name, password)
+	err := checkNewPassword(newPassword)
 
-	// err means something went wrong;
-	// err.Error() has details
-	if err == nil {
-		result.userName = name
-		result.accessToken = token
-	} else {
-		myError = errors.New("Could not sign in user: " + err.Error())
+	if err != nil {
+		return err
 	}
 
-	return result, myError
-}
+	Debug.Println("Calling ChangePassword")
+
+	err = backend.ChangePassword(accessToken, oldPassword, newPassword)
+
+	if err != nil {
+		myError = errors.New("Could not change your password: " + err.Error())
+		return myError
+	}
+
+	fmt.Println("Your password is changed; use it the next time you sign in")
 
-type registerUserResult struct {
-	userName       string
-	password       string
-	accessToken    string
-	signedIn       bool
-	cursor         string
-	pastStep1      bool
-	registerPrompt string
+	return myError
 }
 
-func registerUser(scanner *bufio.Scanner, pastStep1 bool, name string, password string) (registerUserResult, error) {
-	var result registerUserResult
+// First step of changing the signed-in user's email address: send a code to the new one.
+// Returns the new address.
+func startEmailChange(scanner *bufio.Scanner, accessToken string) (string, error) {
 	var myError error
 
-	if pastStep1 {
-		// Finish registering
-		code := getStringValue(scanner, "Enter your confirmation code")
-		fmt.Println("")
+	email := chatlib.NormalizeEmail(getStringValue(scanner, "Enter your new email address"))
+	fmt.Println("")
+
+	problems := chatlib.CheckEmail(email)
 
-		Debug.Println("Calling finishRegisterUser")
+	if len(problems) > 0 {
+		myError = errors.New(strings.Join(problems, "\n"))
+		return "", myError
+	}
 
-		err := finishRegisterUser(name, code)
+	Debug.Println("Calling StartEmailChange")
 
-		if err != nil {
-			myError = errors.New("Could not finish registering user: " + err.Error())
-			return result, myError
-		}
+	delivery, err := backend.StartEmailChange(accessToken, email)
 
-		// Sign them in
-		token, err := signInUser(name, password)
+	if err != nil {
+		myError = errors.New("Could not change your email address: " + err.Error())
+		return "", myError
+	}
 
-		if err == nil {
-			result.userName = name
-			result.password = password
-			result.accessToken = token
-			result.signedIn = true
-			result.cursor = "(" + name + ")> "
-			result.pastStep1 = false
-			result.registerPrompt = "3: Register as new user"
-
-			return result, myError
-		} else {
-			myError = errors.New("Could not register user: " + err.Error())
-			return result, myError
-		}
-	} else {
-		Debug.Println("Calling startRegisterUser")
+	fmt.Println("We sent a verification code to " + sentTo(delivery, chatlib.MaskDestination(email)))
+	fmt.Println("Your email address doesn't change until you enter it")
 
-		// We need the name here
-		name = getStringValue(scanner, "Enter your user name")
-		password = getStringValue(scanner, "Enter a password with at least 6 characters")
+	return email, myError
+}
 
-		if len(password) < 6 {
-			myError = errors.New("You password is too short, try again")
-			return result, myError
-		}
+// Second step of changing the signed-in user's email address: check the code.
+// Returns true once the address is changed.
+func finishEmailChange(scanner *bufio.Scanner, accessToken string, email string) (bool, error) {
+	var myError error
 
-		email := getStringValue(scanner, "Enter your email address")
-		fmt.Println("")
+	code := getStringValue(scanner, "Enter the verification code sent to "+email+" (or press Enter to do it later with email finish)")
+	fmt.Println("")
 
-		err := startRegisterUser(name, password, email)
+	if code == "" {
+		return false, myError
+	}
 
-		if err == nil {
-			result.userName = name
-			result.password = password
-			// Change registration prompt
-			result.registerPrompt = "3: Finish registering (automatically signs you in)"
-			result.pastStep1 = true
-		} else {
-			myError = errors.New("Could not start registering user: " + err.Error())
-		}
+	Debug.Println("Calling FinishEmailChange")
 
-		return result, myError
+	err := backend.FinishEmailChange(accessToken, code)
+
+	if err != nil {
+		myError = errors.New("Could not verify your new email address: " + err.Error() + "\nEnter email finish to try again")
+		return false, myError
 	}
-}
 
-type resetPasswordResult struct {
-	cursor              string
-	pastStep1           bool
-	resetPasswordPrompt string
-    signedIn bool
+	fmt.Println("Your email address is now " + email + "; whoami shows it once you sign in again")
+
+	return true, myError
 }
 
-func resetPassword(scanner *bufio.Scanner, pastStep1 bool, name string) (resetPasswordResult, error) {
-	var result resetPasswordResult
+// Save every post the signed-in user wrote in a JSON file
+func exportPosts(scanner *bufio.Scanner, accessToken string, userName string) error {
 	var myError error
 
-	if pastStep1 {
-		// Finish resetting password
-		Debug.Println("Calling finishResetPassword")
-
-		// Get confirmation code and new password
-		cc := getStringValue(scanner, "Enter the confirmation code")
-		fmt.Println("")
-		pw := getStringValue(scanner, "Enter your new password")
-		fmt.Println("")
+	Debug.Println("Calling ExportPosts")
 
-		err := finishResetPassword(name, cc, pw)
+	posts, err := backend.ExportPosts(accessToken)
 
-		if err == nil {
-			Debug.Println("Successfully reset password")
-			// user is signed in with new password
-			result.cursor = "(" + name + ")> "
-			result.pastStep1 = false
-			result.resetPasswordPrompt = "4: Reset password"
-            result.signedIn = true
-
-			return result, myError
-		} else {
-			myError = errors.New("Could not reset password: " + err.Error())
-			return result, myError
-		}
-	} else {
-		Debug.Println("Calling startResetPassword")
-		Debug.Println("For user " + name)
+	if err != nil {
+		myError = errors.New("Could not get your posts: " + err.Error())
+		return myError
+	}
 
-		err := startResetPassword(name)
+	archive := chatlib.NewPostArchive(userName, posts, time.Now())
 
-		if err == nil {
-			result.pastStep1 = true
-			result.resetPasswordPrompt = "4: Finish resetting password"
-		} else {
-			myError = errors.New("Could not reset password: " + err.Error())
-		}
+	data, err := archive.JSON()
 
-		return result, myError
+	if err != nil {
+		return err
 	}
-}
-
-func postMessage(scanner *bufio.Scanner, accessToken string) error {
-	var myError error
 
-	// Query for message to post
-	message := getStringValue(scanner, "Enter the message to post")
+	fileName := getStringValue(scanner, "Enter the file to save your posts in (or press Enter for "+archive.FileName()+")")
+	fmt.Println("")
 
-	Debug.Println("Calling postMessage")
+	if fileName == "" {
+		fileName = archive.FileName()
+	}
 
-	err := postFromSignedInUser(accessToken, message)
+	// Don't replace a file they might want
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
 
-	if err == nil {
-		fmt.Println("Message posted")
-		return myError
-	} else {
-		myError = errors.New("Message not posted: " + err.Error())
+	if err != nil {
+		myError = errors.New("Could not save your posts: " + err.Error())
 		return myError
 	}
-}
 
-func deleteAccount(accessToken string) error {
-	var myError error
+	_, err = file.Write(data)
 
-	err := deleteUserAccount(accessToken)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 
-	if err == nil {
-		fmt.Println("Your account has been deleted")
-	} else {
-		myError = errors.New("Could not delete account: " + err.Error())
+	if err != nil {
+		myError = errors.New("Could not save your posts: " + err.Error())
+		return myError
 	}
 
+	fmt.Println("Saved " + strconv.Itoa(len(archive.Posts)) + " posts in " + fileName)
+
 	return myError
 }
 
-func deleteMyPost(scanner *bufio.Scanner, accessToken string) error {
+func deleteMyPost(scanner *bufio.Scanner, accessToken string, userName string) error {
 	var myError error
 
 	// Get the ID of the post
 	timestamp := getStringValue(scanner, "Enter the ID of the post to delete (the ID is the long number at the end of the first line):")
 	fmt.Println("")
 
-	err := deletePost(accessToken, timestamp)
+	err := backend.DeletePost(accessToken, timestamp)
 
 	if err != nil {
 		myError = errors.New("Could not delete post: " + err.Error())
-	}
+	} else {
+		// So searches and the history don't have it
+		key := chatlib.PostKey{Alias: userName, Timestamp: timestamp}
+		unindexPost(key)
 
-	return myError
+		if history != nil {
+			err = history.Remove(key)
+
+			if err != nil {
+				Debug.Println(err.Error())
+			}
+		}
+	}
+
+	return myError
+}
+
+// Replace the message of one of the signed-in user's posts.
+// If timestamp is empty, ask for the ID of the post.
+func editPost(scanner *bufio.Scanner, accessToken string, timestamp string) error {
+	var myError error
+
+	if timestamp == "" {
+		timestamp = getStringValue(scanner, "Enter the ID of the post to edit (the ID is the long number at the end of the first line):")
+		fmt.Println("")
+	}
+
+	// Show what it says now; only their own posts come back
+	Debug.Println("Calling ExportPosts")
+
+	posts, err := backend.ExportPosts(accessToken)
+
+	if err != nil {
+		myError = errors.New("Could not get your posts: " + err.Error())
+		return myError
+	}
+
+	var post *chatlib.Post
+
+	for i := range posts {
+		if posts[i].Timestamp == timestamp {
+			post = &posts[i]
+		}
+	}
+
+	if post == nil {
+		myError = errors.New("You don't have a post with the ID " + timestamp)
+		return myError
+	}
+
+	fmt.Println("It says:")
+	fmt.Println(post.Message)
+	fmt.Println("")
+
+	message := getStringValue(scanner, "Enter the new message (press Enter to keep it)")
+	fmt.Println("")
+
+	if message == "" || message == post.Message {
+		fmt.Println("Post not changed")
+		return myError
+	}
+
+	Debug.Println("Calling EditPost")
+
+	err = backend.EditPost(accessToken, timestamp, message)
+
+	if err != nil {
+		myError = errors.New("Could not edit post: " + err.Error())
+		return myError
+	}
+
+	fmt.Println("Post edited; the old message is kept as a revision")
+
+	return myError
+}
+
+// Find one of the latest posts by its ID.
+// Posts by different users can have the same ID; if they do, ask whose.
+func findPost(scanner *bufio.Scanner, timestamp string) (chatlib.Post, error) {
+	var myError error
+
+	posts, err := getAllPosts(configuration.MaxMessages)
+
+	if err != nil {
+		return chatlib.Post{}, err
+	}
+
+	var matches []chatlib.Post
+
+	for _, p := range posts {
+		if p.Timestamp == timestamp {
+			matches = append(matches, p)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		myError = errors.New("None of the latest " + strconv.Itoa(configuration.MaxMessages) + " posts has the ID " + timestamp)
+		return chatlib.Post{}, myError
+	case 1:
+		return matches[0], myError
+	}
+
+	var aliases []string
+
+	for _, p := range matches {
+		aliases = append(aliases, p.Alias)
+	}
+
+	alias := getStringValue(scanner, "Which user's post? ("+strings.Join(aliases, ", ")+")")
+	fmt.Println("")
+
+	for _, p := range matches {
+		if p.Alias == alias {
+			return p, myError
+		}
+	}
+
+	myError = errors.New(alias + " has no post with the ID " + timestamp)
+
+	return chatlib.Post{}, myError
+}
+
+// Reply to a post. If timestamp is empty, ask for the ID of the post.
+func replyToPost(scanner *bufio.Scanner, accessToken string, timestamp string) error {
+	var myError error
+
+	if timestamp == "" {
+		timestamp = getStringValue(scanner, "Enter the ID of the post to reply to (the ID is the long number at the end of the first line):")
+		fmt.Println("")
+	}
+
+	parent, err := findPost(scanner, timestamp)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Replying to " + parent.Alias + ":")
+	fmt.Println(parent.Message)
+	fmt.Println("")
+
+	message := getStringValue(scanner, "Enter your reply (press Enter to cancel)")
+	fmt.Println("")
+
+	if message == "" {
+		fmt.Println("Reply not posted")
+		return myError
+	}
+
+	Debug.Println("Calling AddReply")
+
+	err = backend.AddReply(accessToken, parent.Key(), message)
+
+	if err != nil {
+		myError = errors.New("Reply not posted: " + err.Error())
+		return myError
+	}
+
+	fmt.Println("Reply posted")
+
+	return myError
+}
+
+// Add the signed-in user's reaction to a post or, if they already reacted that way, take it back.
+// If timestamp or reaction is empty, ask for it.
+func reactToPost(scanner *bufio.Scanner, accessToken string, userName string, timestamp string, reaction string) error {
+	var myError error
+
+	if timestamp == "" {
+		timestamp = getStringValue(scanner, "Enter the ID of the post to react to (the ID is the long number at the end of the first line):")
+		fmt.Println("")
+	}
+
+	post, err := findPost(scanner, timestamp)
+
+	if err != nil {
+		return err
+	}
+
+	if reaction == "" {
+		for i, name := range chatlib.ReactionNames {
+			line := strconv.Itoa(i+1) + ": " + chatlib.ReactionEmoji(name) + " :" + name + ":"
+
+			if post.Reacted(userName, name) {
+				line += " (yours; pick it to remove it)"
+			}
+
+			fmt.Println(line)
+		}
+
+		fmt.Println("")
+
+		reaction = getStringValue(scanner, "Enter a reaction, by number or name (press Enter to cancel)")
+		fmt.Println("")
+
+		if reaction == "" {
+			return myError
+		}
+	}
+
+	if i, err := strconv.Atoi(reaction); err == nil && i >= 1 && i <= len(chatlib.ReactionNames) {
+		reaction = chatlib.ReactionNames[i-1]
+	}
+
+	reaction = chatlib.NormalizeReaction(reaction)
+
+	if problem := chatlib.CheckReaction(reaction); problem != "" {
+		myError = errors.New(problem)
+		return myError
+	}
+
+	if post.Reacted(userName, reaction) {
+		Debug.Println("Calling RemoveReaction")
+
+		err = backend.RemoveReaction(accessToken, post.Key(), reaction)
+
+		if err == nil {
+			fmt.Println("Removed your " + chatlib.ReactionEmoji(reaction) + " from " + post.Alias + "'s post")
+		}
+	} else {
+		Debug.Println("Calling AddReaction")
+
+		err = backend.AddReaction(accessToken, post.Key(), reaction)
+
+		if err == nil {
+			fmt.Println("Reacted to " + post.Alias + "'s post with " + chatlib.ReactionEmoji(reaction))
+		}
+	}
+
+	if err != nil {
+		myError = errors.New("Could not react: " + err.Error())
+	}
+
+	return myError
+}
+
+// Hide the replies to a post in the list of posts, or show them again
+func toggleThread(scanner *bufio.Scanner, timestamp string) error {
+	if timestamp == "" {
+		timestamp = getStringValue(scanner, "Enter the ID of the post whose replies to hide or show:")
+		fmt.Println("")
+	}
+
+	post, err := findPost(scanner, timestamp)
+
+	if err != nil {
+		return err
+	}
+
+	if collapsedThreads[post.Key()] {
+		delete(collapsedThreads, post.Key())
+		fmt.Println("Showing the replies to " + post.Alias + "'s post <" + post.Timestamp + ">")
+	} else {
+		collapsedThreads[post.Key()] = true
+		fmt.Println("Hiding the replies to " + post.Alias + "'s post <" + post.Timestamp + ">")
+	}
+
+	return nil
+}
+
+// List the rooms, and whether the user is in each of them
+func listRooms(userName string) error {
+	rooms, err := backend.ListRooms()
+
+	if err != nil {
+		return errors.New("Could not get rooms: " + err.Error())
+	}
+
+	for _, room := range rooms {
+		line := "#" + room.Name
+
+		switch {
+		case room.Everyone:
+			line += " (everyone)"
+		case len(room.Members) == 1:
+			line += " (1 member)"
+		default:
+			line += " (" + strconv.Itoa(len(room.Members)) + " members)"
+		}
+
+		if room.Name == currentRoom {
+			line += " <- you are here"
+		} else if userName != "" && !room.Everyone && room.HasMember(userName) {
+			line += " <- joined"
+		}
+
+		fmt.Println(line)
+	}
+
+	return nil
+}
+
+// Go to a room. If they're signed in, join it, so they can post to it,
+// or, if there's no such room, offer to create it.
+func joinRoom(scanner *bufio.Scanner, accessToken string, userName string, name string) error {
+	var myError error
+
+	if name == "" {
+		name = getStringValue(scanner, "Enter the name of the room")
+		fmt.Println("")
+	}
+
+	name = chatlib.NormalizeRoomName(name)
+
+	if problems := chatlib.CheckRoomName(name); len(problems) > 0 {
+		myError = errors.New(strings.Join(problems, "\n"))
+		return myError
+	}
+
+	rooms, err := backend.ListRooms()
+
+	if err != nil {
+		myError = errors.New("Could not get rooms: " + err.Error())
+		return myError
+	}
+
+	room, ok := chatlib.FindRoom(rooms, name)
+
+	switch {
+	case !ok && userName == "":
+		myError = errors.New("There is no room " + name + "; sign in to create it")
+		return myError
+	case !ok:
+		answer := getStringValue(scanner, "There is no room "+name+". Create it? (y/n)")
+		fmt.Println("")
+
+		if answer != "y" && answer != "Y" {
+			fmt.Println("You're still in #" + currentRoom)
+			return myError
+		}
+
+		Debug.Println("Calling CreateRoom")
+
+		err = backend.CreateRoom(accessToken, name)
+
+		if err != nil {
+			myError = errors.New("Could not create room: " + err.Error())
+			return myError
+		}
+
+		fmt.Println("Created #" + name)
+	case userName != "" && !room.HasMember(userName):
+		Debug.Println("Calling JoinRoom")
+
+		err = backend.JoinRoom(accessToken, name)
+
+		if err != nil {
+			myError = errors.New("Could not join room: " + err.Error())
+			return myError
+		}
+
+		fmt.Println("Joined #" + name)
+	}
+
+	currentRoom = name
+	fmt.Println("You're in #" + name)
+
+	return myError
+}
+
+// When a direct message was sent, such as Monday, January  2 2006 3:04:05 PM MST
+func messageTime(m chatlib.DirectMessage) string {
+	numTime, err := strconv.ParseInt(m.Timestamp, 10, 64)
+
+	if err != nil {
+		return "???"
+	}
+
+	thisTime := time.Unix(numTime, 0)
+
+	return FormatAsDate(thisTime).String() + " " + FormatAsTime(thisTime).String()
+}
+
+// List the signed-in user's conversations, with how many messages they haven't read
+func listConversations(accessToken string) error {
+	var myError error
+
+	conversations, err := backend.ListConversations(accessToken)
+
+	if err != nil {
+		myError = errors.New("Could not get direct messages: " + err.Error())
+		return myError
+	}
+
+	if len(conversations) == 0 {
+		fmt.Println("You have no direct messages; enter /dm USER to send one")
+		return myError
+	}
+
+	fmt.Println("Direct messages (" + strconv.Itoa(chatlib.UnreadCount(conversations)) + " unread):")
+	fmt.Println("")
+
+	for _, c := range conversations {
+		line := c.With
+
+		if c.Unread > 0 {
+			line += " (" + strconv.Itoa(c.Unread) + " unread)"
+		}
+
+		fmt.Println(line + ", last at " + messageTime(c.Latest) + ":")
+		fmt.Println("    " + c.Latest.From + ": " + c.Latest.Message)
+	}
+
+	return myError
 }
 
-func main() {
-	/*
+// Show the messages between the signed-in user and with, oldest first,
+// then let them send with a message
+func directMessages(scanner *bufio.Scanner, accessToken string, userName string, with string, maxMessages int) error {
+	var myError error
+
+	if with == "" {
+		with = getStringValue(scanner, "Enter the user name of who to message")
+		fmt.Println("")
+	}
+
+	with = userNamePolicy().Normalize(with)
+
+	if with == "" {
+		return myError
+	}
+
+	if with == userName {
+		myError = errors.New("You can't send a direct message to yourself")
+		return myError
+	}
+
+	Debug.Println("Calling GetDirectMessages")
+
+	messages, err := backend.GetDirectMessages(accessToken, with, maxMessages)
+
+	if err != nil {
+		myError = errors.New("Could not get direct messages: " + err.Error())
+		return myError
+	}
+
+	if len(messages) == 0 {
+		fmt.Println("No messages with " + with + " yet")
+	}
+
+	for i := range messages {
+		m := messages[len(messages)-i-1]
+
+		fmt.Println(m.From + "@" + messageTime(m) + ":")
+		fmt.Println(m.Message)
+		fmt.Println("")
+	}
+
+	message := getStringValue(scanner, "Enter a message to "+with+" (press Enter to go back)")
+	fmt.Println("")
+
+	if message == "" {
+		return myError
+	}
+
+	Debug.Println("Calling SendDirectMessage")
+
+	err = backend.SendDirectMessage(accessToken, with, message)
+
+	if err != nil {
+		myError = errors.New("Message not sent: " + err.Error())
+		return myError
+	}
+
+	fmt.Println("Message sent to " + with)
+
+	return myError
+}
+
+// List the posts that mention the signed-in user:
+// the ones since they last looked, or, if all is true, every one we find
+func showMentions(userName string, all bool) error {
+	var myError error
+
+	markers, err := chatlib.LoadMentionMarkers(mentionsFile())
+
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	since := markers[userName]
+
+	if all {
+		since = ""
+	}
+
+	Debug.Println("Looking for mentions of " + userName + " since " + since)
+
+	posts, err := chatlib.FindMentions(backend, userNamePolicy(), userName, since, configuration.MaxMessages)
+
+	if err != nil {
+		myError = errors.New("Could not get mentions: " + err.Error())
+		return myError
+	}
+
+	if len(posts) == 0 {
+		if all {
+			fmt.Println("Nobody has mentioned you; enter /mentions all again later")
+		} else {
+			fmt.Println("No new mentions; enter /mentions all to see the earlier ones")
+		}
+
+		return myError
+	}
+
+	count := strconv.Itoa(len(posts)) + " posts"
+
+	if len(posts) == 1 {
+		count = "1 post"
+	}
+
+	if all {
+		fmt.Println(count + " mention you:")
+	} else {
+		fmt.Println(count + " mention you since you last looked:")
+	}
+
+	fmt.Println("")
+
+	known := knownUsers(posts)
+
+	for _, p := range posts {
+		numTime, err := strconv.ParseInt(p.Timestamp, 10, 64)
+		when := "???"
+
+		if err == nil {
+			thisTime := time.Unix(numTime, 0)
+			when = FormatAsDate(thisTime).String() + " " + FormatAsTime(thisTime).String()
+		}
+
+		fmt.Println(p.Alias + "@" + when + " in #" + p.Room + " <" + p.Timestamp + ">:")
+		fmt.Println(highlightMentions(p.Message, known))
+		fmt.Println("")
+	}
+
+	if markers.MarkSeen(userName, posts) {
+		err = markers.Save(mentionsFile())
+
+		if err != nil {
+			fmt.Println("Could not save which mentions you've seen: " + err.Error())
+		}
+	}
+
+	return myError
+}
+
+// Search every post we've gotten, after getting the latest ones in each room,
+// and list the ones that match query, newest first, with what matched highlighted
+func searchPosts(query string) error {
+	var myError error
+
+	q, err := chatlib.ParseQuery(query, time.Local)
+
+	if err != nil {
+		myError = err
+		return myError
+	}
+
+	Debug.Println("Calling RefreshIndex")
+
+	_, err = chatlib.RefreshIndex(backend, searchIndex, configuration.MaxMessages)
+
+	if err != nil {
+		fmt.Println("Searching only the posts we already have: " + err.Error())
+	}
+
+	err = searchIndex.Save(searchIndexFile())
+
+	if err != nil {
+		Debug.Println("Could not save search index: " + err.Error())
+	}
+
+	posts := searchIndex.Search(q)
+
+	if len(posts) == 0 {
+		fmt.Println("No posts match " + query + " (searched " + strconv.Itoa(searchIndex.Len()) + " posts)")
+		return myError
+	}
+
+	shown := posts
+
+	if len(shown) > configuration.MaxMessages {
+		shown = shown[:configuration.MaxMessages]
+		fmt.Println("The newest " + strconv.Itoa(len(shown)) + " of " + strconv.Itoa(len(posts)) + " posts that match " + query + ":")
+	} else if len(posts) == 1 {
+		fmt.Println("1 post matches " + query + ":")
+	} else {
+		fmt.Println(strconv.Itoa(len(posts)) + " posts match " + query + ":")
+	}
+
+	fmt.Println("")
+
+	for _, p := range shown {
+		numTime, err := strconv.ParseInt(p.Timestamp, 10, 64)
+		when := "???"
+
+		if err == nil {
+			thisTime := time.Unix(numTime, 0)
+			when = FormatAsDate(thisTime).String() + " " + FormatAsTime(thisTime).String()
+		}
+
+		fmt.Println(p.Alias + "@" + when + " in #" + p.Room + " <" + p.Timestamp + ">" + editedSuffix(p) + ":")
+
+		// Show what matched in reverse video
+		var text strings.Builder
+
+		for _, part := range q.Highlight(p.Message) {
+			if part.Match {
+				text.WriteString("\033[7m" + part.Text + "\033[0m")
+			} else {
+				text.WriteString(part.Text)
+			}
+		}
+
+		fmt.Println(text.String())
+		fmt.Println("")
+	}
+
+	return myError
+}
+
+var verifier *chatlib.Verifier
+
+// Get the verifier for the configured user pool,
+// loading the user pool's keys the first time.
+// Returns nil, and no error, if UserPoolID or ClientID isn't set.
+func getVerifier() (*chatlib.Verifier, error) {
+	// The memory backend signs its own tokens
+	if memory, ok := backend.(*chatlib.MemoryBackend); ok && verifier == nil {
+		verifier = memory.Verifier()
+	}
+
+	if verifier != nil || configuration.UserPoolID == "" || configuration.ClientID == "" {
+		return verifier, nil
+	}
+
+	v, err := chatlib.LoadVerifier(configuration.Region, configuration.UserPoolID, configuration.ClientID, configuration.JWKSFile)
+
+	if v == nil {
+		return nil, errors.New("Could not get the keys of user pool " + configuration.UserPoolID + ": " + err.Error())
+	}
+
+	if err != nil {
+		Debug.Println(err.Error())
+	}
+
+	verifier = v
+
+	return verifier, nil
+}
+
+// Show who the tokens say is signed in, when they expire,
+// where we're running, and how many of the latest posts are theirs
+func whoAmI(accessToken string, idToken string, refreshToken string) error {
+	var myError error
+	var access chatlib.Claims
+	var id chatlib.Claims
+
+	v, err := getVerifier()
+
+	if err != nil {
+		myError = errors.New("Could not check your tokens: " + err.Error())
+		return myError
+	}
+
+	if v != nil {
+		access, err = v.VerifyAccessToken(accessToken)
+
+		if err == nil && idToken != "" {
+			id, err = v.VerifyIDToken(idToken)
+		}
+	} else {
+		access, err = chatlib.DecodeClaims(accessToken)
+
+		if err == nil && idToken != "" {
+			id, err = chatlib.DecodeClaims(idToken)
+		}
+	}
+
+	// An expired token still tells us who they are
+	if err != nil && err != chatlib.ErrTokenExpired {
+		myError = errors.New("Your tokens are not valid (" + err.Error() + "); sign out and sign in again")
+		return myError
+	}
+
+	profile := chatlib.NewProfile(access, id, v != nil)
+	profile.HasRefreshToken = refreshToken != ""
+	profile.Region = configuration.Region
+	profile.Deployment = backend.Deployment()
+
+	mfa, err := backend.GetMFA(accessToken)
+
+	if err != nil {
+		Debug.Println("Could not get MFA: " + err.Error())
+		mfa = chatlib.MFAUnknown
+	}
+
+	profile.MFA = mfa
+
+	posts, err := getAllPosts(configuration.MaxMessages)
+
+	if err == nil {
+		for _, p := range posts {
+			if p.Alias == profile.UserName {
+				profile.Posts++
+			}
+		}
+	}
+
+	now := time.Now()
+
+	fmt.Println("User name:  " + profile.UserName)
+	fmt.Println("Email:      " + profile.EmailStatus())
+	fmt.Println("Tokens:     " + profile.ExpiryStatus(now))
+	fmt.Println("Refresh:    " + profile.RefreshStatus(now))
+	fmt.Println("MFA:        " + profile.MFAStatus())
+	fmt.Println("Region:     " + profile.Region)
+
+	if len(profile.Deployment) == 0 {
+		fmt.Println("Deployment: every function uses its own name")
+	} else {
+		fmt.Println("Deployment:")
+
+		for _, function := range profile.Deployment {
+			fmt.Println("  " + function)
+		}
+	}
+
+	if err == nil {
+		fmt.Println("Posts:      " + strconv.Itoa(profile.Posts) + " of the latest " + strconv.Itoa(len(posts)))
+	} else {
+		fmt.Println("Posts:      could not get posts: " + err.Error())
+	}
+
+	if !profile.Verified {
+		fmt.Println("")
+		fmt.Println("These values were NOT checked; set UserPoolID and ClientID in conf.json to check them")
+	}
+
+	return myError
+}
+
+func main() {
+	/*
+
+	   The strategy for parsing JSON response is to get the statusCode,
+	   headers (and Content-Type), and body (and result).
+
+	   If the statusCode is not 200, the call failed.
+	   If result is not "success", the operation failed.
+
+	   In either of these two cases, we reconstitute the JSON as the failed version
+	   of the object and display the error message if it exists.
+
+	*/
+    SetConfiguration()
+
+	regionPtr := flag.String("r", configuration.Region, "Region to look for services")
+	timezonePtr := flag.String("t", configuration.Timezone, "Timezone for displayed date and time")
+	maxMsgsPtr := flag.Int("n", configuration.MaxMessages, "Maximum number of messages to download")
+	refreshPtr := flag.Int("f", configuration.RefreshSeconds, "Duration, in seconds, between refreshing post list")
+	backendPtr := flag.String("backend", configuration.Backend, "Where to keep users and posts: lambda or memory")
+	debugPtr := flag.Bool("d", configuration.Debug, "Whether to show debug output")
+	helpPtr := flag.Bool("h", false, "Show usage")
+
+    flag.Parse()
+
+    // Save configuration
+    configuration.Region = *regionPtr
+    configuration.Timezone = *timezonePtr
+    configuration.MaxMessages = *maxMsgsPtr
+    configuration.RefreshSeconds = *refreshPtr
+    configuration.Debug = *debugPtr
+    configuration.Backend = *backendPtr
+
+    help := *helpPtr
+
+    if help {
+        usage()
+        os.Exit(0)
+    }
+
+    if configuration.Debug {
+        initLog(os.Stderr)
+    } else {
+        initLog(ioutil.Discard)
+    }
+
+    Debug.Println("Region:     " + configuration.Region)
+    Debug.Println("Timezone:   " + configuration.Timezone)
+    Debug.Println("Max # msgs: " + strconv.Itoa(configuration.MaxMessages))
+    Debug.Println("Refresh:    " + strconv.Itoa(configuration.RefreshSeconds))
+
+    Debug.Println("Backend:    " + configuration.Backend)
+    Debug.Println("Room:       " + defaultRoom())
+
+    var err error
+
+    backend, err = chatlib.NewBackend(configuration.Backend, configuration.Region, configuration.Functions, defaultRoom())
+
+    if err != nil {
+        fmt.Println(err.Error())
+        os.Exit(1)
+    }
+
+    switch b := backend.(type) {
+    case *chatlib.LambdaBackend:
+        b.Debug = Debug
+    case *chatlib.MemoryBackend:
+        b.PasswordPolicy = passwordPolicy()
+
+        // There's no email, so show what we would have sent
+        b.Deliver = func(to string, subject string, body string) {
+            fmt.Println("(memory backend) To " + to + ": " + subject)
+            fmt.Println(body)
+            fmt.Println("")
+        }
+    }
+
+    // Let them know up front if any function is missing
+    missing, err := backend.Check()
+
+    if err != nil {
+        fmt.Println("Could not check the Lambda functions: " + err.Error())
+    }
+
+    if len(missing) > 0 {
+        fmt.Println("The following Lambda functions do not exist in " + configuration.Region + ":")
+
+        for _, m := range missing {
+            fmt.Println("  " + m)
+        }
+
+        fmt.Println("Update Functions in conf.json to point at your deployed functions")
+    }
+
+    // The rest are optional; we hide what needs them
+    if unsupported := chatlib.Unsupported(backend); len(unsupported) > 0 {
+        fmt.Println("These optional Lambda functions do not exist in " + configuration.Region + ", so what needs them is hidden: " + strings.Join(unsupported, ", "))
+    }
+
+	searchIndex, err = chatlib.LoadSearchIndex(searchIndexFile())
+
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	outbox, err = chatlib.LoadOutbox(outboxFile())
+
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	history, err = chatlib.OpenHistory(historyFile())
+
+	if err != nil {
+		fmt.Println(err.Error())
+		fmt.Println("Getting posts without keeping them")
+		history = nil
+	} else {
+		defer history.Close()
+	}
+
+	// PostApp search "QUERY" searches, then quits
+	if flag.Arg(0) == "search" {
+		err = searchPosts(strings.Join(flag.Args()[1:], " "))
+
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	currentRoom = defaultRoom()
+	cursor := cursorFor("")
+
+	// When false, stop the app
+	keepGoing := true
+
+	// The name of the current user
+	userName := ""
+
+	// True if signed in (required to post)
+	signedIn := false
+
+	// Initialize a session that the SDK will use to load configuration,
+	// credentials, and region from the shared config file. (~/.aws/config).
+	Debug.Println("Calling " + configuration.Backend + " backend in: " + configuration.Region)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	inputValue := ""
+
+	// Registering and resetting a password each take two steps,
+	// which can be in different runs of the app
+	flows, err := chatlib.LoadPendingFlows(pendingFile())
+
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	var accessToken string = ""
+	var idToken string = ""
+	var refreshToken string = ""
+
+	// The address the user is changing their email to, until they enter the code
+	pendingEmail := ""
+
+	for keepGoing {
+		// Send any posts that are waiting, now that the server might be back
+		if signedIn && outbox.Pending(userName) > 0 {
+			failed := outbox.Failed(userName)
+			sent, err := sendOutbox(accessToken, userName)
+
+			if sent > 0 {
+				fmt.Println("Sent " + strconv.Itoa(sent) + " of your posts from your outbox")
+			}
+
+			if refused := outbox.Failed(userName) - failed; refused > 0 {
+				fmt.Println(strconv.Itoa(refused) + " of your posts from your outbox couldn't be sent; enter /outbox to see why, and retry or discard them")
+			}
+
+			if err != nil {
+				Debug.Println("Could not send outbox: " + err.Error())
+			}
+		}
+
+		// Menu
+		fmt.Println("")
+		fmt.Println("Enter a value between 1 and 18, or a / command, to perform the indicated action or q (or Q) to quit:")
+		fmt.Println("")
+		fmt.Println("1: List all posts")
+		fmt.Println("2: Sign in")
+		fmt.Println(flowPrompt("3", "register", flows.Registration, "Register as new user", "Finish registering (then sign in)"))
+		fmt.Println(flowPrompt("4", "reset", flows.PasswordReset, "Reset password", "Finish resetting password (then sign in)"))
+		fmt.Println("5: Post a message (you must be signed in)")
+		fmt.Println("6: Sign out")
+		fmt.Println("7: Delete your account (you must be signed in)")
+		fmt.Println("8: Delete a post (you must be signed in and it must be your post)")
+		fmt.Println("9 (or whoami): Show who you are signed in as, and your account status (you must be signed in)")
+		if backend.Supports("RemindCognitoUserName") {
+			fmt.Println("10 (or forgot): Forgot your user name? Get it by email")
+		}
+		if mfaSupported() {
+			fmt.Println("11 (or mfa): Set up an authenticator app for MFA (mfa off: turn MFA off) (you must be signed in)")
+		}
+		if backend.Supports("ChangeCognitoUserPassword") {
+			fmt.Println("12 (or password): Change your password (you must be signed in)")
+		}
+		if backend.Supports("UpdateCognitoUserEmail") && backend.Supports("VerifyCognitoUserEmail") {
+			if pendingEmail == "" {
+				fmt.Println("13 (or email): Change your email address (you must be signed in)")
+			} else {
+				fmt.Println("13 (or email finish): Enter the code sent to " + pendingEmail + " (email start: use another address)")
+			}
+		}
+		if backend.Supports("GetUserPosts") {
+			fmt.Println("14 (or export): Save all of your posts in a JSON file (you must be signed in)")
+		}
+		if backend.Supports("EditPost") {
+			fmt.Println("15 (or edit post ID): Edit one of your posts (you must be signed in)")
+		}
+		if backend.Supports("AddReply") {
+			fmt.Println("16 (or reply ID): Reply to a post (you must be signed in)")
+		}
+		fmt.Println("17 (or thread ID): Hide or show the replies to a post")
+		if backend.Supports("AddReaction") && backend.Supports("RemoveReaction") {
+			fmt.Println("18 (or react ID [REACTION]): React to a post, such as react ID tada, or take your reaction back (you must be signed in)")
+		}
+		if backend.Supports("ListRooms") {
+			fmt.Println("/rooms: List the chat rooms")
+			fmt.Println("/join ROOM: Go to a chat room (if you're signed in, join it, or create it)")
+		}
+		if backend.Supports("ListConversations") {
+			fmt.Println("/dms: List your direct messages, with how many you haven't read (you must be signed in)")
+		}
+		if backend.Supports("GetDirectMessages") {
+			fmt.Println("/dm USER: Read and send direct messages with USER (you must be signed in)")
+		}
+		fmt.Println("/history N: List the latest N posts in the room we've gotten, even ones older than the latest " + strconv.Itoa(configuration.MaxMessages) + " (/history: every one)")
+		fmt.Println("/search QUERY: Search every post we've gotten, such as /search from:bob after:2017-11-01 \"lunch today\"")
+		fmt.Println("/outbox: List your posts that haven't been sent (/outbox retry N: send post N now; /outbox discard N: throw it away) (you must be signed in)")
+		fmt.Println("/mentions: List the posts that mention you since you last looked (/mentions all: every one) (you must be signed in)")
+		fmt.Println("q (or Q): Quit")
+		fmt.Println("")
+
+		inputValue = getStringValue(scanner, cursor)
+
+		if inputValue != "q" && inputValue != "Q" && !configuration.Debug {
+			clearScreen()
+		}
+
+		Debug.Println("Got: '" + inputValue + "'")
+
+		// Registering and resetting take an action, such as "register finish"
+		command := inputValue
+		action := ""
+		reaction := ""
+		query := ""
+		number := ""
+
+		if fields := strings.Fields(inputValue); len(fields) > 0 && fields[0] == "/search" {
+			// The rest of /search QUERY is the query
+			command = fields[0]
+			query = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(inputValue), "/search"))
+		} else if len(fields) == 2 {
+			command = fields[0]
+			action = fields[1]
+		} else if len(fields) == 3 && fields[0] == "edit" && fields[1] == "post" {
+			// edit post ID
+			command = "edit"
+			action = fields[2]
+		} else if len(fields) == 3 && fields[0] == "/outbox" {
+			// /outbox retry N or /outbox discard N
+			command = fields[0]
+			action = fields[1]
+			number = fields[2]
+		} else if len(fields) == 3 && fields[0] == "react" {
+			// react ID REACTION
+			command = "react"
+			action = fields[1]
+			reaction = fields[2]
+		}
+
+		switch command {
+		case "1":
+			// Get and list all posts
+			getAndListAllPosts(configuration.MaxMessages, userName)
+
+		case "2":
+			// sign in user
+
+			// if already signed in, tell user
+			if signedIn {
+				notifySignedIn(false) // true means tell them they are already registered
+				continue
+			}
+
+			result, err := logInUser(scanner)
+
+			if err != nil {
+				fmt.Println(err.Error())
+			} else {
+				signedIn = true
+
+				userName = result.userName
+				accessToken = result.accessToken
+				idToken = result.idToken
+				refreshToken = result.refreshToken
+
+				cursor = cursorFor(userName)
+			}
+
+		case "3", "register":
+			// register
+
+			// if already signed in, tell user
+			if signedIn {
+				notifySignedIn(true) // true means tell them they are already registered
+				continue
+			}
+
+			flow := &flows.Registration
+
+			if action == "" {
+				action = "start"
+
+				if flow.Pending() {
+					action = "finish"
+				}
+			}
+
+			switch action {
+			case "start":
+				err = startRegistration(scanner, flow)
+
+			case "finish":
+				if !flow.Pending() {
+					err = errors.New("There is no registration to finish; enter register to start one")
+					break
+				}
+
+				var result logInUserResult
+
+				result, err = finishRegistration(scanner, flow)
+
+				if err == nil {
+					signedIn = true
+					userName = result.userName
+					accessToken = result.accessToken
+					idToken = result.idToken
+					refreshToken = result.refreshToken
+					cursor = cursorFor(userName)
+				}
+
+			case "resend":
+				err = resendCode(flow)
+
+			case "cancel":
+				err = flow.Cancel()
+
+				if err == nil {
+					fmt.Println("Canceled registering")
+				}
+
+			default:
+				err = errors.New("Unrecognized option: " + inputValue)
+			}
+
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+
+			savePendingFlows(flows)
+
+		case "4", "reset":
+			// Reset password
+			flow := &flows.PasswordReset
+
+			if action == "" {
+				action = "start"
+
+				if flow.Pending() {
+					action = "finish"
+				}
+			}
+
+			switch action {
+			case "start":
+				err = startReset(scanner, flow)
+
+			case "finish":
+				if !flow.Pending() {
+					err = errors.New("There is no password reset to finish; enter reset to start one")
+					break
+				}
+
+				var result logInUserResult
+
+				result, err = finishReset(scanner, flow)
+
+				if err == nil {
+					signedIn = true
+					userName = result.userName
+					accessToken = result.accessToken
+					idToken = result.idToken
+					refreshToken = result.refreshToken
+					cursor = cursorFor(userName)
+				}
+
+			case "resend":
+				err = resendCode(flow)
+
+			case "cancel":
+				err = flow.Cancel()
+
+				if err == nil {
+					fmt.Println("Canceled resetting your password")
+				}
+
+			default:
+				err = errors.New("Unrecognized option: " + inputValue)
+			}
+
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+
+			savePendingFlows(flows)
+
+		case "5":
+			// post message
+			if !signedIn {
+				fmt.Println("You must be signed in to post a message")
+				continue
+			}
+
+			err := postMessage(scanner, accessToken, userName)
+
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+
+		case "6":
+			if !signedIn {
+				fmt.Println("Your are not signed in")
+				continue
+			}
+
+			// sign out
+			signedIn = false
+			userName = ""
+			accessToken = ""
+			idToken = ""
+			refreshToken = ""
+			pendingEmail = ""
+			cursor = cursorFor("")
+
+		case "7":
+			// delete account
+			if !signedIn {
+				fmt.Println("You must be signed in to delete your account")
+				continue
+			}
+
+			deleted, err := deleteAccount(scanner, userName, accessToken)
+
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+
+			if deleted {
+				signedIn = false
+				userName = ""
+				accessToken = ""
+				idToken = ""
+				refreshToken = ""
+				pendingEmail = ""
+				cursor = cursorFor("")
+			}
+
+		case "8":
+			// delete post
+			if !signedIn {
+				fmt.Println("You must be signed in to delete a post")
+				continue
+			}
 
-	   The strategy for parsing JSON response is to get the statusCode,
-	   headers (and Content-Type), and body (and result).
+			err := deleteMyPost(scanner, accessToken, userName)
 
-	   If the statusCode is not 200, the call failed.
-	   If result is not "success", the operation failed.
+			if err == nil {
+				fmt.Println("Post deleted")
+			} else {
+				fmt.Println(err.Error())
+			}
 
-	   In either of these two cases, we reconstitute the JSON as the failed version
-	   of the object and display the error message if it exists.
+		case "9", "whoami":
+			// show who is signed in
+			if !signedIn {
+				fmt.Println("You must be signed in to see who you are signed in as")
+				continue
+			}
 
-	*/
-    SetConfiguration()
+			err := whoAmI(accessToken, idToken, refreshToken)
 
-	regionPtr := flag.String("r", configuration.Region, "Region to look for services")
-	timezonePtr := flag.String("t", configuration.Timezone, "Timezone for displayed date and time")
-	maxMsgsPtr := flag.Int("n", configuration.MaxMessages, "Maximum number of messages to download")
-	refreshPtr := flag.Int("f", configuration.RefreshSeconds, "Duration, in seconds, between refreshing post list")
-	debugPtr := flag.Bool("d", configuration.Debug, "Whether to show debug output")
-	helpPtr := flag.Bool("h", false, "Show usage")
+			if err != nil {
+				fmt.Println(err.Error())
+			}
 
-    flag.Parse()
+		case "10", "forgot":
+			// send them their user name
+			if !available("RemindCognitoUserName") {
+				continue
+			}
 
-    // Save configuration
-    configuration.Region = *regionPtr
-    configuration.Timezone = *timezonePtr
-    configuration.MaxMessages = *maxMsgsPtr
-    configuration.RefreshSeconds = *refreshPtr
-    configuration.Debug = *debugPtr
+			if signedIn {
+				fmt.Println("You are signed in as " + userName)
+				continue
+			}
 
-    help := *helpPtr
+			err := forgotUserName(scanner)
 
-    if help {
-        usage()
-        os.Exit(0)
-    }
+			if err != nil {
+				fmt.Println(err.Error())
+			}
 
-    if configuration.Debug {
-        initLog(os.Stderr)
-    } else {
-        initLog(ioutil.Discard)
-    }
+		case "11", "mfa":
+			// add an authenticator app, or turn MFA off
+			if !available("AssociateCognitoSoftwareToken") || !available("VerifyCognitoSoftwareToken") || !available("SetCognitoUserMFAPreference") {
+				continue
+			}
 
-    Debug.Println("Region:     " + configuration.Region)
-    Debug.Println("Timezone:   " + configuration.Timezone)
-    Debug.Println("Max # msgs: " + strconv.Itoa(configuration.MaxMessages))
-    Debug.Println("Refresh:    " + strconv.Itoa(configuration.RefreshSeconds))
+			if !signedIn {
+				fmt.Println("You must be signed in to set up MFA")
+				continue
+			}
 
-	cursor := "(anonymous)> "
+			switch action {
+			case "", "setup":
+				err = setUpMFA(scanner, accessToken, userName)
 
-	// When false, stop the app
-	keepGoing := true
+			case "off":
+				err = disableMFA(scanner, accessToken)
 
-	// The name of the current user
-	userName := ""
+			default:
+				err = errors.New("Unrecognized option: " + inputValue)
+			}
 
-	// True if signed in (required to post)
-	signedIn := false
+			if err != nil {
+				fmt.Println(err.Error())
+			}
 
-	// Initialize a session that the SDK will use to load configuration,
-	// credentials, and region from the shared config file. (~/.aws/config).
-	Debug.Println("Calling Lambda function in: " + configuration.Region)
+		case "12", "password":
+			// change password
+			if !available("ChangeCognitoUserPassword") {
+				continue
+			}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	inputValue := ""
+			if !signedIn {
+				fmt.Println("You must be signed in to change your password")
+				continue
+			}
 
-	// So we can adjust prompt for two-stage processes
-	pastStep1 := false // set to true once start* completes successfully; reset to false after finish* completes successfully
-	registerPrompt := "3: Register as new user"
-	resetPasswordPrompt := "4: Reset password"
+			err := changePassword(scanner, accessToken)
 
-	var password string = ""
-	var accessToken string = ""
+			if err != nil {
+				fmt.Println(err.Error())
+			}
 
-	for keepGoing {
-		// Menu
-		fmt.Println("")
-		fmt.Println("Enter a value between 1 and 8 to perform the indicated action or q (or Q) to quit:")
-		fmt.Println("")
-		fmt.Println("1: List all posts")
-		fmt.Println("2: Sign in")
-		fmt.Println(registerPrompt)
-		fmt.Println(resetPasswordPrompt)
-		fmt.Println("5: Post a message (you must be signed in)")
-		fmt.Println("6: Sign out")
-		fmt.Println("7: Delete your account (you must be signed in)")
-		fmt.Println("8: Delete a post (you must be signed in and it must be your post)")
-		fmt.Println("q (or Q): Quit")
-		fmt.Println("")
+		case "13", "email":
+			// change email address
+			if !available("UpdateCognitoUserEmail") || !available("VerifyCognitoUserEmail") {
+				continue
+			}
 
-		inputValue = getStringValue(scanner, cursor)
+			if !signedIn {
+				fmt.Println("You must be signed in to change your email address")
+				continue
+			}
 
-		if inputValue != "q" && inputValue != "Q" && !configuration.Debug {
-			clearScreen()
-		}
+			if action == "" {
+				action = "start"
 
-		Debug.Println("Got: '" + inputValue + "'")
+				if pendingEmail != "" {
+					action = "finish"
+				}
+			}
 
-		switch inputValue {
-		case "1":
-			// Get and list all posts
-			getAndListAllPosts(configuration.MaxMessages)
+			switch action {
+			case "start":
+				var email string
 
-		case "2":
-			// sign in user
+				email, err = startEmailChange(scanner, accessToken)
 
-			// if already signed in, tell user
-			if signedIn {
-				notifySignedIn(false) // true means tell them they are already registered
+				if err != nil {
+					break
+				}
+
+				pendingEmail = email
+
+				fallthrough
+
+			case "finish":
+				if pendingEmail == "" {
+					err = errors.New("There is no email address to verify; enter email to change it")
+					break
+				}
+
+				var changed bool
+
+				changed, err = finishEmailChange(scanner, accessToken, pendingEmail)
+
+				if changed {
+					pendingEmail = ""
+				}
+
+			default:
+				err = errors.New("Unrecognized option: " + inputValue)
+			}
+
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+
+		case "14", "export":
+			// save posts
+			if !available("GetUserPosts") {
 				continue
 			}
 
-			result, err := logInUser(scanner)
+			if !signedIn {
+				fmt.Println("You must be signed in to export your posts")
+				continue
+			}
+
+			err := exportPosts(scanner, accessToken, userName)
 
 			if err != nil {
 				fmt.Println(err.Error())
-			} else {
-				signedIn = true
+			}
 
-				userName = result.userName
-				accessToken = result.accessToken
+		case "15", "edit":
+			// edit post
+			if !available("EditPost") {
+				continue
+			}
 
-				cursor = "(" + userName + ")> "
+			if !signedIn {
+				fmt.Println("You must be signed in to edit a post")
+				continue
 			}
 
-		case "3":
-			// register
+			// "edit post" asks for the ID, as does 15
+			if action == "post" {
+				action = ""
+			}
 
-			// if already signed in, tell user
-			if signedIn {
-				notifySignedIn(true) // true means tell them they are already registered
+			err := editPost(scanner, accessToken, action)
+
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+
+		case "16", "reply":
+			// reply to a post
+			if !available("AddReply") {
+				continue
+			}
+
+			if !signedIn {
+				fmt.Println("You must be signed in to reply to a post")
 				continue
 			}
 
-			result, err := registerUser(scanner, pastStep1, userName, password)
+			err := replyToPost(scanner, accessToken, action)
 
 			if err != nil {
 				fmt.Println(err.Error())
+			}
+
+		case "17", "thread":
+			// hide or show replies
+			err := toggleThread(scanner, action)
+
+			if err == nil {
+				getAndListAllPosts(configuration.MaxMessages, userName)
 			} else {
-				userName = result.userName
-				password = result.password
-				accessToken = result.accessToken
-				signedIn = result.signedIn
-				cursor = result.cursor
-				pastStep1 = result.pastStep1
-				registerPrompt = result.registerPrompt
+				fmt.Println(err.Error())
 			}
 
-		case "4":
-			// Reset password
-			if !pastStep1 {
-				userName = getStringValue(scanner, "Enter your user name:")
-				fmt.Println("")
+		case "18", "react":
+			// add or remove a reaction
+			if !available("AddReaction") || !available("RemoveReaction") {
+				continue
+			}
+
+			if !signedIn {
+				fmt.Println("You must be signed in to react to a post")
+				continue
 			}
 
-			result, err := resetPassword(scanner, pastStep1, userName)
+			err := reactToPost(scanner, accessToken, userName, action, reaction)
 
 			if err == nil {
-				cursor = result.cursor
-				pastStep1 = result.pastStep1
-				resetPasswordPrompt = result.resetPasswordPrompt
-                signedIn = result.signedIn
+				getAndListAllPosts(configuration.MaxMessages, userName)
 			} else {
 				fmt.Println(err.Error())
 			}
 
-		case "5":
-			// post message
+		case "/rooms":
+			// list rooms
+			if !available("ListRooms") {
+				continue
+			}
+
+			err := listRooms(userName)
+
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+
+		case "/join":
+			// go to a room
+			if !available("ListRooms") {
+				continue
+			}
+
+			err := joinRoom(scanner, accessToken, userName, action)
+
+			if err == nil {
+				cursor = cursorFor(userName)
+				getAndListAllPosts(configuration.MaxMessages, userName)
+			} else {
+				fmt.Println(err.Error())
+			}
+
+		case "/dms":
+			// list conversations
+			if !available("ListConversations") {
+				continue
+			}
+
 			if !signedIn {
-				fmt.Println("You must be signed in to post a message")
+				fmt.Println("You must be signed in to see your direct messages")
 				continue
 			}
 
-			err := postMessage(scanner, accessToken)
+			err := listConversations(accessToken)
 
 			if err != nil {
 				fmt.Println(err.Error())
 			}
 
-		case "6":
+		case "/dm":
+			// read and send direct messages
+			if !available("GetDirectMessages") {
+				continue
+			}
+
 			if !signedIn {
-				fmt.Println("Your are not signed in")
+				fmt.Println("You must be signed in to send direct messages")
 				continue
 			}
 
-			// sign out
-			signedIn = false
-			userName = ""
-			accessToken = ""
-			cursor = "(anonymous)> "
+			err := directMessages(scanner, accessToken, userName, action, configuration.MaxMessages)
 
-		case "7":
-			// delete account
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+
+		case "/history":
+			// list posts from the history, past MaxMessages
+			if history == nil {
+				fmt.Println("There is no history; could not open " + historyFile())
+				continue
+			}
+
+			maxMessages := -1
+
+			if action != "" {
+				n, err := strconv.Atoi(action)
+
+				if err != nil || n < 1 {
+					fmt.Println("Enter how many posts to list, such as /history 100")
+					continue
+				}
+
+				maxMessages = n
+			}
+
+			getAndListAllPosts(maxMessages, userName)
+
+		case "/search":
+			// search posts
+			err := searchPosts(query)
+
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+
+		case "/mentions":
+			// list posts that mention them
 			if !signedIn {
-				fmt.Println("You must be signed in to delete your account")
+				fmt.Println("You must be signed in to see your mentions")
 				continue
 			}
 
-			err := deleteAccount(accessToken)
+			if action != "" && action != "all" {
+				fmt.Println("Unrecognized option: " + inputValue)
+				continue
+			}
 
-			if err == nil {
-				signedIn = false
-				userName = ""
-				accessToken = ""
-				cursor = "(anonymous)> "
-			} else {
+			err := showMentions(userName, action == "all")
+
+			if err != nil {
 				fmt.Println(err.Error())
 			}
 
-		case "8":
-			// delete post
+		case "/outbox":
+			// list, retry, or discard posts that weren't sent
 			if !signedIn {
-				fmt.Println("You must be signed in to delete a post")
+				fmt.Println("You must be signed in to see your outbox")
 				continue
 			}
 
-			err := deleteMyPost(scanner, accessToken)
+			if action == "" {
+				listOutbox(userName)
+				continue
+			}
 
-			if err == nil {
-				fmt.Println("Post deleted")
-			} else {
+			err := changeOutbox(accessToken, userName, action, number)
+
+			if err != nil {
 				fmt.Println(err.Error())
 			}
 
@@ -0,0 +1,393 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Backends:
+
+  A Backend is where the chat app keeps its users and posts.
+  The clients only talk to a Backend, so they work the same with either of:
+
+  - LambdaBackend, which calls the Lambda functions in ../../setup/lambda
+    (and any others listed in FunctionNames that are deployed).
+  - MemoryBackend, which keeps everything in memory,
+    for trying out the clients and for tests.
+
+  Pick one with the Backend entry in conf.json:
+  lambda (the default) or memory.
+*/
+
+import (
+	"errors"
+	"strings"
+)
+
+type Backend interface {
+	// Make sure the backend can do everything the clients need.
+	// Returns the operations it can't do that the clients can't do without,
+	// or an error if it could not find out.
+	Check() ([]string, error)
+	// False if the backend can't do function, one of FunctionNames, since Check found it isn't deployed.
+	// The clients hide whatever needs it.
+	Supports(function string) bool
+	// Describe where the operations go, for whoami and the profile panel
+	Deployment() []string
+
+	// Get the latest maxPosts posts in room, newest first
+	GetPosts(room string, maxPosts int) ([]Post, error)
+	// Get the latest maxPosts posts in room newer than since, a Timestamp, newest first;
+	// an empty since gets the latest posts, as GetPosts does
+	GetPostsSince(room string, since string, maxPosts int) ([]Post, error)
+	// Get the oldest maxPosts posts in room newer than since, a Timestamp, oldest first,
+	// so a client can page forward through what it missed;
+	// returns ErrSinceUnsupported if the backend can't
+	GetPostsAfter(room string, since string, maxPosts int) ([]Post, error)
+	// Post to room, which the signed-in user must be a member of.
+	// A post's key is its Alias and the second it's added, so if the user
+	// already posted in this second, it fails with PostExistsCode.
+	AddPost(accessToken string, room string, message string) error
+	// Post to room, as AddPost does, unless a post with id was already added,
+	// so sending a post from the Outbox again doesn't post it twice
+	AddPostOnce(accessToken string, room string, message string, id string) error
+	// Post a reply to the post parent, in the parent's room
+	AddReply(accessToken string, parent PostKey, message string) error
+	// Delete one of the signed-in user's posts
+	DeletePost(accessToken string, timestamp string) error
+	// Replace the message of one of the signed-in user's posts,
+	// keeping the old message as a revision
+	EditPost(accessToken string, timestamp string, message string) error
+	// React to any post with one of ReactionNames, or take the reaction back;
+	// each user has at most one reaction of each kind on a post
+	AddReaction(accessToken string, post PostKey, reaction string) error
+	RemoveReaction(accessToken string, post PostKey, reaction string) error
+
+	// Sign in; if the user pool wants more, such as an MFA code,
+	// the result has a Challenge instead of tokens
+	SignIn(userName string, password []byte) (SignInResult, error)
+	// Answer a challenge with a code or, for NEW_PASSWORD_REQUIRED, a new password.
+	// The result can be another challenge.
+	RespondToChallenge(challenge Challenge, answer []byte) (SignInResult, error)
+	// Add a user who isn't confirmed, and send them a confirmation code
+	StartRegistration(userName string, password []byte, email string) (CodeDelivery, error)
+	// Confirm a user with the code we sent them
+	FinishRegistration(userName string, code string) error
+	// Send an unconfirmed user a new confirmation code
+	ResendConfirmationCode(userName string) (CodeDelivery, error)
+	// Send a user a code to reset their password
+	StartPasswordReset(userName string) (CodeDelivery, error)
+	FinishPasswordReset(userName string, code string, newPassword []byte) error
+	// Send the names of the users with email to that address
+	RemindUserName(email string) (CodeDelivery, error)
+	// Get the names of the confirmed users, so the clients can tell which @names are mentions
+	ListUserNames() ([]string, error)
+	DeleteUser(accessToken string) error
+
+	// Start adding an authenticator app: get the secret to share with it
+	StartTOTPEnrollment(accessToken string) (string, error)
+	// Check the first code from the authenticator app and, if it's right,
+	// make the app the user's MFA, so signing in asks for a code from it
+	FinishTOTPEnrollment(accessToken string, code string) error
+	// Turn off MFA, so signing in only takes a password
+	DisableMFA(accessToken string) error
+	// Get the MFA the user signs in with, such as SOFTWARE_TOKEN_MFA, or "" for none
+	GetMFA(accessToken string) (string, error)
+
+	// Change the signed-in user's password; they must know the old one
+	ChangePassword(accessToken string, oldPassword []byte, newPassword []byte) error
+	// Start changing the signed-in user's email address,
+	// which sends a code to the new address.
+	// The old address stays until they enter the code.
+	StartEmailChange(accessToken string, email string) (CodeDelivery, error)
+	FinishEmailChange(accessToken string, code string) error
+	// Get every post the signed-in user wrote, in no particular order
+	ExportPosts(accessToken string) ([]Post, error)
+
+	// Get every room, with the default room first and the rest by name
+	ListRooms() ([]Room, error)
+	// Create a room, with the signed-in user as its first member
+	CreateRoom(accessToken string, name string) error
+	// Make the signed-in user a member of a room, so they can post to it
+	JoinRoom(accessToken string, name string) error
+
+	// Send a direct message from the signed-in user to another user
+	SendDirectMessage(accessToken string, to string, message string) error
+	// Get the signed-in user's conversations, newest first
+	ListConversations(accessToken string) ([]Conversation, error)
+	// Get the latest maxMessages messages between the signed-in user and another user,
+	// newest first, and mark the ones to the signed-in user read
+	GetDirectMessages(accessToken string, with string, maxMessages int) ([]DirectMessage, error)
+}
+
+// The backend names in conf.json
+const (
+	LambdaBackendName = "lambda"
+	MemoryBackendName = "memory"
+)
+
+type Post struct {
+	Alias string
+	// Unix time, in seconds, as a string; with Alias, identifies the post
+	Timestamp string
+	Message   string
+	// Unix time, in seconds, as a string, of the last edit; empty if it was never edited
+	EditedAt string
+	// What the post said before each edit, oldest first
+	Revisions []PostRevision
+	// The post this one replies to; empty if it isn't a reply
+	Parent PostKey
+	// The room it's in; see RoomName
+	Room string
+	// The users who reacted with each reaction, by name; see ReactionCounts
+	Reactions map[string][]string
+	// The ID the client made up for it, for AddPostOnce;
+	// empty if the backend doesn't keep IDs
+	PostID string
+}
+
+// What identifies a post
+type PostKey struct {
+	Alias     string
+	Timestamp string
+}
+
+func (p Post) Key() PostKey {
+	return PostKey{Alias: p.Alias, Timestamp: p.Timestamp}
+}
+
+func (p Post) IsReply() bool {
+	return p.Parent != PostKey{}
+}
+
+// What a post said until it was edited
+type PostRevision struct {
+	Message string
+	// When the post started saying it: its Timestamp, or the EditedAt of an earlier edit
+	Time string
+}
+
+func (p Post) Edited() bool {
+	return p.EditedAt != ""
+}
+
+// What a user gets when they sign in
+type Tokens struct {
+	AccessToken  string
+	ExpiresIn    int
+	TokenType    string
+	RefreshToken string
+	IdToken      string
+}
+
+// The challenges a user pool can answer a sign-in with
+const (
+	// A code from an authenticator app
+	SoftwareTokenMFAChallenge = "SOFTWARE_TOKEN_MFA"
+	// A code sent by text message
+	SMSMFAChallenge = "SMS_MFA"
+	// A new password, to replace one an administrator set
+	NewPasswordRequiredChallenge = "NEW_PASSWORD_REQUIRED"
+)
+
+// Something a user must do to finish signing in
+type Challenge struct {
+	Name     string
+	UserName string
+	// Ties the answer to the sign-in; pass it back unchanged
+	Session string
+	// Such as CODE_DELIVERY_DESTINATION, for SMS_MFA
+	Parameters map[string]string
+}
+
+// Where the code for an SMS_MFA challenge went, masked
+func (c Challenge) Destination() string {
+	return MaskDestination(c.Parameters["CODE_DELIVERY_DESTINATION"])
+}
+
+// What the user must enter to answer the challenge
+func (c Challenge) Prompt() string {
+	switch c.Name {
+	case SoftwareTokenMFAChallenge:
+		return "Enter the code from your authenticator app"
+	case SMSMFAChallenge:
+		if destination := c.Destination(); destination != "" {
+			return "Enter the code we sent to " + destination
+		}
+
+		return "Enter the code we sent to your phone"
+	case NewPasswordRequiredChallenge:
+		return "You must choose a new password"
+	}
+
+	return "Enter your answer to the " + c.Name + " challenge"
+}
+
+// True if the answer is a password, so it must be hidden and checked against the policy
+func (c Challenge) WantsPassword() bool {
+	return c.Name == NewPasswordRequiredChallenge
+}
+
+// What signing in (or answering a challenge) gets:
+// tokens, or another challenge
+type SignInResult struct {
+	Tokens    Tokens
+	Challenge *Challenge
+}
+
+// Where a backend sent a code or a message
+type CodeDelivery struct {
+	Destination    string
+	DeliveryMedium string
+	AttributeName  string
+}
+
+// The destination, masked so it's safe to show to whoever asked
+func (d CodeDelivery) MaskedDestination() string {
+	return MaskDestination(d.Destination)
+}
+
+// Mask an email address or phone number,
+// so b***@e***.com for bob@example.com and +*******1234 for a phone number.
+// Amazon Cognito already masks destinations; we leave those alone.
+func MaskDestination(destination string) string {
+	if destination == "" || strings.Contains(destination, "***") {
+		return destination
+	}
+
+	at := strings.LastIndex(destination, "@")
+
+	if at < 0 {
+		// A phone number; show the last 4 digits
+		if len(destination) <= 4 {
+			return strings.Repeat("*", len(destination))
+		}
+
+		masked := strings.Repeat("*", len(destination)-4) + destination[len(destination)-4:]
+
+		if strings.HasPrefix(destination, "+") {
+			masked = "+" + masked[1:]
+		}
+
+		return masked
+	}
+
+	local := destination[:at]
+	domain := destination[at+1:]
+	top := ""
+
+	if dot := strings.LastIndex(domain, "."); dot >= 0 {
+		top = domain[dot:]
+		domain = domain[:dot]
+	}
+
+	return maskPart(local) + "@" + maskPart(domain) + top
+}
+
+func maskPart(part string) string {
+	if part == "" {
+		return ""
+	}
+
+	return string([]rune(part)[0]) + "***"
+}
+
+// An operation a backend refused
+type Error struct {
+	Operation  string
+	StatusCode int
+	// Such as UsernameExistsException; can be empty
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+
+	if e.Code != "" {
+		return e.Operation + " failed: " + e.Code
+	}
+
+	return e.Operation + " failed"
+}
+
+// A backend that couldn't be reached, or couldn't answer, such as with no connection,
+// so trying again later could work
+type UnreachableError struct {
+	Operation string
+	Err       error
+}
+
+func (e *UnreachableError) Error() string {
+	return "Error calling " + e.Operation + ": " + e.Err.Error()
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// Get the optional functions backend can't do, to tell the user what's hidden
+func Unsupported(backend Backend) []string {
+	var unsupported []string
+
+	for _, name := range FunctionNames {
+		if !requiredFunction(name) && !backend.Supports(name) {
+			unsupported = append(unsupported, name)
+		}
+	}
+
+	return unsupported
+}
+
+// Get the code of a backend error, or "" if err isn't one
+func ErrorCode(err error) string {
+	var backendError *Error
+
+	if errors.As(err, &backendError) {
+		return backendError.Code
+	}
+
+	return ""
+}
+
+// Create the backend named in conf.json; functions only matters to the Lambda backend
+func NewBackend(name string, region string, functions map[string]FunctionConfig, defaultRoom string) (Backend, error) {
+	if defaultRoom == "" {
+		defaultRoom = DefaultRoom
+	}
+
+	switch name {
+	case "", LambdaBackendName:
+		backend := NewLambdaBackend(region, functions)
+		backend.DefaultRoom = defaultRoom
+
+		return backend, nil
+	case MemoryBackendName:
+		backend, err := NewMemoryBackend()
+
+		if err != nil {
+			return nil, err
+		}
+
+		backend.DefaultRoom = defaultRoom
+
+		return backend, nil
+	}
+
+	return nil, errors.New("Backend must be " + LambdaBackendName + " or " + MemoryBackendName + ", not " + name)
+}
+
+// The Code of the Error AddPost and AddPostOnce fail with
+// when the user already has a post from this second
+const PostExistsCode = "ConditionalCheckFailedException"
+
+// GetPostsAfter returns this when the GetPosts function ignores Since,
+// so the only posts we can get are the latest ones
+var ErrSinceUnsupported = errors.New("The GetPosts function doesn't take Since, so it can't page through posts")
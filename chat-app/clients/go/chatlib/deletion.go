@@ -0,0 +1,179 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Deleting an account:
+
+  Both clients delete accounts with DeleteAccount, which:
+
+  1. Makes sure the user meant it: they must type their password again,
+     or the phrase from DeletionPhrase, such as "delete bob".
+     We check the password by signing in, which texts a code to users with SMS MFA,
+     so they must type the phrase (DeletionNeedsPhrase).
+  2. If they asked, deletes every post they wrote, one at a time, with DeletePost.
+     If any post can't be deleted, it stops before deleting the account,
+     since without the account nobody could delete the rest.
+  3. Deletes the user.
+
+  It returns a DeletionReport of what it removed, even when it fails part way.
+*/
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// What a user can type instead of their password to delete their account
+func DeletionPhrase(userName string) string {
+	return "delete " + userName
+}
+
+type DeletionRequest struct {
+	UserName    string
+	AccessToken string
+	// The user's password, or DeletionPhrase(UserName)
+	Confirmation []byte
+	// Also delete every post the user wrote
+	DeletePosts bool
+}
+
+type DeletionReport struct {
+	UserName string
+	// The timestamps of the posts we deleted, and of those we could not
+	DeletedPosts []string
+	FailedPosts  []string
+	// True once the user is gone
+	AccountDeleted bool
+}
+
+// The confirmation was neither the user's password nor the phrase
+var ErrDeletionNotConfirmed = errors.New("That is neither your password nor the phrase; nothing was deleted")
+
+// The user must type the phrase, since checking their password would text them a code
+var ErrDeletionNeedsPhrase = errors.New("You sign in with a code we text you, so type the phrase instead of your password; nothing was deleted")
+
+// Describe what was deleted, one sentence per line
+func (r DeletionReport) Lines() []string {
+	var lines []string
+
+	if len(r.DeletedPosts) > 0 {
+		lines = append(lines, "Deleted "+countPosts(len(r.DeletedPosts)))
+	}
+
+	if len(r.FailedPosts) > 0 {
+		lines = append(lines, "Could not delete "+countPosts(len(r.FailedPosts)))
+	}
+
+	if r.AccountDeleted {
+		lines = append(lines, "Deleted the account "+r.UserName)
+	} else {
+		lines = append(lines, "Your account "+r.UserName+" still exists")
+	}
+
+	return lines
+}
+
+func (r DeletionReport) String() string {
+	return strings.Join(r.Lines(), ". ") + "."
+}
+
+func countPosts(n int) string {
+	if n == 1 {
+		return "1 post"
+	}
+
+	return strconv.Itoa(n) + " posts"
+}
+
+// True if the user must confirm with the phrase, not their password:
+// they sign in with SMS MFA, so checking their password would text them a code.
+// If we can't tell, they must use the phrase.
+func DeletionNeedsPhrase(backend Backend, accessToken string) bool {
+	mfa, err := backend.GetMFA(accessToken)
+
+	return err != nil || mfa == SMSMFAChallenge
+}
+
+// Make sure confirmation is the phrase, or the user's password
+func confirmDeletion(backend Backend, request DeletionRequest) error {
+	if strings.TrimSpace(string(request.Confirmation)) == DeletionPhrase(request.UserName) {
+		return nil
+	}
+
+	if len(request.Confirmation) == 0 {
+		return ErrDeletionNotConfirmed
+	}
+
+	if DeletionNeedsPhrase(backend, request.AccessToken) {
+		return ErrDeletionNeedsPhrase
+	}
+
+	// A challenge, such as an MFA code, still means the password was right
+	_, err := backend.SignIn(request.UserName, request.Confirmation)
+
+	if err != nil {
+		if ErrorCode(err) == "NotAuthorizedException" {
+			return ErrDeletionNotConfirmed
+		}
+
+		return errors.New("Could not check your password: " + err.Error())
+	}
+
+	return nil
+}
+
+// Delete a user's account, and their posts if they asked, once they confirm it
+func DeleteAccount(backend Backend, request DeletionRequest) (DeletionReport, error) {
+	report := DeletionReport{UserName: request.UserName}
+
+	err := confirmDeletion(backend, request)
+
+	if err != nil {
+		return report, err
+	}
+
+	if request.DeletePosts {
+		posts, err := backend.ExportPosts(request.AccessToken)
+
+		if err != nil {
+			return report, errors.New("Could not get your posts: " + err.Error())
+		}
+
+		for _, post := range posts {
+			if post.Alias != request.UserName {
+				continue
+			}
+
+			err = backend.DeletePost(request.AccessToken, post.Timestamp)
+
+			if err != nil {
+				report.FailedPosts = append(report.FailedPosts, post.Timestamp)
+			} else {
+				report.DeletedPosts = append(report.DeletedPosts, post.Timestamp)
+			}
+		}
+
+		if len(report.FailedPosts) > 0 {
+			return report, errors.New("Stopped before deleting your account, so you can try again")
+		}
+	}
+
+	err = backend.DeleteUser(request.AccessToken)
+
+	if err != nil {
+		return report, errors.New("Could not delete account: " + err.Error())
+	}
+
+	report.AccountDeleted = true
+
+	return report, nil
+}
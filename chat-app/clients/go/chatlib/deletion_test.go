@@ -0,0 +1,64 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import "testing"
+
+func TestDeleteAccountConfirmation(t *testing.T) {
+	tests := []struct {
+		name         string
+		smsMFA       bool
+		confirmation string
+		want         error
+	}{
+		{"password", false, testPassword, nil},
+		{"phrase", false, "delete bob", nil},
+		{"wrong password", false, "Wr0ngPassword!", ErrDeletionNotConfirmed},
+		{"nothing", false, "", ErrDeletionNotConfirmed},
+		{"SMS MFA, phrase", true, "delete bob", nil},
+		{"SMS MFA, password", true, testPassword, ErrDeletionNeedsPhrase},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, _ := newTestBackend(t)
+			token := signInTestUser(t, m, "bob")
+			texts := 0
+
+			if test.smsMFA {
+				err := m.EnableSMSMFA("bob", "+15555550100")
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				m.Deliver = func(to string, subject string, body string) {
+					if to == "+15555550100" {
+						texts++
+					}
+				}
+			}
+
+			report, err := DeleteAccount(m, DeletionRequest{UserName: "bob", AccessToken: token, Confirmation: []byte(test.confirmation)})
+
+			if err != test.want {
+				t.Fatalf("got error %v, want %v", err, test.want)
+			}
+
+			if report.AccountDeleted != (test.want == nil) {
+				t.Errorf("got AccountDeleted %v", report.AccountDeleted)
+			}
+
+			if texts != 0 {
+				t.Errorf("texted %d codes", texts)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Direct messages:
+
+  A direct message goes from one user to another, and only those two can see it.
+  Direct messages aren't posts: they're kept apart from them,
+  so GetPosts, ExportPosts, and the rest never return them.
+
+  The messages between two users are a conversation.
+  ListConversations gets the signed-in user's conversations, newest first,
+  each with how many messages they haven't read;
+  GetDirectMessages gets the messages in one, and marks them read.
+*/
+
+import (
+	"sort"
+	"strconv"
+)
+
+type DirectMessage struct {
+	From string
+	To   string
+	// Unix time, in seconds, as a string
+	Timestamp string
+	Message   string
+	// True once To has seen it
+	Read bool
+}
+
+// The messages between the signed-in user and one other user
+type Conversation struct {
+	// The other user
+	With string
+	// The newest message, from either of them
+	Latest DirectMessage
+	// How many messages to the signed-in user they haven't read
+	Unread int
+}
+
+// Get the user at the other end of a message from, or to, userName
+func (m DirectMessage) Other(userName string) string {
+	if m.From == userName {
+		return m.To
+	}
+
+	return m.From
+}
+
+// Add up the unread messages in conversations
+func UnreadCount(conversations []Conversation) int {
+	count := 0
+
+	for _, c := range conversations {
+		count += c.Unread
+	}
+
+	return count
+}
+
+func messageTime(m DirectMessage) int64 {
+	seconds, _ := strconv.ParseInt(m.Timestamp, 10, 64)
+	return seconds
+}
+
+// Sort messages newest first
+func sortMessages(messages []DirectMessage) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messageTime(messages[i]) > messageTime(messages[j])
+	})
+}
+
+// Group userName's messages into conversations, newest first
+func conversations(userName string, messages []DirectMessage) []Conversation {
+	byUser := make(map[string]*Conversation)
+	var list []*Conversation
+
+	for _, m := range messages {
+		if m.From != userName && m.To != userName {
+			continue
+		}
+
+		other := m.Other(userName)
+		c, ok := byUser[other]
+
+		if !ok {
+			c = &Conversation{With: other, Latest: m}
+			byUser[other] = c
+			list = append(list, c)
+		} else if messageTime(m) >= messageTime(c.Latest) {
+			c.Latest = m
+		}
+
+		if m.To == userName && !m.Read {
+			c.Unread++
+		}
+	}
+
+	result := make([]Conversation, 0, len(list))
+
+	for _, c := range list {
+		result = append(result, *c)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return messageTime(result[i].Latest) > messageTime(result[j].Latest)
+	})
+
+	return result
+}
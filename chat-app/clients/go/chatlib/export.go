@@ -0,0 +1,107 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Exporting posts:
+
+  A user can download every post they wrote as a JSON archive:
+
+    {
+      "UserName": "bob",
+      "ExportedAt": "2017-11-01T17:04:05Z",
+      "Posts": [
+        { "Timestamp": "1509555845", "Time": "2017-11-01T17:04:05Z", "Room": "general", "Message": "Hi" }
+      ]
+    }
+
+  Posts are oldest first. Timestamp is the post's Unix time, as the backend keeps it;
+  Time is the same time in RFC 3339, for people and other tools.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+	"unicode"
+)
+
+type ArchivedPost struct {
+	Timestamp string
+	Time      time.Time
+	Room      string
+	Message   string
+}
+
+type PostArchive struct {
+	UserName   string
+	ExportedAt time.Time
+	Posts      []ArchivedPost
+}
+
+// Make an archive of userName's posts; posts by anyone else are left out
+func NewPostArchive(userName string, posts []Post, now time.Time) PostArchive {
+	archive := PostArchive{UserName: userName, ExportedAt: now.UTC().Truncate(time.Second), Posts: []ArchivedPost{}}
+
+	for _, p := range posts {
+		if p.Alias != userName {
+			continue
+		}
+
+		post := ArchivedPost{Timestamp: p.Timestamp, Room: p.Room, Message: p.Message}
+
+		if seconds, err := strconv.ParseInt(p.Timestamp, 10, 64); err == nil {
+			post.Time = time.Unix(seconds, 0).UTC()
+		}
+
+		archive.Posts = append(archive.Posts, post)
+	}
+
+	sort.SliceStable(archive.Posts, func(i, j int) bool {
+		return archive.Posts[i].Time.Before(archive.Posts[j].Time)
+	})
+
+	return archive
+}
+
+// Get the archive as indented JSON.
+// It isn't for a web page, so < and > stay as they are.
+func (a PostArchive) JSON() ([]byte, error) {
+	var data bytes.Buffer
+
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	err := encoder.Encode(a)
+
+	if err != nil {
+		return nil, errors.New("Error creating archive: " + err.Error())
+	}
+
+	return data.Bytes(), nil
+}
+
+// Get a file name for the archive, such as posts-bob-20171101.json.
+// User names can have characters file systems don't allow, so we replace them.
+func (a PostArchive) FileName() string {
+	name := []rune(a.UserName)
+
+	for i, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			name[i] = '_'
+		}
+	}
+
+	return "posts-" + string(name) + "-" + a.ExportedAt.Format("20060102") + ".json"
+}
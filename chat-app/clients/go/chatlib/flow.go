@@ -0,0 +1,196 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Two-step flows:
+
+  Registering and resetting a password each take two steps,
+  with a confirmation code sent by email in between.
+  Each is a Flow, a small state machine:
+
+    registration:   idle --Start--> pending confirmation --Finish--> idle
+    password reset: idle --Start--> pending code         --Finish--> idle
+
+  Cancel takes a pending flow back to idle, and a pending flow
+  can't be started again until it's finished or canceled.
+  The two flows are independent, so starting a reset
+  doesn't disturb a pending registration.
+
+  PendingFlows saves both flows in a file,
+  so a flow started in one run of a client can be finished in another.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+type FlowKind string
+
+const (
+	RegistrationFlow  FlowKind = "registration"
+	PasswordResetFlow FlowKind = "password reset"
+)
+
+type FlowState string
+
+const (
+	FlowIdle                        FlowState = ""
+	RegistrationPendingConfirmation FlowState = "pending confirmation"
+	ResetPendingCode                FlowState = "pending code"
+)
+
+// The state a flow of each kind waits in between its two steps
+var pendingStates = map[FlowKind]FlowState{
+	RegistrationFlow:  RegistrationPendingConfirmation,
+	PasswordResetFlow: ResetPendingCode,
+}
+
+type Flow struct {
+	Kind     FlowKind
+	State    FlowState
+	UserName string
+	// Registration only
+	Email   string `json:",omitempty"`
+	Started time.Time
+}
+
+func (f *Flow) Pending() bool {
+	return f.State != FlowIdle
+}
+
+// Check that the flow can start, before taking the first step
+func (f *Flow) CanStart() error {
+	if f.Pending() {
+		return errors.New("A " + string(f.Kind) + " for " + f.UserName + " is already " + string(f.State) + "; finish or cancel it first")
+	}
+
+	return nil
+}
+
+// Move to the pending state once the first step succeeds
+func (f *Flow) Start(userName string, email string) error {
+	err := f.CanStart()
+
+	if err != nil {
+		return err
+	}
+
+	f.State = pendingStates[f.Kind]
+	f.UserName = userName
+	f.Email = email
+	f.Started = time.Now()
+
+	return nil
+}
+
+// Go back to idle once the second step succeeds
+func (f *Flow) Finish() error {
+	if !f.Pending() {
+		return errors.New("There is no " + string(f.Kind) + " to finish")
+	}
+
+	f.reset()
+
+	return nil
+}
+
+// Go back to idle without finishing
+func (f *Flow) Cancel() error {
+	if !f.Pending() {
+		return errors.New("There is no " + string(f.Kind) + " to cancel")
+	}
+
+	f.reset()
+
+	return nil
+}
+
+func (f *Flow) reset() {
+	*f = Flow{Kind: f.Kind}
+}
+
+// Both two-step flows of a client
+type PendingFlows struct {
+	Registration  Flow
+	PasswordReset Flow
+}
+
+func NewPendingFlows() *PendingFlows {
+	return &PendingFlows{
+		Registration:  Flow{Kind: RegistrationFlow},
+		PasswordReset: Flow{Kind: PasswordResetFlow},
+	}
+}
+
+// Read the flows saved in filename.
+// If filename doesn't exist, neither flow is pending.
+func LoadPendingFlows(filename string) (*PendingFlows, error) {
+	flows := NewPendingFlows()
+
+	data, err := ioutil.ReadFile(filename)
+
+	if os.IsNotExist(err) {
+		return flows, nil
+	}
+
+	if err != nil {
+		return flows, errors.New("Error reading pending flows: " + err.Error())
+	}
+
+	err = json.Unmarshal(data, flows)
+
+	if err != nil {
+		return NewPendingFlows(), errors.New("Error parsing pending flows in " + filename + ": " + err.Error())
+	}
+
+	// Don't trust the file to have the right kinds and states
+	flows.Registration.Kind = RegistrationFlow
+	flows.PasswordReset.Kind = PasswordResetFlow
+
+	for _, flow := range []*Flow{&flows.Registration, &flows.PasswordReset} {
+		if flow.State != FlowIdle && flow.State != pendingStates[flow.Kind] {
+			flow.reset()
+		}
+	}
+
+	return flows, nil
+}
+
+// Save the flows in filename, or remove it if neither is pending
+func (p *PendingFlows) Save(filename string) error {
+	if !p.Registration.Pending() && !p.PasswordReset.Pending() {
+		err := os.Remove(filename)
+
+		if err != nil && !os.IsNotExist(err) {
+			return errors.New("Error removing pending flows: " + err.Error())
+		}
+
+		return nil
+	}
+
+	data, err := json.MarshalIndent(p, "", "    ")
+
+	if err != nil {
+		return errors.New("Error marshalling pending flows: " + err.Error())
+	}
+
+	err = ioutil.WriteFile(filename, data, 0600)
+
+	if err != nil {
+		return errors.New("Error saving pending flows: " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,374 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Post history:
+
+  The clients keep every post they've gotten in a History,
+  a SQLite database on this computer, and show the posts from there.
+  So the posts show up right away, the clients work without a connection,
+  and the history goes back further than the latest MaxMessages posts.
+
+  Sync adds a room's new posts to the history. It only asks the backend for
+  posts newer than the newest one we have (the high-water mark),
+  less HistorySyncWindow, so it also sees the latest edits and reactions.
+  It pages forward from there, oldest first, so however many posts came in
+  since the last Sync, it gets them all; only a GetPosts function without
+  Since limits it to the latest ones.
+  Any post we have in the part of the room the backend returned,
+  which the backend didn't return, was deleted, so Sync deletes it too;
+  but not in a second with more posts than a page, since it can't get them all.
+
+  Posts are keyed by (Alias, Timestamp), like PostKey,
+  and kept as JSON, so new fields in Post don't need a new table.
+*/
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// How far before the high-water mark Sync looks,
+// to catch recent posts that were edited, reacted to, or deleted
+const HistorySyncWindow = 24 * time.Hour
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS posts (
+	alias     TEXT NOT NULL,
+	timestamp TEXT NOT NULL,
+	room      TEXT NOT NULL,
+	time      INTEGER NOT NULL,
+	post      TEXT NOT NULL,
+	PRIMARY KEY (alias, timestamp)
+);
+CREATE INDEX IF NOT EXISTS posts_by_room ON posts (room, time);
+CREATE TABLE IF NOT EXISTS rooms (
+	room      TEXT PRIMARY KEY,
+	synced_at INTEGER NOT NULL
+);
+`
+
+type History struct {
+	db *sql.DB
+}
+
+// What Sync changed
+type SyncResult struct {
+	Added   int
+	Changed int
+	Deleted int
+}
+
+// Open the history in filename, creating it if it doesn't exist
+func OpenHistory(filename string) (*History, error) {
+	db, err := sql.Open("sqlite3", filename)
+
+	if err != nil {
+		return nil, errors.New("Error opening history: " + err.Error())
+	}
+
+	// Sync and reading posts can happen at once in the GUI; one connection keeps SQLite happy
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(historySchema)
+
+	if err != nil {
+		db.Close()
+		return nil, errors.New("Error creating history in " + filename + ": " + err.Error())
+	}
+
+	return &History{db: db}, nil
+}
+
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+// The Unix time of a Timestamp, or 0 if it isn't one
+func timestampSeconds(timestamp string) int64 {
+	seconds, _ := strconv.ParseInt(timestamp, 10, 64)
+	return seconds
+}
+
+// Get the latest maxPosts posts in room, newest first;
+// a negative maxPosts gets every one
+func (h *History) Posts(room string, maxPosts int) ([]Post, error) {
+	rows, err := h.db.Query("SELECT post FROM posts WHERE room = ? ORDER BY time DESC, alias LIMIT ?", room, maxPosts)
+
+	if err != nil {
+		return nil, errors.New("Error reading history: " + err.Error())
+	}
+
+	defer rows.Close()
+
+	var posts []Post
+
+	for rows.Next() {
+		var data string
+		var p Post
+
+		err = rows.Scan(&data)
+
+		if err == nil {
+			err = json.Unmarshal([]byte(data), &p)
+		}
+
+		if err != nil {
+			return posts, errors.New("Error reading history: " + err.Error())
+		}
+
+		posts = append(posts, p)
+	}
+
+	return posts, rows.Err()
+}
+
+// How many posts we have in room
+func (h *History) Count(room string) (int, error) {
+	var count int
+
+	err := h.db.QueryRow("SELECT COUNT(*) FROM posts WHERE room = ?", room).Scan(&count)
+
+	if err != nil {
+		return 0, errors.New("Error reading history: " + err.Error())
+	}
+
+	return count, nil
+}
+
+// When Sync last got room's posts; zero if it never has
+func (h *History) SyncedAt(room string) (time.Time, error) {
+	var syncedAt int64
+
+	err := h.db.QueryRow("SELECT synced_at FROM rooms WHERE room = ?", room).Scan(&syncedAt)
+
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+
+	if err != nil {
+		return time.Time{}, errors.New("Error reading history: " + err.Error())
+	}
+
+	return time.Unix(syncedAt, 0), nil
+}
+
+// Forget a post, such as one the user deleted
+func (h *History) Remove(key PostKey) error {
+	_, err := h.db.Exec("DELETE FROM posts WHERE alias = ? AND timestamp = ?", key.Alias, key.Timestamp)
+
+	if err != nil {
+		return errors.New("Error removing post from history: " + err.Error())
+	}
+
+	return nil
+}
+
+// Get room's posts from backend that are newer than the high-water mark, less HistorySyncWindow,
+// maxPosts at a time, and add, update, or delete the ones we have to match;
+// the first Sync of a room only gets the latest maxPosts posts
+func (h *History) Sync(backend Backend, room string, maxPosts int) (SyncResult, error) {
+	var result SyncResult
+	var highWater sql.NullInt64
+
+	err := h.db.QueryRow("SELECT MAX(time) FROM posts WHERE room = ?", room).Scan(&highWater)
+
+	if err != nil {
+		return result, errors.New("Error reading history: " + err.Error())
+	}
+
+	// The posts we get are everything in room after since
+	since := int64(-1)
+
+	if highWater.Valid {
+		since = highWater.Int64 - int64(HistorySyncWindow/time.Second)
+	}
+
+	var posts []Post
+	// Seconds we only got some of the posts in
+	var partial map[int64]bool
+	err = ErrSinceUnsupported
+
+	if highWater.Valid {
+		// Page forward from since, so we get every post that came in since the last Sync
+		posts, partial, err = postsAfter(backend, room, since, maxPosts)
+	}
+
+	truncated := false
+
+	if err == ErrSinceUnsupported {
+		// We can only get the latest maxPosts posts
+		after := ""
+
+		if highWater.Valid {
+			after = strconv.FormatInt(since, 10)
+		}
+
+		posts, err = backend.GetPostsSince(room, after, maxPosts)
+		truncated = maxPosts >= 0 && len(posts) >= maxPosts && len(posts) > 0
+	}
+
+	if err != nil {
+		return result, errors.New("Could not get the posts in " + room + ": " + err.Error())
+	}
+
+	// Unless we only got the latest maxPosts of them;
+	// then they're everything after the oldest one we got,
+	// and some of the posts at the same time as it
+	after := since
+	lowest := since + 1
+
+	if truncated {
+		after = postTime(posts[len(posts)-1])
+		lowest = after
+	}
+
+	tx, err := h.db.Begin()
+
+	if err != nil {
+		return result, errors.New("Error updating history: " + err.Error())
+	}
+
+	defer tx.Rollback()
+
+	// The posts we have in that part of the room, as JSON
+	have := make(map[PostKey]string)
+
+	rows, err := tx.Query("SELECT alias, timestamp, post FROM posts WHERE room = ? AND time >= ?", room, lowest)
+
+	if err != nil {
+		return result, errors.New("Error reading history: " + err.Error())
+	}
+
+	for rows.Next() {
+		var key PostKey
+		var data string
+
+		err = rows.Scan(&key.Alias, &key.Timestamp, &data)
+
+		if err != nil {
+			rows.Close()
+			return result, errors.New("Error reading history: " + err.Error())
+		}
+
+		have[key] = data
+	}
+
+	rows.Close()
+
+	for _, p := range posts {
+		data, err := json.Marshal(p)
+
+		if err != nil {
+			return result, errors.New("Error marshalling post: " + err.Error())
+		}
+
+		old, ok := have[p.Key()]
+		delete(have, p.Key())
+
+		if ok && old == string(data) {
+			continue
+		}
+
+		_, err = tx.Exec("INSERT OR REPLACE INTO posts (alias, timestamp, room, time, post) VALUES (?, ?, ?, ?, ?)",
+			p.Alias, p.Timestamp, room, postTime(p), string(data))
+
+		if err != nil {
+			return result, errors.New("Error updating history: " + err.Error())
+		}
+
+		if ok {
+			result.Changed++
+		} else {
+			result.Added++
+		}
+	}
+
+	// Whatever's left was deleted
+	for key := range have {
+		seconds := timestampSeconds(key.Timestamp)
+
+		if (truncated && seconds == after) || partial[seconds] {
+			// It might be one the backend left out
+			continue
+		}
+
+		_, err = tx.Exec("DELETE FROM posts WHERE alias = ? AND timestamp = ?", key.Alias, key.Timestamp)
+
+		if err != nil {
+			return result, errors.New("Error updating history: " + err.Error())
+		}
+
+		result.Deleted++
+	}
+
+	_, err = tx.Exec("INSERT OR REPLACE INTO rooms (room, synced_at) VALUES (?, ?)", room, time.Now().Unix())
+
+	if err != nil {
+		return result, errors.New("Error updating history: " + err.Error())
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return result, errors.New("Error updating history: " + err.Error())
+	}
+
+	return result, nil
+}
+
+// Get all of room's posts newer than since from backend, oldest first, a page of maxPosts at a time
+func postsAfter(backend Backend, room string, since int64, maxPosts int) ([]Post, map[int64]bool, error) {
+	var posts []Post
+	index := make(map[PostKey]int)
+	partial := make(map[int64]bool)
+	after := since
+
+	for {
+		page, err := backend.GetPostsAfter(room, strconv.FormatInt(after, 10), maxPosts)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, p := range page {
+			if i, ok := index[p.Key()]; ok {
+				posts[i] = p
+				continue
+			}
+
+			index[p.Key()] = len(posts)
+			posts = append(posts, p)
+		}
+
+		if len(page) == 0 || maxPosts < 0 || len(page) < maxPosts {
+			return posts, partial, nil
+		}
+
+		// The page might have cut off some of the posts in its last second,
+		// so the next one starts with that second again
+		last := postTime(page[len(page)-1])
+
+		if last-1 > after {
+			after = last - 1
+		} else {
+			// More than maxPosts posts in one second; we can't get the rest of them,
+			// so Sync mustn't take them for deleted
+			partial[last] = true
+			after = last
+		}
+	}
+}
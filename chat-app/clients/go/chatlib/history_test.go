@@ -0,0 +1,253 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// What a History.Sync test does to the memory backend
+type syncTest struct {
+	t      *testing.T
+	m      *MemoryBackend
+	now    *time.Time
+	tokens map[string]string
+	// The Timestamp of each message posted
+	posted map[string]string
+}
+
+// Post message as user, a second after the last post
+func (s *syncTest) post(user string, message string) {
+	*s.now = s.now.Add(time.Second)
+	s.postNow(user, message)
+}
+
+// Post message as user, at the same time as the last post
+func (s *syncTest) postNow(user string, message string) {
+	err := s.m.AddPost(s.tokens[user], s.m.DefaultRoom, message)
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	s.posted[message] = strconv.FormatInt(s.now.Unix(), 10)
+}
+
+// Sign user in again, once their token has expired
+func (s *syncTest) signIn(user string) {
+	result, err := s.m.SignIn(user, []byte(testPassword))
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	s.tokens[user] = result.Tokens.AccessToken
+}
+
+func (s *syncTest) edit(user string, message string, newMessage string) {
+	err := s.m.EditPost(s.tokens[user], s.posted[message], newMessage)
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *syncTest) remove(user string, message string) {
+	err := s.m.DeletePost(s.tokens[user], s.posted[message])
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func TestHistorySync(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxPosts int
+		// What happens before the first Sync, and between it and the second
+		before func(s *syncTest)
+		after  func(s *syncTest)
+		// What the second Sync does, and the messages in the history then, newest first
+		want      SyncResult
+		wantPosts []string
+	}{
+		{"new posts", 10, func(s *syncTest) {
+			s.post("alice", "a1")
+			s.post("bob", "b1")
+		}, func(s *syncTest) {
+			s.post("alice", "a2")
+			s.post("bob", "b2")
+		}, SyncResult{Added: 2}, []string{"b2", "a2", "b1", "a1"}},
+		{"first sync only gets the latest", 2, func(s *syncTest) {
+			s.post("alice", "a1")
+			s.post("alice", "a2")
+			s.post("alice", "a3")
+		}, nil, SyncResult{Added: 1}, []string{"a3", "a2", "a1"}},
+		{"more than maxPosts between syncs", 2, func(s *syncTest) {
+			s.post("alice", "a1")
+		}, func(s *syncTest) {
+			for _, message := range []string{"a2", "a3", "a4", "a5", "a6"} {
+				s.post("alice", message)
+			}
+		}, SyncResult{Added: 5}, []string{"a6", "a5", "a4", "a3", "a2", "a1"}},
+		{"same second across pages", 2, func(s *syncTest) {
+			s.post("alice", "a1")
+		}, func(s *syncTest) {
+			s.post("alice", "a2")
+			s.post("alice", "a3")
+			s.postNow("bob", "b3")
+			s.post("carol", "c4")
+		}, SyncResult{Added: 4}, []string{"c4", "a3", "b3", "a2", "a1"}},
+		{"edited", 10, func(s *syncTest) {
+			s.post("alice", "a1")
+			s.post("bob", "b1")
+		}, func(s *syncTest) {
+			s.edit("alice", "a1", "a1 again")
+		}, SyncResult{Changed: 1}, []string{"b1", "a1 again"}},
+		{"deleted", 10, func(s *syncTest) {
+			s.post("alice", "a1")
+			s.post("bob", "b1")
+		}, func(s *syncTest) {
+			s.remove("alice", "a1")
+		}, SyncResult{Deleted: 1}, []string{"b1"}},
+		{"deleted while paging", 2, func(s *syncTest) {
+			s.post("alice", "a1")
+			s.post("alice", "a2")
+		}, func(s *syncTest) {
+			s.remove("alice", "a1")
+			s.post("alice", "a3")
+			s.post("alice", "a4")
+			s.post("alice", "a5")
+		}, SyncResult{Added: 3, Deleted: 1}, []string{"a5", "a4", "a3", "a2"}},
+		{"deleted before the window", 10, func(s *syncTest) {
+			s.post("alice", "a1")
+			*s.now = s.now.Add(2 * HistorySyncWindow)
+			s.signIn("alice")
+			s.signIn("bob")
+			s.post("alice", "a2")
+		}, func(s *syncTest) {
+			s.remove("alice", "a1")
+			s.post("bob", "b2")
+		}, SyncResult{Added: 1}, []string{"b2", "a2", "a1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, now := newTestBackend(t)
+			s := &syncTest{t: t, m: m, now: now, tokens: make(map[string]string), posted: make(map[string]string)}
+
+			for _, name := range []string{"alice", "bob", "carol"} {
+				s.tokens[name] = signInTestUser(t, m, name)
+			}
+
+			h, err := OpenHistory(filepath.Join(t.TempDir(), "history.db"))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer h.Close()
+
+			room := m.DefaultRoom
+			test.before(s)
+
+			_, err = h.Sync(m, room, test.maxPosts)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.after != nil {
+				test.after(s)
+			}
+
+			result, err := h.Sync(m, room, test.maxPosts)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result != test.want {
+				t.Errorf("got %+v, want %+v", result, test.want)
+			}
+
+			posts, err := h.Posts(room, -1)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+
+			for _, p := range posts {
+				got = append(got, p.Message)
+			}
+
+			if !sameStrings(got, test.wantPosts) {
+				t.Errorf("got posts %v, want %v", got, test.wantPosts)
+			}
+		})
+	}
+}
+
+func TestHistorySyncBusySecond(t *testing.T) {
+	m, now := newTestBackend(t)
+	s := &syncTest{t: t, m: m, now: now, tokens: make(map[string]string), posted: make(map[string]string)}
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+		s.tokens[name] = signInTestUser(t, m, name)
+	}
+
+	s.post("alice", "a1")
+	s.post("alice", "a2")
+	s.postNow("bob", "b2")
+	s.postNow("carol", "c2")
+
+	h, err := OpenHistory(filepath.Join(t.TempDir(), "history.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer h.Close()
+
+	room := m.DefaultRoom
+
+	_, err = h.Sync(m, room, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages of 2 can't hold the 3 posts in the second one,
+	// so whichever one the backend leaves out mustn't be taken for deleted
+	result, err := h.Sync(m, room, 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != (SyncResult{}) {
+		t.Errorf("got %+v, want nothing", result)
+	}
+
+	posts, err := h.Posts(room, -1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posts) != 4 {
+		t.Errorf("got %d posts, want 4", len(posts))
+	}
+}
@@ -0,0 +1,964 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  The Lambda backend:
+
+  Every operation invokes a Lambda function with a JSON request,
+  and every function responds with:
+
+    {
+      "statusCode": 200,
+      "headers": { "Content-Type": "application/json" },
+      "body": { "result": "success", "data": ... }
+    }
+
+  or, if it fails, a statusCode other than 200 and
+  "body": { "result": "failure", "error": { "message": ..., "code": ... } }.
+  (Some functions return the error as a string.)
+
+  Functions maps the names in FunctionNames to the deployed functions.
+
+  Only the functions in RequiredFunctionNames are in ../../setup/lambda;
+  the rest are optional. Check finds out which of them are deployed,
+  and the clients hide whatever needs one that isn't (see Supports).
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// A Lambda function as deployed.
+// Qualifier is optional and selects a version or alias of the function.
+type FunctionConfig struct {
+	FunctionName string
+	Qualifier    string
+}
+
+func (f FunctionConfig) String() string {
+	if f.Qualifier == "" {
+		return f.FunctionName
+	}
+
+	return f.FunctionName + ":" + f.Qualifier
+}
+
+// The Lambda functions the backend calls
+var FunctionNames = []string{
+	"AddPost",
+	"AddReaction",
+	"AddReply",
+	"AssociateCognitoSoftwareToken",
+	"ChangeCognitoUserPassword",
+	"CreateRoom",
+	"DeleteCognitoUser",
+	"DeletePost",
+	"EditPost",
+	"FinishAddingPendingCognitoUser",
+	"FinishChangingForgottenCognitoUserPassword",
+	"GetCognitoUser",
+	"GetDirectMessages",
+	"GetPosts",
+	"GetUserPosts",
+	"JoinRoom",
+	"ListCognitoUsers",
+	"ListConversations",
+	"ListRooms",
+	"RemindCognitoUserName",
+	"RemoveReaction",
+	"ResendPendingCognitoUserCode",
+	"RespondToCognitoAuthChallenge",
+	"SendDirectMessage",
+	"SetCognitoUserMFAPreference",
+	"SignInCognitoUser",
+	"StartAddingPendingCognitoUser",
+	"StartChangingForgottenCognitoUserPassword",
+	"UpdateCognitoUserEmail",
+	"VerifyCognitoSoftwareToken",
+	"VerifyCognitoUserEmail",
+}
+
+// The functions in ../../setup/lambda, which the clients can't do without
+var RequiredFunctionNames = []string{
+	"AddPost",
+	"DeleteCognitoUser",
+	"DeletePost",
+	"FinishAddingPendingCognitoUser",
+	"FinishChangingForgottenCognitoUserPassword",
+	"GetPosts",
+	"SignInCognitoUser",
+	"StartAddingPendingCognitoUser",
+	"StartChangingForgottenCognitoUserPassword",
+}
+
+func requiredFunction(name string) bool {
+	for _, required := range RequiredFunctionNames {
+		if name == required {
+			return true
+		}
+	}
+
+	return false
+}
+
+type LambdaBackend struct {
+	client    *lambda.Lambda
+	region    string
+	functions map[string]FunctionConfig
+	// The functions Check found aren't deployed
+	missing      map[string]bool
+	missingMutex sync.Mutex
+	// The room everyone is in, and where posts without a room are;
+	// the functions must treat it the same way
+	DefaultRoom string
+	// Gets the raw requests (without passwords) and responses
+	Debug *log.Logger
+}
+
+// Create a backend that calls the functions in region.
+// Credentials come from the shared config file (~/.aws/config).
+func NewLambdaBackend(region string, functions map[string]FunctionConfig) *LambdaBackend {
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+
+	return &LambdaBackend{
+		client:      lambda.New(sess, &aws.Config{Region: aws.String(region)}),
+		region:      region,
+		functions:   functions,
+		DefaultRoom: DefaultRoom,
+		Debug:       log.New(ioutil.Discard, "", 0),
+		missing:     make(map[string]bool),
+	}
+}
+
+// Get the deployed function for name;
+// if it isn't in the configuration, it's deployed as name
+func (b *LambdaBackend) Function(name string) FunctionConfig {
+	function, ok := b.functions[name]
+
+	if !ok || function.FunctionName == "" {
+		function.FunctionName = name
+	}
+
+	return function
+}
+
+// Find out which of the functions we call exist.
+// Returns the required ones that don't; Supports is false for any optional one that doesn't.
+func (b *LambdaBackend) Check() ([]string, error) {
+	var missing []string
+
+	for _, name := range FunctionNames {
+		function := b.Function(name)
+
+		input := &lambda.GetFunctionInput{FunctionName: aws.String(function.FunctionName)}
+
+		if function.Qualifier != "" {
+			input.Qualifier = aws.String(function.Qualifier)
+		}
+
+		b.Debug.Println("Checking for function " + function.String())
+
+		_, err := b.client.GetFunction(input)
+
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == lambda.ErrCodeResourceNotFoundException {
+				b.missingMutex.Lock()
+				b.missing[name] = true
+				b.missingMutex.Unlock()
+
+				if requiredFunction(name) {
+					missing = append(missing, name+" ("+function.String()+")")
+				}
+
+				continue
+			}
+
+			return missing, errors.New("Error checking for function " + function.String() + ": " + err.Error())
+		}
+	}
+
+	return missing, nil
+}
+
+// False if Check found function isn't deployed
+func (b *LambdaBackend) Supports(function string) bool {
+	b.missingMutex.Lock()
+	defer b.missingMutex.Unlock()
+
+	return !b.missing[function]
+}
+
+// Get the functions that aren't deployed under their own names
+func (b *LambdaBackend) Deployment() []string {
+	var functions []string
+
+	for _, name := range FunctionNames {
+		function := b.Function(name)
+
+		if function.String() != name {
+			functions = append(functions, name+": "+function.String())
+		}
+	}
+
+	return functions
+}
+
+type lambdaResponseBody struct {
+	Result string          `json:"result"`
+	Data   json.RawMessage `json:"data"`
+	Error  json.RawMessage `json:"error"`
+}
+
+type lambdaResponse struct {
+	StatusCode int                `json:"statusCode"`
+	Body       lambdaResponseBody `json:"body"`
+}
+
+type lambdaError struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+// A password, or other secret, that invokeSecrets adds to a request
+type secretField struct {
+	Name  string
+	Value []byte
+}
+
+// Invoke function name with request, plus secretName: secret if secretName isn't empty,
+// and unmarshal the data in the response into data, if it isn't nil
+func (b *LambdaBackend) invoke(name string, request interface{}, secretName string, secret []byte, data interface{}) error {
+	var secrets []secretField
+
+	if secretName != "" {
+		secrets = append(secrets, secretField{secretName, secret})
+	}
+
+	return b.invokeSecrets(name, request, secrets, data)
+}
+
+// True if err, from Invoke, means Lambda couldn't be reached or couldn't answer:
+// a network error or timeout, or a status code of 0 or 500 and up.
+// Anything else, such as missing credentials or a function that isn't there,
+// fails the same way the next time.
+func lambdaUnreachable(err error) bool {
+	if failure, ok := err.(awserr.RequestFailure); ok {
+		return failure.StatusCode() == 0 || failure.StatusCode() >= 500
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == request.ErrCodeRequestError || aerr.Code() == request.ErrCodeResponseTimeout
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr)
+}
+
+// Invoke function name with request plus every secret,
+// and unmarshal the data in the response into data, if it isn't nil
+func (b *LambdaBackend) invokeSecrets(name string, request interface{}, secrets []secretField, data interface{}) error {
+	payload, err := json.Marshal(request)
+
+	if err != nil {
+		return errors.New("Error marshalling " + name + " request: " + err.Error())
+	}
+
+	b.Debug.Println("Raw request to " + name + ":")
+	b.Debug.Println(string(payload))
+
+	for i, secret := range secrets {
+		withSecret := AppendSecret(payload, secret.Name, secret.Value)
+
+		// The payload before this one already has a secret
+		if i > 0 {
+			ZeroBytes(payload)
+		}
+
+		payload = withSecret
+	}
+
+	if len(secrets) > 0 {
+		defer ZeroBytes(payload)
+	}
+
+	if !b.Supports(name) {
+		return &Error{Operation: name, StatusCode: 404, Code: lambda.ErrCodeResourceNotFoundException,
+			Message: "The " + name + " Lambda function isn't deployed (" + b.Function(name).String() + ")"}
+	}
+
+	function := b.Function(name)
+
+	input := &lambda.InvokeInput{FunctionName: aws.String(function.FunctionName), Payload: payload}
+
+	if function.Qualifier != "" {
+		input.Qualifier = aws.String(function.Qualifier)
+	}
+
+	result, err := b.client.Invoke(input)
+
+	if err != nil && lambdaUnreachable(err) {
+		return &UnreachableError{Operation: name, Err: err}
+	}
+
+	if err != nil {
+		return errors.New("Error calling " + name + ": " + err.Error())
+	}
+
+	b.Debug.Println("")
+	b.Debug.Println("Raw response from " + name + ":")
+	b.Debug.Println(string(result.Payload))
+	b.Debug.Println("")
+
+	var resp lambdaResponse
+
+	err = json.Unmarshal(result.Payload, &resp)
+
+	if err != nil {
+		return errors.New("Error unmarshalling " + name + " response: " + err.Error())
+	}
+
+	if resp.StatusCode != 200 || resp.Body.Result == "failure" {
+		failure := &Error{Operation: name, StatusCode: resp.StatusCode}
+
+		var lambdaErr lambdaError
+
+		if json.Unmarshal(resp.Body.Error, &lambdaErr) == nil {
+			failure.Code = lambdaErr.Code
+			failure.Message = lambdaErr.Message
+		} else {
+			json.Unmarshal(resp.Body.Error, &failure.Message)
+		}
+
+		if failure.Message == "" && failure.Code == "" {
+			failure.Message = "Got status code " + strconv.Itoa(resp.StatusCode) + " from " + name
+		}
+
+		return failure
+	}
+
+	if data != nil && len(resp.Body.Data) > 0 {
+		err = json.Unmarshal(resp.Body.Data, data)
+
+		if err != nil {
+			return errors.New("Error unmarshalling " + name + " data: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// A string attribute of a DynamoDB item
+type lambdaString struct {
+	S string
+}
+
+// A revision in the Revisions list attribute of a post
+type lambdaRevision struct {
+	M struct {
+		Message lambdaString
+		Time    lambdaString
+	}
+}
+
+type lambdaPost struct {
+	Alias     lambdaString
+	Timestamp lambdaString
+	Message   lambdaString
+	// Only set once the post is edited
+	EditedAt  lambdaString
+	Revisions struct {
+		L []lambdaRevision
+	}
+	// Only set for replies
+	ParentAlias     lambdaString
+	ParentTimestamp lambdaString
+	// Not set for posts from before there were rooms
+	Room lambdaString
+	// A map of string sets, such as {"tada": {"SS": ["bob"]}}
+	Reactions struct {
+		M map[string]struct {
+			SS []string
+		}
+	}
+	// Only set for posts from AddPostOnce, by an AddPost that keeps it
+	PostID lambdaString
+}
+
+type getPostsRequest struct {
+	SortBy     string
+	SortOrder  string
+	PostsToGet int
+	Room       string
+}
+
+// Posts without a room are in the default room
+func (b *LambdaBackend) lambdaPosts(items []lambdaPost) []Post {
+	var posts []Post
+
+	for _, item := range items {
+		post := Post{Alias: item.Alias.S, Timestamp: item.Timestamp.S, Message: item.Message.S, EditedAt: item.EditedAt.S}
+		post.Parent = PostKey{Alias: item.ParentAlias.S, Timestamp: item.ParentTimestamp.S}
+		post.Room = item.Room.S
+		post.PostID = item.PostID.S
+
+		if post.Room == "" {
+			post.Room = b.DefaultRoom
+		}
+
+		for _, revision := range item.Revisions.L {
+			post.Revisions = append(post.Revisions, PostRevision{Message: revision.M.Message.S, Time: revision.M.Time.S})
+		}
+
+		for name, users := range item.Reactions.M {
+			if len(users.SS) > 0 {
+				if post.Reactions == nil {
+					post.Reactions = make(map[string][]string)
+				}
+
+				post.Reactions[name] = users.SS
+			}
+		}
+
+		posts = append(posts, post)
+	}
+
+	return posts
+}
+
+func (b *LambdaBackend) GetPosts(room string, maxPosts int) ([]Post, error) {
+	var items []lambdaPost
+
+	err := b.invoke("GetPosts", getPostsRequest{"timestamp", "descending", maxPosts, room}, "", nil, &items)
+
+	// A function from before there were rooms returns every post
+	var posts []Post
+
+	for _, post := range b.lambdaPosts(items) {
+		if post.Room == room {
+			posts = append(posts, post)
+		}
+	}
+
+	return posts, err
+}
+
+type getPostsSinceRequest struct {
+	SortBy     string
+	SortOrder  string
+	PostsToGet int
+	Room       string
+	Since      string
+}
+
+func (b *LambdaBackend) GetPostsSince(room string, since string, maxPosts int) ([]Post, error) {
+	if since == "" {
+		return b.GetPosts(room, maxPosts)
+	}
+
+	var items []lambdaPost
+
+	err := b.invoke("GetPosts", getPostsSinceRequest{"timestamp", "descending", maxPosts, room, since}, "", nil, &items)
+
+	// A function from before Since returns the latest posts
+	var posts []Post
+
+	for _, post := range b.lambdaPosts(items) {
+		if post.Room == room && postTime(post) > timestampSeconds(since) {
+			posts = append(posts, post)
+		}
+	}
+
+	return posts, err
+}
+
+func (b *LambdaBackend) GetPostsAfter(room string, since string, maxPosts int) ([]Post, error) {
+	var items []lambdaPost
+
+	err := b.invoke("GetPosts", getPostsSinceRequest{"timestamp", "ascending", maxPosts, room, since}, "", nil, &items)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var posts []Post
+
+	for _, post := range b.lambdaPosts(items) {
+		// A function from before Since returns the oldest posts of all
+		if postTime(post) <= timestampSeconds(since) {
+			return nil, ErrSinceUnsupported
+		}
+
+		if post.Room == room {
+			posts = append(posts, post)
+		}
+	}
+
+	return posts, nil
+}
+
+type addPostRequest struct {
+	AccessToken string
+	Message     string
+	Room        string
+}
+
+func (b *LambdaBackend) AddPost(accessToken string, room string, message string) error {
+	return b.invoke("AddPost", addPostRequest{accessToken, message, room}, "", nil, nil)
+}
+
+type addPostOnceRequest struct {
+	AccessToken string
+	Message     string
+	Room        string
+	PostID      string
+}
+
+func (b *LambdaBackend) AddPostOnce(accessToken string, room string, message string, id string) error {
+	return b.invoke("AddPost", addPostOnceRequest{accessToken, message, room, id}, "", nil, nil)
+}
+
+type addReplyRequest struct {
+	AccessToken     string
+	Message         string
+	ParentAlias     string
+	ParentTimestamp string
+}
+
+func (b *LambdaBackend) AddReply(accessToken string, parent PostKey, message string) error {
+	return b.invoke("AddReply", addReplyRequest{accessToken, message, parent.Alias, parent.Timestamp}, "", nil, nil)
+}
+
+type deletePostRequest struct {
+	AccessToken     string
+	TimestampOfPost string
+}
+
+func (b *LambdaBackend) DeletePost(accessToken string, timestamp string) error {
+	return b.invoke("DeletePost", deletePostRequest{accessToken, timestamp}, "", nil, nil)
+}
+
+// A room in the Rooms table
+type lambdaRoom struct {
+	Name      lambdaString
+	CreatedBy lambdaString
+	CreatedAt lambdaString
+	// A string set
+	Members struct {
+		SS []string
+	}
+}
+
+func (b *LambdaBackend) ListRooms() ([]Room, error) {
+	var items []lambdaRoom
+
+	// Without a Rooms table there's only the room everyone is in,
+	// which is where every post goes
+	if !b.Supports("ListRooms") {
+		return withDefaultRoom(nil, b.DefaultRoom), nil
+	}
+
+	err := b.invoke("ListRooms", struct{}{}, "", nil, &items)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rooms []Room
+
+	for _, item := range items {
+		rooms = append(rooms, Room{Name: item.Name.S, CreatedBy: item.CreatedBy.S, CreatedAt: item.CreatedAt.S, Members: item.Members.SS})
+	}
+
+	return withDefaultRoom(rooms, b.DefaultRoom), nil
+}
+
+type roomRequest struct {
+	AccessToken string
+	Name        string
+}
+
+func (b *LambdaBackend) CreateRoom(accessToken string, name string) error {
+	return b.invoke("CreateRoom", roomRequest{accessToken, name}, "", nil, nil)
+}
+
+func (b *LambdaBackend) JoinRoom(accessToken string, name string) error {
+	return b.invoke("JoinRoom", roomRequest{accessToken, name}, "", nil, nil)
+}
+
+// A message in the DirectMessages table
+type lambdaDirectMessage struct {
+	From      lambdaString
+	To        lambdaString
+	Timestamp lambdaString
+	Message   lambdaString
+	Read      struct {
+		BOOL bool
+	}
+}
+
+func (item lambdaDirectMessage) directMessage() DirectMessage {
+	return DirectMessage{From: item.From.S, To: item.To.S, Timestamp: item.Timestamp.S, Message: item.Message.S, Read: item.Read.BOOL}
+}
+
+type sendDirectMessageRequest struct {
+	AccessToken string
+	To          string
+	Message     string
+}
+
+func (b *LambdaBackend) SendDirectMessage(accessToken string, to string, message string) error {
+	return b.invoke("SendDirectMessage", sendDirectMessageRequest{accessToken, to, message}, "", nil, nil)
+}
+
+// A conversation, as ListConversations counts it
+type lambdaConversation struct {
+	With   string
+	Unread int
+	Latest lambdaDirectMessage
+}
+
+func (b *LambdaBackend) ListConversations(accessToken string) ([]Conversation, error) {
+	var items []lambdaConversation
+
+	err := b.invoke("ListConversations", accessTokenRequest{accessToken}, "", nil, &items)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Conversation
+
+	for _, item := range items {
+		result = append(result, Conversation{With: item.With, Unread: item.Unread, Latest: item.Latest.directMessage()})
+	}
+
+	return result, nil
+}
+
+type getDirectMessagesRequest struct {
+	AccessToken   string
+	With          string
+	MessagesToGet int
+}
+
+func (b *LambdaBackend) GetDirectMessages(accessToken string, with string, maxMessages int) ([]DirectMessage, error) {
+	var items []lambdaDirectMessage
+
+	err := b.invoke("GetDirectMessages", getDirectMessagesRequest{accessToken, with, maxMessages}, "", nil, &items)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []DirectMessage
+
+	for _, item := range items {
+		messages = append(messages, item.directMessage())
+	}
+
+	sortMessages(messages)
+
+	return messages, nil
+}
+
+type reactionRequest struct {
+	AccessToken string
+	Alias       string
+	Timestamp   string
+	Reaction    string
+}
+
+func (b *LambdaBackend) AddReaction(accessToken string, post PostKey, reaction string) error {
+	return b.invoke("AddReaction", reactionRequest{accessToken, post.Alias, post.Timestamp, reaction}, "", nil, nil)
+}
+
+func (b *LambdaBackend) RemoveReaction(accessToken string, post PostKey, reaction string) error {
+	return b.invoke("RemoveReaction", reactionRequest{accessToken, post.Alias, post.Timestamp, reaction}, "", nil, nil)
+}
+
+type editPostRequest struct {
+	AccessToken     string
+	TimestampOfPost string
+	Message         string
+}
+
+func (b *LambdaBackend) EditPost(accessToken string, timestamp string, message string) error {
+	return b.invoke("EditPost", editPostRequest{accessToken, timestamp, message}, "", nil, nil)
+}
+
+// appendSecret adds the Password
+type signInRequest struct {
+	UserName string
+}
+
+// What AdminInitiateAuth and AdminRespondToAuthChallenge return
+type signInData struct {
+	// Empty unless there's a challenge
+	ChallengeName        string
+	Session              string
+	ChallengeParameters  map[string]string
+	AuthenticationResult Tokens
+}
+
+func (d signInData) result(userName string) SignInResult {
+	if d.ChallengeName == "" {
+		return SignInResult{Tokens: d.AuthenticationResult}
+	}
+
+	return SignInResult{Challenge: &Challenge{
+		Name:       d.ChallengeName,
+		UserName:   userName,
+		Session:    d.Session,
+		Parameters: d.ChallengeParameters,
+	}}
+}
+
+func (b *LambdaBackend) SignIn(userName string, password []byte) (SignInResult, error) {
+	var data signInData
+
+	err := b.invoke("SignInCognitoUser", signInRequest{userName}, "Password", password, &data)
+
+	return data.result(userName), err
+}
+
+// appendSecret adds the Answer: the code, or the new password
+type challengeRequest struct {
+	UserName      string
+	ChallengeName string
+	Session       string
+}
+
+func (b *LambdaBackend) RespondToChallenge(challenge Challenge, answer []byte) (SignInResult, error) {
+	var data signInData
+
+	request := challengeRequest{challenge.UserName, challenge.Name, challenge.Session}
+
+	err := b.invoke("RespondToCognitoAuthChallenge", request, "Answer", answer, &data)
+
+	return data.result(challenge.UserName), err
+}
+
+// appendSecret adds the Password
+type startRegistrationRequest struct {
+	UserName string
+	Email    string
+}
+
+type codeDeliveryData struct {
+	UserConfirmed       bool
+	CodeDeliveryDetails CodeDelivery
+}
+
+func (b *LambdaBackend) StartRegistration(userName string, password []byte, email string) (CodeDelivery, error) {
+	var data codeDeliveryData
+
+	err := b.invoke("StartAddingPendingCognitoUser", startRegistrationRequest{userName, email}, "Password", password, &data)
+
+	return data.CodeDeliveryDetails, err
+}
+
+type confirmationRequest struct {
+	UserName         string
+	ConfirmationCode string
+}
+
+func (b *LambdaBackend) FinishRegistration(userName string, code string) error {
+	return b.invoke("FinishAddingPendingCognitoUser", confirmationRequest{userName, code}, "", nil, nil)
+}
+
+type userNameRequest struct {
+	UserName string
+}
+
+func (b *LambdaBackend) ResendConfirmationCode(userName string) (CodeDelivery, error) {
+	var data codeDeliveryData
+
+	err := b.invoke("ResendPendingCognitoUserCode", userNameRequest{userName}, "", nil, &data)
+
+	return data.CodeDeliveryDetails, err
+}
+
+func (b *LambdaBackend) StartPasswordReset(userName string) (CodeDelivery, error) {
+	var data codeDeliveryData
+
+	err := b.invoke("StartChangingForgottenCognitoUserPassword", userNameRequest{userName}, "", nil, &data)
+
+	return data.CodeDeliveryDetails, err
+}
+
+// appendSecret adds the NewPassword
+func (b *LambdaBackend) FinishPasswordReset(userName string, code string, newPassword []byte) error {
+	return b.invoke("FinishChangingForgottenCognitoUserPassword", confirmationRequest{userName, code}, "NewPassword", newPassword, nil)
+}
+
+type emailRequest struct {
+	Email string
+}
+
+func (b *LambdaBackend) RemindUserName(email string) (CodeDelivery, error) {
+	var data codeDeliveryData
+
+	err := b.invoke("RemindCognitoUserName", emailRequest{email}, "", nil, &data)
+
+	return data.CodeDeliveryDetails, err
+}
+
+type accessTokenRequest struct {
+	AccessToken string
+}
+
+func (b *LambdaBackend) ListUserNames() ([]string, error) {
+	var names []string
+
+	err := b.invoke("ListCognitoUsers", struct{}{}, "", nil, &names)
+
+	return names, err
+}
+
+func (b *LambdaBackend) DeleteUser(accessToken string) error {
+	return b.invoke("DeleteCognitoUser", accessTokenRequest{accessToken}, "", nil, nil)
+}
+
+type associateSoftwareTokenData struct {
+	SecretCode string
+}
+
+func (b *LambdaBackend) StartTOTPEnrollment(accessToken string) (string, error) {
+	var data associateSoftwareTokenData
+
+	err := b.invoke("AssociateCognitoSoftwareToken", accessTokenRequest{accessToken}, "", nil, &data)
+
+	return data.SecretCode, err
+}
+
+type verifySoftwareTokenRequest struct {
+	AccessToken        string
+	UserCode           string
+	FriendlyDeviceName string
+}
+
+type verifySoftwareTokenData struct {
+	// SUCCESS or ERROR
+	Status string
+}
+
+// The MFA settings of SetUserMFAPreference
+type mfaSettings struct {
+	Enabled      bool
+	PreferredMfa bool
+}
+
+type mfaPreferenceRequest struct {
+	AccessToken              string
+	SMSMfaSettings           mfaSettings
+	SoftwareTokenMfaSettings mfaSettings
+}
+
+func (b *LambdaBackend) FinishTOTPEnrollment(accessToken string, code string) error {
+	var data verifySoftwareTokenData
+
+	err := b.invoke("VerifyCognitoSoftwareToken", verifySoftwareTokenRequest{accessToken, code, "Authenticator app"}, "", nil, &data)
+
+	if err != nil {
+		return err
+	}
+
+	if data.Status != "" && data.Status != "SUCCESS" {
+		return &Error{Operation: "VerifyCognitoSoftwareToken", StatusCode: 400, Code: "EnableSoftwareTokenMFAException", Message: "Code mismatch"}
+	}
+
+	request := mfaPreferenceRequest{AccessToken: accessToken, SoftwareTokenMfaSettings: mfaSettings{true, true}}
+
+	return b.invoke("SetCognitoUserMFAPreference", request, "", nil, nil)
+}
+
+func (b *LambdaBackend) DisableMFA(accessToken string) error {
+	return b.invoke("SetCognitoUserMFAPreference", mfaPreferenceRequest{AccessToken: accessToken}, "", nil, nil)
+}
+
+// The part of what GetUser returns that we use
+type getUserData struct {
+	PreferredMfaSetting string
+	UserMFASettingList  []string
+}
+
+func (b *LambdaBackend) GetMFA(accessToken string) (string, error) {
+	var data getUserData
+
+	err := b.invoke("GetCognitoUser", accessTokenRequest{accessToken}, "", nil, &data)
+
+	if data.PreferredMfaSetting == "" && len(data.UserMFASettingList) > 0 {
+		return data.UserMFASettingList[0], err
+	}
+
+	return data.PreferredMfaSetting, err
+}
+
+// invokeSecrets adds the PreviousPassword and ProposedPassword
+func (b *LambdaBackend) ChangePassword(accessToken string, oldPassword []byte, newPassword []byte) error {
+	secrets := []secretField{{"PreviousPassword", oldPassword}, {"ProposedPassword", newPassword}}
+
+	return b.invokeSecrets("ChangeCognitoUserPassword", accessTokenRequest{accessToken}, secrets, nil)
+}
+
+type updateEmailRequest struct {
+	AccessToken string
+	Email       string
+}
+
+// What UpdateUserAttributes returns
+type updateEmailData struct {
+	CodeDeliveryDetailsList []CodeDelivery
+}
+
+func (b *LambdaBackend) StartEmailChange(accessToken string, email string) (CodeDelivery, error) {
+	var data updateEmailData
+
+	err := b.invoke("UpdateCognitoUserEmail", updateEmailRequest{accessToken, email}, "", nil, &data)
+
+	if len(data.CodeDeliveryDetailsList) == 0 {
+		return CodeDelivery{}, err
+	}
+
+	return data.CodeDeliveryDetailsList[0], err
+}
+
+type verifyEmailRequest struct {
+	AccessToken string
+	Code        string
+}
+
+func (b *LambdaBackend) FinishEmailChange(accessToken string, code string) error {
+	return b.invoke("VerifyCognitoUserEmail", verifyEmailRequest{accessToken, code}, "", nil, nil)
+}
+
+func (b *LambdaBackend) ExportPosts(accessToken string) ([]Post, error) {
+	var items []lambdaPost
+
+	err := b.invoke("GetUserPosts", accessTokenRequest{accessToken}, "", nil, &items)
+
+	return b.lambdaPosts(items), err
+}
@@ -0,0 +1,55 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"testing"
+)
+
+func TestLambdaUnsupported(t *testing.T) {
+	b := NewLambdaBackend("us-west-2", nil)
+
+	// As if Check didn't find them
+	b.missing["EditPost"] = true
+	b.missing["AddPost"] = true
+
+	if b.Supports("EditPost") || !b.Supports("AddReply") {
+		t.Errorf("got EditPost %v, AddReply %v; want only AddReply", b.Supports("EditPost"), b.Supports("AddReply"))
+	}
+
+	// The required ones are Check's to report
+	if got := Unsupported(b); !sameStrings(got, []string{"EditPost"}) {
+		t.Errorf("got unsupported %v, want EditPost", got)
+	}
+
+	// Without calling Lambda, or it being worth trying again
+	err := b.EditPost("token", "1500000000", "hi")
+
+	if ErrorCode(err) != "ResourceNotFoundException" || Unreachable(err) {
+		t.Errorf("got error %v, want ResourceNotFoundException", err)
+	}
+}
+
+func TestLambdaListRoomsUnsupported(t *testing.T) {
+	b := NewLambdaBackend("us-west-2", nil)
+	b.DefaultRoom = "team"
+	b.missing["ListRooms"] = true
+
+	// Mentions and search go through every room, so they still need this one
+	rooms, err := b.ListRooms()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rooms) != 1 || rooms[0].Name != "team" || !rooms[0].Everyone {
+		t.Errorf("got rooms %+v, want only team, for everyone", rooms)
+	}
+}
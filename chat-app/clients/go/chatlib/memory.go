@@ -0,0 +1,1192 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  The memory backend:
+
+  Keeps users and posts in memory, and forgets them when the client stops.
+  It behaves like the Lambda functions and Amazon Cognito,
+  down to the error codes, so you can try the clients,
+  or test them, without an AWS account:
+
+  - Tokens are real JWTs, signed with a key the backend creates,
+    so Verifier() can check them.
+  - Instead of sending email or text messages, it calls Deliver
+    with each message, which is where you find confirmation codes.
+  - EnableSMSMFA, EnableSoftwareTokenMFA, and RequireNewPassword
+    make a user's next sign-in end in a challenge, as Amazon Cognito does
+    when a user has MFA or an administrator set their password.
+*/
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Region, user pool, and app client of the memory backend's tokens
+const (
+	MemoryRegion     = "memory"
+	MemoryUserPoolID = "memory"
+	MemoryClientID   = "memory"
+)
+
+const memoryKeyID = "memory"
+
+type memoryUser struct {
+	Sub          string
+	Email        string
+	Confirmed    bool
+	Salt         []byte
+	PasswordHash []byte
+	// The code we last sent to confirm the user or reset their password
+	ConfirmationCode string
+	ResetCode        string
+	// SMSMFAChallenge, SoftwareTokenMFAChallenge, or "" for no MFA
+	MFA         string
+	PhoneNumber string
+	TOTPSecret  string
+	// The secret of an authenticator app the user is adding, until they enter its first code
+	PendingTOTPSecret string
+	// The address a user is changing their email to, and the code we sent it
+	PendingEmail string
+	EmailCode    string
+	// True if the user must choose a new password when they next sign in
+	NewPasswordRequired bool
+}
+
+// A sign-in waiting for the answer to a challenge
+type memoryChallenge struct {
+	UserName string
+	Name     string
+	// The code we sent, for SMS_MFA
+	Code    string
+	Expires time.Time
+}
+
+// How long a user has to answer a challenge, as in Amazon Cognito
+const memoryChallengeLifetime = 3 * time.Minute
+
+type MemoryBackend struct {
+	mutex sync.Mutex
+	users map[string]*memoryUser
+	posts []Post
+	// The IDs of the posts added with AddPostOnce
+	postIDs map[string]bool
+	// Every direct message, oldest first
+	messages []DirectMessage
+	// Every room but the default room, by name
+	rooms map[string]*Room
+	// Challenges waiting for an answer, by session
+	challenges map[string]*memoryChallenge
+	key        *rsa.PrivateKey
+	// Called with every message the backend would send; nil to drop them
+	Deliver func(to string, subject string, body string)
+	// How long tokens last
+	TokenLifetime time.Duration
+	// The passwords the backend accepts, as a user pool's PasswordPolicy
+	PasswordPolicy PasswordPolicy
+	// The room everyone is in
+	DefaultRoom string
+	// Returns the current time; replace it to test expiration
+	Now func() time.Time
+}
+
+func NewMemoryBackend() (*MemoryBackend, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		return nil, errors.New("Error creating key for memory backend: " + err.Error())
+	}
+
+	return &MemoryBackend{
+		users:          make(map[string]*memoryUser),
+		postIDs:        make(map[string]bool),
+		rooms:          make(map[string]*Room),
+		challenges:     make(map[string]*memoryChallenge),
+		key:            key,
+		TokenLifetime:  time.Hour,
+		PasswordPolicy: DefaultPasswordPolicy,
+		DefaultRoom:    DefaultRoom,
+		Now:            time.Now,
+	}, nil
+}
+
+// The key set that checks the backend's tokens
+func (m *MemoryBackend) KeySet() *KeySet {
+	return NewKeySet(map[string]*rsa.PublicKey{memoryKeyID: &m.key.PublicKey})
+}
+
+// A verifier for the backend's tokens
+func (m *MemoryBackend) Verifier() *Verifier {
+	v := NewVerifier(m.KeySet(), MemoryRegion, MemoryUserPoolID, MemoryClientID)
+	v.Now = m.now
+
+	return v
+}
+
+func (m *MemoryBackend) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+
+	return time.Now()
+}
+
+func (m *MemoryBackend) Check() ([]string, error) {
+	return nil, nil
+}
+
+func (m *MemoryBackend) Supports(function string) bool {
+	return true
+}
+
+func (m *MemoryBackend) Deployment() []string {
+	return []string{"In memory; nothing is saved"}
+}
+
+func memoryError(operation string, code string, message string) error {
+	return &Error{Operation: operation, StatusCode: 400, Code: code, Message: message}
+}
+
+func randomCode() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+
+	if err != nil {
+		panic("Error getting random code: " + err.Error())
+	}
+
+	return strings.Repeat("0", 6-len(n.String())) + n.String()
+}
+
+func hashPassword(salt []byte, password []byte) []byte {
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write(password)
+
+	return hash.Sum(nil)
+}
+
+func (m *MemoryBackend) setPassword(user *memoryUser, password []byte) {
+	user.Salt = []byte(newRandomString(16))
+	user.PasswordHash = hashPassword(user.Salt, password)
+}
+
+func newRandomString(size int) string {
+	b := make([]byte, size)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		panic("Error getting random bytes: " + err.Error())
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (m *MemoryBackend) checkPassword(operation string, password []byte) error {
+	violations := m.PasswordPolicy.Check(password)
+
+	if len(violations) > 0 {
+		// Amazon Cognito only reports the first one
+		return memoryError(operation, "InvalidPasswordException", "Password did not conform with policy: "+violations[0])
+	}
+
+	return nil
+}
+
+func (m *MemoryBackend) deliver(to string, subject string, body string) CodeDelivery {
+	if m.Deliver != nil {
+		m.Deliver(to, subject, body)
+	}
+
+	return CodeDelivery{Destination: MaskDestination(to), DeliveryMedium: "EMAIL", AttributeName: "email"}
+}
+
+// Make the user's sign-ins end in an SMS_MFA challenge,
+// with the codes sent to phoneNumber
+func (m *MemoryBackend) EnableSMSMFA(userName string, phoneNumber string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[userName]
+
+	if !ok {
+		return memoryError("EnableSMSMFA", "UserNotFoundException", "User does not exist.")
+	}
+
+	user.MFA = SMSMFAChallenge
+	user.PhoneNumber = phoneNumber
+
+	return nil
+}
+
+// Make the user's sign-ins end in a SOFTWARE_TOKEN_MFA challenge.
+// Returns the secret to get codes from with TOTPCode.
+func (m *MemoryBackend) EnableSoftwareTokenMFA(userName string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[userName]
+
+	if !ok {
+		return "", memoryError("EnableSoftwareTokenMFA", "UserNotFoundException", "User does not exist.")
+	}
+
+	if user.TOTPSecret == "" {
+		user.TOTPSecret = newTOTPSecret()
+	}
+
+	user.MFA = SoftwareTokenMFAChallenge
+
+	return user.TOTPSecret, nil
+}
+
+// Make the user choose a new password the next time they sign in,
+// as if an administrator had set it
+func (m *MemoryBackend) RequireNewPassword(userName string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[userName]
+
+	if !ok {
+		return memoryError("RequireNewPassword", "UserNotFoundException", "User does not exist.")
+	}
+
+	user.NewPasswordRequired = true
+
+	return nil
+}
+
+// Get the next challenge for a user who signed in, or their tokens if there isn't one.
+// A new password comes before an MFA code, as in Amazon Cognito.
+func (m *MemoryBackend) nextStep(userName string, user *memoryUser, answered string) SignInResult {
+	name := ""
+
+	switch {
+	case user.NewPasswordRequired:
+		name = NewPasswordRequiredChallenge
+	case user.MFA != "" && answered != SMSMFAChallenge && answered != SoftwareTokenMFAChallenge:
+		name = user.MFA
+	default:
+		return SignInResult{Tokens: m.newTokens(userName, user)}
+	}
+
+	session := newRandomString(32)
+	pending := &memoryChallenge{UserName: userName, Name: name, Expires: m.now().Add(memoryChallengeLifetime)}
+	challenge := &Challenge{Name: name, UserName: userName, Session: session, Parameters: map[string]string{}}
+
+	switch name {
+	case SMSMFAChallenge:
+		pending.Code = randomCode()
+		m.deliver(user.PhoneNumber, "Your authentication code", "Your authentication code is "+pending.Code)
+		challenge.Parameters["CODE_DELIVERY_DELIVERY_MEDIUM"] = "SMS"
+		challenge.Parameters["CODE_DELIVERY_DESTINATION"] = MaskDestination(user.PhoneNumber)
+	case NewPasswordRequiredChallenge:
+		challenge.Parameters["requiredAttributes"] = "[]"
+	}
+
+	m.challenges[session] = pending
+
+	return SignInResult{Challenge: challenge}
+}
+
+// Sign a JWT with the backend's key
+func (m *MemoryBackend) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": memoryKeyID, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	hash := sha256.Sum256([]byte(signed))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, hash[:])
+
+	if err != nil {
+		panic("Error signing token: " + err.Error())
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (m *MemoryBackend) newTokens(userName string, user *memoryUser) Tokens {
+	now := m.now()
+	expires := now.Add(m.TokenLifetime)
+	issuer := IssuerURL(MemoryRegion, MemoryUserPoolID)
+
+	access := m.sign(map[string]interface{}{
+		"sub":       user.Sub,
+		"iss":       issuer,
+		"client_id": MemoryClientID,
+		"token_use": "access",
+		"username":  userName,
+		"exp":       expires.Unix(),
+		"iat":       now.Unix(),
+		"auth_time": now.Unix(),
+	})
+
+	id := m.sign(map[string]interface{}{
+		"sub":              user.Sub,
+		"iss":              issuer,
+		"aud":              MemoryClientID,
+		"token_use":        "id",
+		"cognito:username": userName,
+		"email":            user.Email,
+		"email_verified":   user.Confirmed,
+		"exp":              expires.Unix(),
+		"iat":              now.Unix(),
+		"auth_time":        now.Unix(),
+	})
+
+	return Tokens{
+		AccessToken:  access,
+		ExpiresIn:    int(m.TokenLifetime.Seconds()),
+		TokenType:    "Bearer",
+		RefreshToken: newRandomString(32),
+		IdToken:      id,
+	}
+}
+
+// Get the name of the user an access token belongs to
+func (m *MemoryBackend) tokenUser(operation string, accessToken string) (string, error) {
+	claims, err := m.Verifier().VerifyAccessToken(accessToken)
+
+	if err != nil {
+		return "", memoryError(operation, "NotAuthorizedException", "Access Token is not valid: "+err.Error())
+	}
+
+	if _, ok := m.users[claims.UserName()]; !ok {
+		return "", memoryError(operation, "UserNotFoundException", "User does not exist.")
+	}
+
+	return claims.UserName(), nil
+}
+
+func (m *MemoryBackend) GetPosts(room string, maxPosts int) ([]Post, error) {
+	return m.GetPostsSince(room, "", maxPosts)
+}
+
+func (m *MemoryBackend) GetPostsSince(room string, since string, maxPosts int) ([]Post, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var posts []Post
+
+	for _, p := range m.posts {
+		if p.Room == room && (since == "" || postTime(p) > timestampSeconds(since)) {
+			posts = append(posts, p)
+		}
+	}
+
+	sort.SliceStable(posts, func(i, j int) bool {
+		a, _ := strconv.ParseInt(posts[i].Timestamp, 10, 64)
+		b, _ := strconv.ParseInt(posts[j].Timestamp, 10, 64)
+
+		return a > b
+	})
+
+	if maxPosts >= 0 && len(posts) > maxPosts {
+		posts = posts[:maxPosts]
+	}
+
+	return posts, nil
+}
+
+func (m *MemoryBackend) GetPostsAfter(room string, since string, maxPosts int) ([]Post, error) {
+	newest, err := m.GetPostsSince(room, since, -1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var posts []Post
+
+	for i := len(newest) - 1; i >= 0 && (maxPosts < 0 || len(posts) < maxPosts); i-- {
+		posts = append(posts, newest[i])
+	}
+
+	return posts, nil
+}
+
+func (m *MemoryBackend) AddPost(accessToken string, room string, message string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("AddPost", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	err = m.checkMember("AddPost", room, userName)
+
+	if err != nil {
+		return err
+	}
+
+	return m.putPost("AddPost", Post{Alias: userName, Timestamp: strconv.FormatInt(m.now().Unix(), 10), Message: message, Room: room})
+}
+
+func (m *MemoryBackend) AddPostOnce(accessToken string, room string, message string, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("AddPost", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	// It's already there
+	if m.postIDs[id] {
+		return nil
+	}
+
+	err = m.checkMember("AddPost", room, userName)
+
+	if err != nil {
+		return err
+	}
+
+	err = m.putPost("AddPost", Post{Alias: userName, Timestamp: strconv.FormatInt(m.now().Unix(), 10), Message: message, Room: room, PostID: id})
+
+	if err != nil {
+		return err
+	}
+
+	m.postIDs[id] = true
+
+	return nil
+}
+
+// Make sure userName can post to room
+func (m *MemoryBackend) checkMember(operation string, room string, userName string) error {
+	if room == m.DefaultRoom {
+		return nil
+	}
+
+	r, ok := m.rooms[room]
+
+	if !ok {
+		return memoryError(operation, "ResourceNotFoundException", "There is no room "+room+".")
+	}
+
+	if !r.HasMember(userName) {
+		return memoryError(operation, "NotAuthorizedException", "Join "+room+" before posting to it.")
+	}
+
+	return nil
+}
+
+// Add post, unless there's one with the same key: a second post by a user
+// in the same second fails, as the put in AddPost does, rather than replacing the first
+func (m *MemoryBackend) putPost(operation string, post Post) error {
+	for _, p := range m.posts {
+		if p.Key() == post.Key() {
+			return memoryError(operation, PostExistsCode, "There is already a post by "+post.Alias+" from this second; try again in a second.")
+		}
+	}
+
+	m.posts = append(m.posts, post)
+
+	return nil
+}
+
+func (m *MemoryBackend) AddReply(accessToken string, parent PostKey, message string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("AddReply", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	room := ""
+
+	for _, p := range m.posts {
+		if p.Key() == parent {
+			room = p.Room
+			break
+		}
+	}
+
+	if room == "" {
+		// What DynamoDB says when the parent isn't there
+		return memoryError("AddReply", "ConditionalCheckFailedException", "No matching post to reply to.")
+	}
+
+	err = m.checkMember("AddReply", room, userName)
+
+	if err != nil {
+		return err
+	}
+
+	reply := Post{Alias: userName, Timestamp: strconv.FormatInt(m.now().Unix(), 10), Message: message, Parent: parent, Room: room}
+
+	// A reply in the same second as the post it answers would replace it
+	if reply.Key() == parent {
+		return memoryError("AddReply", "InvalidParameterException", "A post can't reply to itself; try again.")
+	}
+
+	return m.putPost("AddReply", reply)
+}
+
+func (m *MemoryBackend) DeletePost(accessToken string, timestamp string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("DeletePost", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	for i, p := range m.posts {
+		if p.Alias == userName && p.Timestamp == timestamp {
+			m.posts = append(m.posts[:i], m.posts[i+1:]...)
+			return nil
+		}
+	}
+
+	return &Error{Operation: "DeletePost", StatusCode: 400, Message: "No matching items to delete."}
+}
+
+func (m *MemoryBackend) EditPost(accessToken string, timestamp string, message string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("EditPost", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	for i, p := range m.posts {
+		if p.Alias != userName || p.Timestamp != timestamp {
+			continue
+		}
+
+		since := p.Timestamp
+
+		if p.Edited() {
+			since = p.EditedAt
+		}
+
+		// Copy the revisions, so posts GetPosts returned don't change
+		revisions := make([]PostRevision, len(p.Revisions), len(p.Revisions)+1)
+		copy(revisions, p.Revisions)
+
+		p.Revisions = append(revisions, PostRevision{Message: p.Message, Time: since})
+		p.Message = message
+		p.EditedAt = strconv.FormatInt(m.now().Unix(), 10)
+		m.posts[i] = p
+
+		return nil
+	}
+
+	// What DynamoDB says when the post isn't there to update
+	return memoryError("EditPost", "ConditionalCheckFailedException", "No matching post to edit.")
+}
+
+func (m *MemoryBackend) AddReaction(accessToken string, post PostKey, reaction string) error {
+	return m.react("AddReaction", accessToken, post, reaction, true)
+}
+
+func (m *MemoryBackend) RemoveReaction(accessToken string, post PostKey, reaction string) error {
+	return m.react("RemoveReaction", accessToken, post, reaction, false)
+}
+
+// Add or remove the signed-in user's reaction to post
+func (m *MemoryBackend) react(operation string, accessToken string, post PostKey, reaction string, add bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser(operation, accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	if problem := CheckReaction(reaction); problem != "" {
+		return memoryError(operation, "InvalidParameterException", problem+".")
+	}
+
+	for i, p := range m.posts {
+		if p.Key() == post {
+			// A new map, so posts GetPosts returned don't change
+			m.posts[i].Reactions = withReaction(p.Reactions, userName, reaction, add)
+			return nil
+		}
+	}
+
+	return memoryError(operation, "ConditionalCheckFailedException", "No matching post to react to.")
+}
+
+func (m *MemoryBackend) SignIn(userName string, password []byte) (SignInResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[userName]
+
+	if !ok {
+		return SignInResult{}, memoryError("SignInCognitoUser", "UserNotFoundException", "User does not exist.")
+	}
+
+	if subtle.ConstantTimeCompare(hashPassword(user.Salt, password), user.PasswordHash) != 1 {
+		return SignInResult{}, memoryError("SignInCognitoUser", "NotAuthorizedException", "Incorrect username or password.")
+	}
+
+	if !user.Confirmed {
+		return SignInResult{}, memoryError("SignInCognitoUser", "UserNotConfirmedException", "User is not confirmed.")
+	}
+
+	return m.nextStep(userName, user, ""), nil
+}
+
+func (m *MemoryBackend) RespondToChallenge(challenge Challenge, answer []byte) (SignInResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	const operation = "RespondToCognitoAuthChallenge"
+
+	pending, ok := m.challenges[challenge.Session]
+
+	if !ok || pending.Name != challenge.Name || pending.UserName != challenge.UserName {
+		return SignInResult{}, memoryError(operation, "NotAuthorizedException", "Invalid session for the user.")
+	}
+
+	if m.now().After(pending.Expires) {
+		delete(m.challenges, challenge.Session)
+		return SignInResult{}, memoryError(operation, "NotAuthorizedException", "Invalid session for the user, session is expired.")
+	}
+
+	user, ok := m.users[pending.UserName]
+
+	if !ok {
+		delete(m.challenges, challenge.Session)
+		return SignInResult{}, memoryError(operation, "UserNotFoundException", "User does not exist.")
+	}
+
+	// A wrong code leaves the challenge open, so the user can try again
+	switch pending.Name {
+	case SMSMFAChallenge:
+		if subtle.ConstantTimeCompare(answer, []byte(pending.Code)) != 1 {
+			return SignInResult{}, memoryError(operation, "CodeMismatchException", "Invalid code received for user")
+		}
+	case SoftwareTokenMFAChallenge:
+		if !VerifyTOTP(user.TOTPSecret, string(answer), m.now()) {
+			return SignInResult{}, memoryError(operation, "CodeMismatchException", "Invalid code received for user")
+		}
+	case NewPasswordRequiredChallenge:
+		err := m.checkPassword(operation, answer)
+
+		if err != nil {
+			return SignInResult{}, err
+		}
+
+		m.setPassword(user, answer)
+		user.NewPasswordRequired = false
+	}
+
+	delete(m.challenges, challenge.Session)
+
+	return m.nextStep(pending.UserName, user, pending.Name), nil
+}
+
+func (m *MemoryBackend) StartRegistration(userName string, password []byte, email string) (CodeDelivery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.users[userName]; ok {
+		return CodeDelivery{}, memoryError("StartAddingPendingCognitoUser", "UsernameExistsException", "User already exists")
+	}
+
+	err := m.checkPassword("StartAddingPendingCognitoUser", password)
+
+	if err != nil {
+		return CodeDelivery{}, err
+	}
+
+	user := &memoryUser{Sub: newRandomString(16), Email: email, ConfirmationCode: randomCode()}
+	m.setPassword(user, password)
+	m.users[userName] = user
+
+	return m.deliver(email, "Your verification code", "Your confirmation code is "+user.ConfirmationCode), nil
+}
+
+func (m *MemoryBackend) FinishRegistration(userName string, code string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[userName]
+
+	if !ok {
+		return memoryError("FinishAddingPendingCognitoUser", "UserNotFoundException", "Username/client id combination not found.")
+	}
+
+	if user.Confirmed {
+		return memoryError("FinishAddingPendingCognitoUser", "NotAuthorizedException", "User cannot be confirmed. Current status is CONFIRMED")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(code), []byte(user.ConfirmationCode)) != 1 {
+		return memoryError("FinishAddingPendingCognitoUser", "CodeMismatchException", "Invalid verification code provided, please try again.")
+	}
+
+	user.Confirmed = true
+	user.ConfirmationCode = ""
+
+	return nil
+}
+
+func (m *MemoryBackend) ResendConfirmationCode(userName string) (CodeDelivery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[userName]
+
+	if !ok {
+		return CodeDelivery{}, memoryError("ResendPendingCognitoUserCode", "UserNotFoundException", "Username/client id combination not found.")
+	}
+
+	if user.Confirmed {
+		return CodeDelivery{}, memoryError("ResendPendingCognitoUserCode", "InvalidParameterException", "User is already confirmed.")
+	}
+
+	user.ConfirmationCode = randomCode()
+
+	return m.deliver(user.Email, "Your verification code", "Your confirmation code is "+user.ConfirmationCode), nil
+}
+
+func (m *MemoryBackend) StartPasswordReset(userName string) (CodeDelivery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[userName]
+
+	if !ok {
+		return CodeDelivery{}, memoryError("StartChangingForgottenCognitoUserPassword", "UserNotFoundException", "Username/client id combination not found.")
+	}
+
+	user.ResetCode = randomCode()
+
+	return m.deliver(user.Email, "Your password reset code", "Your password reset code is "+user.ResetCode), nil
+}
+
+func (m *MemoryBackend) FinishPasswordReset(userName string, code string, newPassword []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[userName]
+
+	if !ok {
+		return memoryError("FinishChangingForgottenCognitoUserPassword", "UserNotFoundException", "Username/client id combination not found.")
+	}
+
+	if user.ResetCode == "" || subtle.ConstantTimeCompare([]byte(code), []byte(user.ResetCode)) != 1 {
+		return memoryError("FinishChangingForgottenCognitoUserPassword", "CodeMismatchException", "Invalid verification code provided, please try again.")
+	}
+
+	err := m.checkPassword("FinishChangingForgottenCognitoUserPassword", newPassword)
+
+	if err != nil {
+		return err
+	}
+
+	m.setPassword(user, newPassword)
+	user.ResetCode = ""
+
+	return nil
+}
+
+func (m *MemoryBackend) RemindUserName(email string) (CodeDelivery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var names []string
+
+	for name, user := range m.users {
+		if user.Confirmed && strings.EqualFold(user.Email, email) {
+			names = append(names, name)
+		}
+	}
+
+	// Don't tell whoever asked whether anyone has that address
+	if len(names) == 0 {
+		return CodeDelivery{Destination: MaskDestination(email), DeliveryMedium: "EMAIL", AttributeName: "email"}, nil
+	}
+
+	sort.Strings(names)
+
+	return m.deliver(email, "Your user name", "The user names for this address: "+strings.Join(names, ", ")), nil
+}
+
+func (m *MemoryBackend) ListUserNames() ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var names []string
+
+	for name, user := range m.users {
+		if user.Confirmed {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (m *MemoryBackend) StartTOTPEnrollment(accessToken string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("AssociateCognitoSoftwareToken", accessToken)
+
+	if err != nil {
+		return "", err
+	}
+
+	user := m.users[userName]
+	user.PendingTOTPSecret = newTOTPSecret()
+
+	return user.PendingTOTPSecret, nil
+}
+
+func (m *MemoryBackend) FinishTOTPEnrollment(accessToken string, code string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("VerifyCognitoSoftwareToken", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	user := m.users[userName]
+
+	if user.PendingTOTPSecret == "" {
+		return memoryError("VerifyCognitoSoftwareToken", "InvalidParameterException", "User has not associated a software token.")
+	}
+
+	if !VerifyTOTP(user.PendingTOTPSecret, code, m.now()) {
+		return memoryError("VerifyCognitoSoftwareToken", "EnableSoftwareTokenMFAException", "Code mismatch")
+	}
+
+	user.TOTPSecret = user.PendingTOTPSecret
+	user.PendingTOTPSecret = ""
+	user.MFA = SoftwareTokenMFAChallenge
+
+	return nil
+}
+
+func (m *MemoryBackend) DisableMFA(accessToken string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("SetCognitoUserMFAPreference", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	m.users[userName].MFA = ""
+
+	return nil
+}
+
+func (m *MemoryBackend) GetMFA(accessToken string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("GetCognitoUser", accessToken)
+
+	if err != nil {
+		return "", err
+	}
+
+	return m.users[userName].MFA, nil
+}
+
+func (m *MemoryBackend) ChangePassword(accessToken string, oldPassword []byte, newPassword []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("ChangeCognitoUserPassword", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	user := m.users[userName]
+
+	if subtle.ConstantTimeCompare(hashPassword(user.Salt, oldPassword), user.PasswordHash) != 1 {
+		return memoryError("ChangeCognitoUserPassword", "NotAuthorizedException", "Incorrect username or password.")
+	}
+
+	err = m.checkPassword("ChangeCognitoUserPassword", newPassword)
+
+	if err != nil {
+		return err
+	}
+
+	m.setPassword(user, newPassword)
+
+	return nil
+}
+
+func (m *MemoryBackend) StartEmailChange(accessToken string, email string) (CodeDelivery, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("UpdateCognitoUserEmail", accessToken)
+
+	if err != nil {
+		return CodeDelivery{}, err
+	}
+
+	user := m.users[userName]
+
+	if strings.EqualFold(email, user.Email) {
+		return CodeDelivery{}, memoryError("UpdateCognitoUserEmail", "InvalidParameterException", "The new email address is the same as the current one.")
+	}
+
+	// Like a user pool that keeps the old address until the new one is verified
+	user.PendingEmail = email
+	user.EmailCode = randomCode()
+
+	return m.deliver(email, "Your verification code", "Your verification code is "+user.EmailCode), nil
+}
+
+func (m *MemoryBackend) FinishEmailChange(accessToken string, code string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("VerifyCognitoUserEmail", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	user := m.users[userName]
+
+	if user.EmailCode == "" || subtle.ConstantTimeCompare([]byte(code), []byte(user.EmailCode)) != 1 {
+		return memoryError("VerifyCognitoUserEmail", "CodeMismatchException", "Invalid verification code provided, please try again.")
+	}
+
+	user.Email = user.PendingEmail
+	user.PendingEmail = ""
+	user.EmailCode = ""
+
+	return nil
+}
+
+func (m *MemoryBackend) ExportPosts(accessToken string) ([]Post, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("GetUserPosts", accessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var posts []Post
+
+	for _, p := range m.posts {
+		if p.Alias == userName {
+			posts = append(posts, p)
+		}
+	}
+
+	return posts, nil
+}
+
+func (m *MemoryBackend) ListRooms() ([]Room, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var rooms []Room
+
+	for _, r := range m.rooms {
+		room := *r
+		room.Members = append([]string(nil), r.Members...)
+		rooms = append(rooms, room)
+	}
+
+	return withDefaultRoom(rooms, m.DefaultRoom), nil
+}
+
+func (m *MemoryBackend) CreateRoom(accessToken string, name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("CreateRoom", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	if problems := CheckRoomName(name); len(problems) > 0 {
+		return memoryError("CreateRoom", "InvalidParameterException", strings.Join(problems, "; ")+".")
+	}
+
+	if _, ok := m.rooms[name]; ok || name == m.DefaultRoom {
+		return memoryError("CreateRoom", "ConditionalCheckFailedException", "There is already a room "+name+".")
+	}
+
+	m.rooms[name] = &Room{
+		Name:      name,
+		CreatedBy: userName,
+		CreatedAt: strconv.FormatInt(m.now().Unix(), 10),
+		Members:   []string{userName},
+	}
+
+	return nil
+}
+
+func (m *MemoryBackend) JoinRoom(accessToken string, name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("JoinRoom", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	if name == m.DefaultRoom {
+		return nil
+	}
+
+	room, ok := m.rooms[name]
+
+	if !ok {
+		return memoryError("JoinRoom", "ResourceNotFoundException", "There is no room "+name+".")
+	}
+
+	if !room.HasMember(userName) {
+		room.Members = append(room.Members, userName)
+	}
+
+	return nil
+}
+
+func (m *MemoryBackend) SendDirectMessage(accessToken string, to string, message string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("SendDirectMessage", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	if user, ok := m.users[to]; !ok || !user.Confirmed {
+		return memoryError("SendDirectMessage", "UserNotFoundException", "There is no user "+to+".")
+	}
+
+	if to == userName {
+		return memoryError("SendDirectMessage", "InvalidParameterException", "You can't send a direct message to yourself.")
+	}
+
+	m.messages = append(m.messages, DirectMessage{
+		From:      userName,
+		To:        to,
+		Timestamp: strconv.FormatInt(m.now().Unix(), 10),
+		Message:   message,
+	})
+
+	return nil
+}
+
+func (m *MemoryBackend) ListConversations(accessToken string) ([]Conversation, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("ListConversations", accessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return conversations(userName, m.messages), nil
+}
+
+func (m *MemoryBackend) GetDirectMessages(accessToken string, with string, maxMessages int) ([]DirectMessage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("GetDirectMessages", accessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Where the messages between them are in m.messages, newest first, even within a second
+	var found []int
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		message := m.messages[i]
+
+		if (message.From == userName && message.To == with) || (message.From == with && message.To == userName) {
+			found = append(found, i)
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return messageTime(m.messages[found[i]]) > messageTime(m.messages[found[j]])
+	})
+
+	if maxMessages >= 0 && len(found) > maxMessages {
+		found = found[:maxMessages]
+	}
+
+	var messages []DirectMessage
+
+	// Only the ones we return have been seen; they're returned as they were, so new ones still say unread
+	for _, i := range found {
+		messages = append(messages, m.messages[i])
+
+		if m.messages[i].To == userName {
+			m.messages[i].Read = true
+		}
+	}
+
+	return messages, nil
+}
+
+func (m *MemoryBackend) DeleteUser(accessToken string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	userName, err := m.tokenUser("DeleteCognitoUser", accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	delete(m.users, userName)
+
+	return nil
+}
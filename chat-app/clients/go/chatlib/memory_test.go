@@ -0,0 +1,461 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+const testPassword = "Passw0rd!x"
+
+// A memory backend whose clock only moves when the test moves it
+func newTestBackend(t *testing.T) (*MemoryBackend, *time.Time) {
+	m, err := NewMemoryBackend()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1500000000, 0)
+	m.Now = func() time.Time { return now }
+
+	return m, &now
+}
+
+// Register and confirm a user with testPassword
+func addTestUser(t *testing.T, m *MemoryBackend, userName string) {
+	_, err := m.StartRegistration(userName, []byte(testPassword), userName+"@example.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.FinishRegistration(userName, m.users[userName].ConfirmationCode)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Register and sign in a user without MFA, and get their access token
+func signInTestUser(t *testing.T, m *MemoryBackend, userName string) string {
+	addTestUser(t, m, userName)
+
+	result, err := m.SignIn(userName, []byte(testPassword))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Challenge != nil {
+		t.Fatalf("got challenge %s signing in %s", result.Challenge.Name, userName)
+	}
+
+	return result.Tokens.AccessToken
+}
+
+// The Code of a backend error, or "" if err isn't one
+func errorCode(err error) string {
+	var backendError *Error
+
+	if errors.As(err, &backendError) {
+		return backendError.Code
+	}
+
+	return ""
+}
+
+func TestMemoryChallenges(t *testing.T) {
+	// One answer to a challenge, and what should come of it
+	type step struct {
+		// The challenge we expect to answer
+		challenge string
+		// The answer; "code" is the code the backend sent, "totp" the authenticator app's
+		answer string
+		// Move the clock this far before answering
+		wait time.Duration
+		// The error code we expect, if any; the challenge stays open after one
+		err string
+	}
+
+	tests := []struct {
+		name  string
+		setup func(m *MemoryBackend) error
+		steps []step
+	}{
+		{"no challenge", nil, nil},
+		{"SMS MFA", func(m *MemoryBackend) error {
+			return m.EnableSMSMFA("bob", "+15555550100")
+		}, []step{
+			{challenge: SMSMFAChallenge, answer: "code"},
+		}},
+		{"SMS MFA wrong code", func(m *MemoryBackend) error {
+			return m.EnableSMSMFA("bob", "+15555550100")
+		}, []step{
+			{challenge: SMSMFAChallenge, answer: "000000x", err: "CodeMismatchException"},
+			{challenge: SMSMFAChallenge, answer: "code"},
+		}},
+		{"SMS MFA expired", func(m *MemoryBackend) error {
+			return m.EnableSMSMFA("bob", "+15555550100")
+		}, []step{
+			{challenge: SMSMFAChallenge, answer: "code", wait: memoryChallengeLifetime + time.Second, err: "NotAuthorizedException"},
+		}},
+		{"authenticator app", func(m *MemoryBackend) error {
+			_, err := m.EnableSoftwareTokenMFA("bob")
+			return err
+		}, []step{
+			{challenge: SoftwareTokenMFAChallenge, answer: "totp"},
+		}},
+		{"new password", func(m *MemoryBackend) error {
+			return m.RequireNewPassword("bob")
+		}, []step{
+			{challenge: NewPasswordRequiredChallenge, answer: "weak", err: "InvalidPasswordException"},
+			{challenge: NewPasswordRequiredChallenge, answer: "N3wPassw0rd!"},
+		}},
+		{"new password, then SMS MFA", func(m *MemoryBackend) error {
+			err := m.EnableSMSMFA("bob", "+15555550100")
+
+			if err == nil {
+				err = m.RequireNewPassword("bob")
+			}
+
+			return err
+		}, []step{
+			{challenge: NewPasswordRequiredChallenge, answer: "N3wPassw0rd!"},
+			{challenge: SMSMFAChallenge, answer: "code"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, now := newTestBackend(t)
+			addTestUser(t, m, "bob")
+
+			if test.setup != nil {
+				err := test.setup(m)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result, err := m.SignIn("bob", []byte(testPassword))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, s := range test.steps {
+				if result.Challenge == nil {
+					t.Fatalf("step %d: got tokens, want challenge %s", i+1, s.challenge)
+				}
+
+				if result.Challenge.Name != s.challenge {
+					t.Fatalf("step %d: got challenge %s, want %s", i+1, result.Challenge.Name, s.challenge)
+				}
+
+				*now = now.Add(s.wait)
+				answer := s.answer
+
+				switch answer {
+				case "code":
+					answer = m.challenges[result.Challenge.Session].Code
+				case "totp":
+					answer, err = TOTPCode(m.users["bob"].TOTPSecret, *now)
+
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				next, err := m.RespondToChallenge(*result.Challenge, []byte(answer))
+
+				if errorCode(err) != s.err {
+					t.Fatalf("step %d: got error %v, want %s", i+1, err, s.err)
+				}
+
+				if err == nil {
+					result = next
+				}
+			}
+
+			if n := len(test.steps); n > 0 && test.steps[n-1].err != "" {
+				// It ended in an error, so there are no tokens
+				return
+			}
+
+			if result.Challenge != nil {
+				t.Fatalf("got challenge %s, want tokens", result.Challenge.Name)
+			}
+
+			claims, err := m.Verifier().VerifyAccessToken(result.Tokens.AccessToken)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.UserName() != "bob" {
+				t.Errorf("got token for %q, want bob", claims.UserName())
+			}
+		})
+	}
+}
+
+func TestMemoryChallengeSession(t *testing.T) {
+	m, _ := newTestBackend(t)
+	addTestUser(t, m, "bob")
+	addTestUser(t, m, "alice")
+
+	err := m.EnableSMSMFA("bob", "+15555550100")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := m.SignIn("bob", []byte(testPassword))
+
+	if err != nil || result.Challenge == nil {
+		t.Fatalf("got %v, %v; want a challenge", result, err)
+	}
+
+	code := m.challenges[result.Challenge.Session].Code
+
+	tests := []struct {
+		name   string
+		change func(c *Challenge)
+	}{
+		{"unknown session", func(c *Challenge) { c.Session = "nope" }},
+		{"another user", func(c *Challenge) { c.UserName = "alice" }},
+		{"another challenge", func(c *Challenge) { c.Name = SoftwareTokenMFAChallenge }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			challenge := *result.Challenge
+			test.change(&challenge)
+
+			_, err := m.RespondToChallenge(challenge, []byte(code))
+
+			if errorCode(err) != "NotAuthorizedException" {
+				t.Fatalf("got error %v, want NotAuthorizedException", err)
+			}
+		})
+	}
+
+	// The real session still works, once
+	_, err = m.RespondToChallenge(*result.Challenge, []byte(code))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.RespondToChallenge(*result.Challenge, []byte(code))
+
+	if errorCode(err) != "NotAuthorizedException" {
+		t.Fatalf("answering twice: got error %v, want NotAuthorizedException", err)
+	}
+}
+
+func TestMemoryDefaultRoom(t *testing.T) {
+	backend, err := NewBackend(MemoryBackendName, "", nil, "team")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := backend.(*MemoryBackend)
+	token := signInTestUser(t, m, "bob")
+
+	tests := []struct {
+		name string
+		do   func() error
+		// The error code we expect, if any
+		err string
+	}{
+		{"post to the default room", func() error { return m.AddPost(token, "team", "hi") }, ""},
+		{"join the default room", func() error { return m.JoinRoom(token, "team") }, ""},
+		{"create the default room", func() error { return m.CreateRoom(token, "team") }, "ConditionalCheckFailedException"},
+		{"post to general", func() error { return m.AddPost(token, "general", "hi") }, "ResourceNotFoundException"},
+		{"create general", func() error { return m.CreateRoom(token, "general") }, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.do()
+
+			if errorCode(err) != test.err {
+				t.Fatalf("got error %v, want %s", err, test.err)
+			}
+		})
+	}
+
+	rooms, err := m.ListRooms()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rooms) != 2 || rooms[0].Name != "team" || !rooms[0].Everyone || rooms[1].Everyone {
+		t.Errorf("got rooms %+v, want team, for everyone, then general", rooms)
+	}
+
+	if !rooms[0].HasMember("alice") {
+		t.Error("alice isn't a member of the default room")
+	}
+}
+
+func TestMemoryAddPostSameSecond(t *testing.T) {
+	m, now := newTestBackend(t)
+	token := signInTestUser(t, m, "bob")
+
+	if err := m.AddPost(token, m.DefaultRoom, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same key, so it mustn't replace the first
+	err := m.AddPostOnce(token, m.DefaultRoom, "second", "second-post")
+
+	if !PostTooSoon(err) {
+		t.Fatalf("got error %v, want %s", err, PostExistsCode)
+	}
+
+	*now = now.Add(time.Second)
+
+	if err := m.AddPostOnce(token, m.DefaultRoom, "second", "second-post"); err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := m.GetPosts(m.DefaultRoom, -1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+
+	for _, p := range posts {
+		messages = append(messages, p.Message)
+	}
+
+	sort.Strings(messages)
+
+	if !sameStrings(messages, []string{"first", "second"}) {
+		t.Errorf("got %v, want first and second", messages)
+	}
+}
+
+func TestMemoryDirectMessages(t *testing.T) {
+	m, now := newTestBackend(t)
+
+	tokens := make(map[string]string)
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+		tokens[name] = signInTestUser(t, m, name)
+	}
+
+	send := func(from string, to string, message string) {
+		*now = now.Add(time.Second)
+
+		err := m.SendDirectMessage(tokens[from], to, message)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send("alice", "bob", "a1")
+	send("alice", "bob", "a2")
+	send("carol", "bob", "c1")
+	send("bob", "alice", "b1")
+	send("alice", "bob", "a3")
+	send("alice", "carol", "a4")
+
+	// Each call sees what the ones before it did
+	tests := []struct {
+		name string
+		user string
+		with string
+		max  int
+		// The messages we get, newest first, and which of them were unread
+		want       []string
+		wantUnread []string
+		// How many are unread from with, afterwards
+		unreadAfter int
+	}{
+		{"only the newest two", "bob", "alice", 2, []string{"a3", "b1"}, []string{"a3"}, 2},
+		{"all of them", "bob", "alice", -1, []string{"a3", "b1", "a2", "a1"}, []string{"a2", "a1"}, 0},
+		{"another conversation", "bob", "carol", -1, []string{"c1"}, []string{"c1"}, 0},
+		{"not someone else's", "carol", "bob", -1, []string{"c1"}, nil, 0},
+		{"the other end", "carol", "alice", -1, []string{"a4"}, []string{"a4"}, 0},
+		{"sent messages don't get read", "alice", "bob", -1, []string{"a3", "b1", "a2", "a1"}, []string{"b1"}, 0},
+		{"nobody", "alice", "dave", -1, nil, nil, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages, err := m.GetDirectMessages(tokens[test.user], test.with, test.max)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got, gotUnread []string
+
+			for _, message := range messages {
+				if message.From != test.user && message.To != test.user {
+					t.Errorf("got %s's message to %s", message.From, message.To)
+				}
+
+				got = append(got, message.Message)
+
+				if message.To == test.user && !message.Read {
+					gotUnread = append(gotUnread, message.Message)
+				}
+			}
+
+			if !sameStrings(got, test.want) {
+				t.Errorf("got messages %v, want %v", got, test.want)
+			}
+
+			if !sameStrings(gotUnread, test.wantUnread) {
+				t.Errorf("got unread messages %v, want %v", gotUnread, test.wantUnread)
+			}
+
+			conversations, err := m.ListConversations(tokens[test.user])
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, c := range conversations {
+				if c.With == test.with && c.Unread != test.unreadAfter {
+					t.Errorf("got %d unread afterwards, want %d", c.Unread, test.unreadAfter)
+				}
+			}
+		})
+	}
+}
+
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,280 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Mentions:
+
+  A post mentions a user with @ and their user name, such as "@bob, look at this".
+  The @ must start a word, so bob@example.com isn't a mention,
+  and punctuation after the name, such as the comma above, isn't part of it.
+  Only the names of users the backend knows are mentions;
+  SplitMentions cuts a message into text and mentions, so the clients can highlight them.
+
+  FindMentions gets the posts, in every room, that mention a user.
+  MentionMarkers keeps, for each user, the Timestamp of the newest mention
+  they've seen, in a file, so the clients can show what's new since their last visit.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// What can follow a mention without being part of the name
+const mentionTrailers = ".,;:!?)]}'\""
+
+// A piece of a message: text, or a mention of a known user
+type MessagePart struct {
+	// What the message says, including the @ of a mention
+	Text string
+	// The user name, without the @, if this part is a mention; otherwise empty
+	Mention string
+}
+
+// True if an @ at message[i] starts a word
+func startsWord(message string, i int) bool {
+	if i == 0 {
+		return true
+	}
+
+	r, _ := utf8.DecodeLastRuneInString(message[:i])
+
+	return !unicode.In(r, unicode.L, unicode.M, unicode.N) && r != '@'
+}
+
+// Find the next possible mention at or after start.
+// Returns where its @ is and where its name ends, or -1, -1 if there isn't one.
+func nextMention(message string, start int) (int, int) {
+	for i := start; i < len(message); i++ {
+		if message[i] != '@' || !startsWord(message, i) {
+			continue
+		}
+
+		end := i + 1
+
+		for end < len(message) {
+			r, size := utf8.DecodeRuneInString(message[end:])
+
+			if unicode.IsSpace(r) {
+				break
+			}
+
+			end += size
+		}
+
+		for end > i+1 && strings.ContainsRune(mentionTrailers, rune(message[end-1])) {
+			end--
+		}
+
+		if end > i+1 {
+			return i, end
+		}
+	}
+
+	return -1, -1
+}
+
+// Get the names after each @ in message, known users or not, in order
+func Mentions(message string) []string {
+	var names []string
+
+	for start := 0; ; {
+		at, end := nextMention(message, start)
+
+		if at < 0 {
+			return names
+		}
+
+		names = append(names, message[at+1:end])
+		start = end
+	}
+}
+
+// Cut message into text and mentions of users for whom known returns true.
+// Joining the Text of the parts gives back message.
+func SplitMentions(message string, known func(string) bool) []MessagePart {
+	var parts []MessagePart
+	text := 0
+
+	for start := 0; ; {
+		at, end := nextMention(message, start)
+
+		if at < 0 {
+			break
+		}
+
+		start = end
+		name := message[at+1 : end]
+
+		if !known(name) {
+			continue
+		}
+
+		if at > text {
+			parts = append(parts, MessagePart{Text: message[text:at]})
+		}
+
+		parts = append(parts, MessagePart{Text: message[at:end], Mention: name})
+		text = end
+	}
+
+	if text < len(message) {
+		parts = append(parts, MessagePart{Text: message[text:]})
+	}
+
+	return parts
+}
+
+// The users a client knows, to tell mentions from other words that start with @
+type KnownUsers struct {
+	policy UserNamePolicy
+	names  map[string]bool
+}
+
+// Know userNames, compared as policy normalizes them
+func NewKnownUsers(policy UserNamePolicy, userNames ...string) *KnownUsers {
+	k := &KnownUsers{policy: policy, names: make(map[string]bool)}
+	k.Add(userNames...)
+
+	return k
+}
+
+func (k *KnownUsers) Add(userNames ...string) {
+	for _, name := range userNames {
+		k.names[k.policy.Normalize(name)] = true
+	}
+}
+
+func (k *KnownUsers) Has(userName string) bool {
+	return k.names[k.policy.Normalize(userName)]
+}
+
+// True if message mentions userName
+func Mentioned(policy UserNamePolicy, message string, userName string) bool {
+	userName = policy.Normalize(userName)
+
+	for _, name := range Mentions(message) {
+		if policy.Normalize(name) == userName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the posts by other users that mention userName and are newer than since,
+// a Timestamp, or "" for all of them, newest first.
+// Looks at the latest maxPosts posts in each room.
+func FindMentions(backend Backend, policy UserNamePolicy, userName string, since string, maxPosts int) ([]Post, error) {
+	rooms, err := backend.ListRooms()
+
+	if err != nil {
+		return nil, errors.New("Could not get rooms: " + err.Error())
+	}
+
+	sinceTime, _ := strconv.ParseInt(since, 10, 64)
+
+	var found []Post
+
+	for _, room := range rooms {
+		posts, err := backend.GetPosts(room.Name, maxPosts)
+
+		if err != nil {
+			return nil, errors.New("Could not get the posts in " + room.Name + ": " + err.Error())
+		}
+
+		for _, p := range posts {
+			postTime, _ := strconv.ParseInt(p.Timestamp, 10, 64)
+
+			if postTime > sinceTime && p.Alias != userName && Mentioned(policy, p.Message, userName) {
+				found = append(found, p)
+			}
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		a, _ := strconv.ParseInt(found[i].Timestamp, 10, 64)
+		b, _ := strconv.ParseInt(found[j].Timestamp, 10, 64)
+
+		return a > b
+	})
+
+	return found, nil
+}
+
+// For each user, the Timestamp of the newest mention of them they've seen
+type MentionMarkers map[string]string
+
+// Read the markers saved in filename.
+// If filename doesn't exist, nobody has seen any mentions.
+func LoadMentionMarkers(filename string) (MentionMarkers, error) {
+	markers := make(MentionMarkers)
+
+	data, err := ioutil.ReadFile(filename)
+
+	if os.IsNotExist(err) {
+		return markers, nil
+	}
+
+	if err != nil {
+		return markers, errors.New("Error reading mention markers: " + err.Error())
+	}
+
+	err = json.Unmarshal(data, &markers)
+
+	if err != nil {
+		return make(MentionMarkers), errors.New("Error parsing mention markers in " + filename + ": " + err.Error())
+	}
+
+	return markers, nil
+}
+
+// Move userName's marker to the newest of posts, which are newest first;
+// returns true if it moved
+func (m MentionMarkers) MarkSeen(userName string, posts []Post) bool {
+	if len(posts) == 0 {
+		return false
+	}
+
+	newest, _ := strconv.ParseInt(posts[0].Timestamp, 10, 64)
+	seen, _ := strconv.ParseInt(m[userName], 10, 64)
+
+	if newest <= seen {
+		return false
+	}
+
+	m[userName] = posts[0].Timestamp
+
+	return true
+}
+
+func (m MentionMarkers) Save(filename string) error {
+	data, err := json.MarshalIndent(m, "", "    ")
+
+	if err != nil {
+		return errors.New("Error marshalling mention markers: " + err.Error())
+	}
+
+	err = ioutil.WriteFile(filename, data, 0600)
+
+	if err != nil {
+		return errors.New("Error saving mention markers: " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitMentions(t *testing.T) {
+	known := NewKnownUsers(DefaultUserNamePolicy, "bob", "alice", "émile").Has
+
+	tests := []struct {
+		name    string
+		message string
+		want    []MessagePart
+	}{
+		{"no mentions", "hello there", []MessagePart{{Text: "hello there"}}},
+		{"mention then text", "@bob, look", []MessagePart{{Text: "@bob", Mention: "bob"}, {Text: ", look"}}},
+		{"text then mention", "thanks @alice!", []MessagePart{{Text: "thanks "}, {Text: "@alice", Mention: "alice"}, {Text: "!"}}},
+		{"two mentions", "@bob @alice", []MessagePart{{Text: "@bob", Mention: "bob"}, {Text: " "}, {Text: "@alice", Mention: "alice"}}},
+		{"in parentheses", "(@bob)", []MessagePart{{Text: "("}, {Text: "@bob", Mention: "bob"}, {Text: ")"}}},
+		{"unknown user", "hi @carol", []MessagePart{{Text: "hi @carol"}}},
+		{"email address", "mail bob@example.com", []MessagePart{{Text: "mail bob@example.com"}}},
+		{"double @", "@@bob", []MessagePart{{Text: "@@bob"}}},
+		{"@ alone", "meet @ noon", []MessagePart{{Text: "meet @ noon"}}},
+		{"name with an accent", "salut @émile.", []MessagePart{{Text: "salut "}, {Text: "@émile", Mention: "émile"}, {Text: "."}}},
+		{"case sensitive", "@Bob", []MessagePart{{Text: "@Bob"}}},
+		{"empty", "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := SplitMentions(test.message, known)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+
+			var joined strings.Builder
+
+			for _, part := range got {
+				joined.WriteString(part.Text)
+			}
+
+			if joined.String() != test.message {
+				t.Errorf("parts join to %q, not the message", joined.String())
+			}
+		})
+	}
+}
+
+func TestMentions(t *testing.T) {
+	got := Mentions("@bob and @carol, not bob@example.com or @")
+	want := []string{"bob", "carol"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMentioned(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   UserNamePolicy
+		message  string
+		userName string
+		want     bool
+	}{
+		{"mentioned", DefaultUserNamePolicy, "hey @bob", "bob", true},
+		{"someone else", DefaultUserNamePolicy, "hey @bobby", "bob", false},
+		{"case sensitive", DefaultUserNamePolicy, "hey @BOB", "bob", false},
+		{"case insensitive", UserNamePolicy{CaseInsensitive: true}, "hey @BOB", "bob", true},
+		{"NFC", DefaultUserNamePolicy, "salut @émile", "émile", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Mentioned(test.policy, test.message, test.userName); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,447 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  The outbox:
+
+  A post goes in the Outbox, which is saved in a file, before the client sends it,
+  and comes out once the backend has it. So a post written without a connection
+  isn't lost: it waits in the outbox, and Send tries it again later.
+
+  Each post has an ID the client makes up, which goes to AddPostOnce.
+  The backend only adds a post with a given ID once, so if a post did get there,
+  but the answer didn't get back, sending it again doesn't post it twice.
+  Not every backend checks the IDs, so before sending a post again after such a try,
+  Send looks for it in its room, by its ID, and takes it out if it's there.
+  A backend that doesn't keep the IDs either, such as one with an older AddPost,
+  leaves only the message to go on, so then that check is best-effort:
+  a post that says the same thing, by the same user, since the post was written,
+  counts as the post, even if they really did post it twice.
+
+  A post in the outbox is either:
+
+    pending  the backend couldn't be reached; Send tries it again, in order
+    failed   the backend refused it, such as for a room the user isn't in,
+             or it can't be sent as is; it waits until the user retries or discards it
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+type OutboxPost struct {
+	// Made up by the client; the backend only adds a post with this ID once
+	ID string
+	// The user who wrote it; only they can send it
+	Alias   string
+	Room    string
+	Message string
+	// Unix time, in seconds, as a string, when the user wrote it
+	Created string
+	// How many times we tried to send it, and why the last try didn't work
+	Attempts  int
+	LastError string
+	// True if the backend refused it, so Send leaves it alone
+	Failed bool
+	// True if the last try couldn't reach the backend, so it might have gotten there anyway
+	MaybeSent bool
+}
+
+// Pending or failed
+func (p OutboxPost) State() string {
+	if p.Failed {
+		return "failed"
+	}
+
+	return "pending"
+}
+
+type Outbox struct {
+	// Oldest first, which is the order they're sent in
+	Posts []OutboxPost
+	// When we last sent a post, or the backend said it already had one from that second
+	lastSent time.Time
+}
+
+// Make up an ID for a post
+func NewPostID() string {
+	id := make([]byte, 16)
+
+	_, err := rand.Read(id)
+
+	if err != nil {
+		panic("Error getting random bytes: " + err.Error())
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// True if err means the backend couldn't be reached, or couldn't answer,
+// rather than that it refused the request, so trying again later could work
+func Unreachable(err error) bool {
+	var unreachable *UnreachableError
+
+	if errors.As(err, &unreachable) {
+		return true
+	}
+
+	var backendError *Error
+
+	if errors.As(err, &backendError) {
+		// A function that crashed or timed out has no status code
+		return backendError.StatusCode == 0 || backendError.StatusCode >= 500
+	}
+
+	// Such as a request we couldn't marshal, or a bad configuration,
+	// which fails the same way every time
+	return false
+}
+
+// True if err, from AddPost or AddPostOnce, means the user already has a post
+// from this second, so the post can go in the next one
+func PostTooSoon(err error) bool {
+	return ErrorCode(err) == PostExistsCode
+}
+
+// Add a post by alias to room, to send; returns it
+func (o *Outbox) Add(alias string, room string, message string) OutboxPost {
+	post := OutboxPost{ID: NewPostID(), Alias: alias, Room: room, Message: message, Created: strconv.FormatInt(time.Now().Unix(), 10)}
+	o.Posts = append(o.Posts, post)
+
+	return post
+}
+
+// Get alias's posts, oldest first
+func (o *Outbox) For(alias string) []OutboxPost {
+	var posts []OutboxPost
+
+	for _, p := range o.Posts {
+		if p.Alias == alias {
+			posts = append(posts, p)
+		}
+	}
+
+	return posts
+}
+
+// How many of alias's posts are waiting to be sent
+func (o *Outbox) Pending(alias string) int {
+	count := 0
+
+	for _, p := range o.Posts {
+		if p.Alias == alias && !p.Failed {
+			count++
+		}
+	}
+
+	return count
+}
+
+// How many of alias's posts failed, and wait for them to retry or discard them
+func (o *Outbox) Failed(alias string) int {
+	count := 0
+
+	for _, p := range o.Posts {
+		if p.Alias == alias && p.Failed {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (o *Outbox) find(alias string, id string) int {
+	for i, p := range o.Posts {
+		if p.Alias == alias && p.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Get one of alias's posts; false if it isn't in the outbox, such as once it's sent
+func (o *Outbox) Get(alias string, id string) (OutboxPost, bool) {
+	i := o.find(alias, id)
+
+	if i < 0 {
+		return OutboxPost{}, false
+	}
+
+	return o.Posts[i], true
+}
+
+// Throw away one of alias's posts without sending it
+func (o *Outbox) Discard(alias string, id string) error {
+	i := o.find(alias, id)
+
+	if i < 0 {
+		return errors.New("There is no post " + id + " in your outbox")
+	}
+
+	o.Posts = append(o.Posts[:i], o.Posts[i+1:]...)
+
+	return nil
+}
+
+// Make one of alias's failed posts pending again, so Send tries it
+func (o *Outbox) Retry(alias string, id string) error {
+	i := o.find(alias, id)
+
+	if i < 0 {
+		return errors.New("There is no post " + id + " in your outbox")
+	}
+
+	o.Posts[i].Failed = false
+
+	return nil
+}
+
+// Send alias's pending posts, oldest first, and take the ones the backend gets out.
+// Stops at the first one it can't send because the backend can't be reached,
+// so they arrive in order, and returns that error.
+// Posts the backend refuses are marked failed, and the rest are still sent.
+// Waits a second between posts; returns how many were sent.
+func (o *Outbox) Send(backend Backend, accessToken string, alias string) (int, error) {
+	return o.send(backend, accessToken, alias, true)
+}
+
+// Send alias's pending posts as Send does, but only the ones that can go without waiting;
+// the rest wait for the next call, which can be Wait from now.
+// Returns how many were sent.
+func (o *Outbox) SendReady(backend Backend, accessToken string, alias string) (int, error) {
+	return o.send(backend, accessToken, alias, false)
+}
+
+// How long until SendReady can send another post
+func (o *Outbox) Wait() time.Duration {
+	if o.lastSent.IsZero() {
+		return 0
+	}
+
+	wait := time.Until(o.lastSent.Truncate(time.Second).Add(time.Second))
+
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
+}
+
+func (o *Outbox) send(backend Backend, accessToken string, alias string, wait bool) (int, error) {
+	sent := 0
+	tooSoon := false
+
+	for {
+		p, ok := o.Next(alias)
+
+		if !ok {
+			return sent, nil
+		}
+
+		// A post's Timestamp is the second it was added, and with Alias is its key,
+		// so the backend refuses a second post in the same second
+		if o.Wait() > 0 && !wait {
+			return sent, nil
+		}
+
+		time.Sleep(o.Wait())
+
+		p, err := SendPost(backend, accessToken, p)
+		o.Update(p, err)
+
+		switch {
+		case err == nil:
+			sent++
+		case PostTooSoon(err):
+			// Update made it wait for the next second; Send tries it once more then
+			if !wait || tooSoon {
+				return sent, nil
+			}
+
+			tooSoon = true
+		case Unreachable(err):
+			return sent, err
+		}
+	}
+}
+
+// Get alias's oldest pending post, the next one to send; false if there isn't one
+func (o *Outbox) Next(alias string) (OutboxPost, bool) {
+	for _, p := range o.Posts {
+		if p.Alias == alias && !p.Failed {
+			return p, true
+		}
+	}
+
+	return OutboxPost{}, false
+}
+
+// Send p, a post from an outbox, and return it updated with how that went,
+// along with the error if the backend didn't get it.
+// It doesn't touch the outbox, so a client can call it without holding up
+// whatever else uses the outbox; give what it returns to Update.
+// Call it for one post at a time, Wait apart, as Send does.
+func SendPost(backend Backend, accessToken string, p OutboxPost) (OutboxPost, error) {
+	posted, err := alreadyPosted(backend, p)
+
+	if err != nil && !Unreachable(err) {
+		// We can't tell, so count on AddPostOnce
+		posted, err = false, nil
+	}
+
+	if err == nil && !posted {
+		err = backend.AddPostOnce(accessToken, p.Room, p.Message, p.ID)
+	}
+
+	if err == nil {
+		return p, nil
+	}
+
+	p.Attempts++
+	p.LastError = err.Error()
+
+	switch {
+	case PostTooSoon(err):
+		// It's still pending, for the next second
+	case Unreachable(err):
+		p.MaybeSent = true
+	default:
+		p.Failed = true
+	}
+
+	return p, err
+}
+
+// Record how SendPost did with p: take it out if the backend got it (err is nil),
+// otherwise keep it as SendPost left it, pending or failed.
+// If it was too soon, Wait is until the next second.
+// If it was discarded while it was being sent, it stays gone.
+func (o *Outbox) Update(p OutboxPost, err error) {
+	i := o.find(p.Alias, p.ID)
+
+	if err == nil || PostTooSoon(err) {
+		// The backend added it, or another post from this second, by now
+		o.lastSent = time.Now()
+	}
+
+	if err == nil {
+		if i >= 0 {
+			o.Posts = append(o.Posts[:i], o.Posts[i+1:]...)
+		}
+
+		return
+	}
+
+	if i >= 0 {
+		o.Posts[i] = p
+	}
+}
+
+// How many posts from when an outbox post was written Send looks through for it
+const outboxCheckPosts = 100
+
+// True if p, which might have gotten there on a try that couldn't reach the backend,
+// is in its room, from when it was written on: a post with its ID or,
+// for a post the backend didn't keep an ID for, a post by its alias with its message.
+// A backend whose AddPost ignores the ID AddPostOnce sends would otherwise post it twice.
+func alreadyPosted(backend Backend, p OutboxPost) (bool, error) {
+	if !p.MaybeSent {
+		return false, nil
+	}
+
+	since := strconv.FormatInt(timestampSeconds(p.Created)-1, 10)
+	posts, err := backend.GetPostsAfter(p.Room, since, outboxCheckPosts)
+
+	if err == ErrSinceUnsupported {
+		posts, err = backend.GetPostsSince(p.Room, since, outboxCheckPosts)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, post := range posts {
+		if post.PostID == p.ID {
+			return true, nil
+		}
+
+		// Best-effort: it's the same message, but it could be one they posted again
+		if post.PostID == "" && post.Alias == p.Alias && post.Message == p.Message {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Read the outbox saved in filename.
+// If filename doesn't exist, the outbox is empty.
+func LoadOutbox(filename string) (*Outbox, error) {
+	outbox := &Outbox{}
+
+	data, err := ioutil.ReadFile(filename)
+
+	if os.IsNotExist(err) {
+		return outbox, nil
+	}
+
+	if err != nil {
+		return outbox, errors.New("Error reading outbox: " + err.Error())
+	}
+
+	err = json.Unmarshal(data, outbox)
+
+	if err != nil {
+		return &Outbox{}, errors.New("Error parsing outbox in " + filename + ": " + err.Error())
+	}
+
+	return outbox, nil
+}
+
+// Save the outbox in filename, or remove it if the outbox is empty
+func (o *Outbox) Save(filename string) error {
+	if len(o.Posts) == 0 {
+		err := os.Remove(filename)
+
+		if err != nil && !os.IsNotExist(err) {
+			return errors.New("Error removing outbox: " + err.Error())
+		}
+
+		return nil
+	}
+
+	data, err := json.MarshalIndent(o, "", "    ")
+
+	if err != nil {
+		return errors.New("Error marshalling outbox: " + err.Error())
+	}
+
+	// Write a new file and rename it, so a crash can't leave half an outbox
+	err = ioutil.WriteFile(filename+".new", data, 0600)
+
+	if err == nil {
+		err = os.Rename(filename+".new", filename)
+	}
+
+	if err != nil {
+		return errors.New("Error saving outbox: " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// What an AddPostOnce call does instead of working
+type addFailure struct {
+	// Whether the post gets there anyway
+	added bool
+	err   error
+}
+
+// A memory backend whose AddPostOnce fails the way a Lambda backend can
+type flakyBackend struct {
+	*MemoryBackend
+	// What the next AddPostOnce calls do, in order; the ones after them work
+	failures []addFailure
+	// Ignore the ID, like the AddPost in setup/lambda
+	ignoreIDs bool
+	// The messages that got there, in order
+	added []string
+	// If set, the test backend's clock, moved a second after each post, as Send would wait
+	now *time.Time
+}
+
+func (f *flakyBackend) AddPostOnce(accessToken string, room string, message string, id string) error {
+	failure := addFailure{added: true}
+
+	if len(f.failures) > 0 {
+		failure, f.failures = f.failures[0], f.failures[1:]
+	}
+
+	if failure.added {
+		var err error
+
+		if f.ignoreIDs {
+			err = f.MemoryBackend.AddPost(accessToken, room, message)
+		} else {
+			err = f.MemoryBackend.AddPostOnce(accessToken, room, message, id)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		f.added = append(f.added, message)
+
+		if f.now != nil {
+			*f.now = f.now.Add(time.Second)
+		}
+	}
+
+	return failure.err
+}
+
+func TestOutboxSend(t *testing.T) {
+	unreachable := &UnreachableError{Operation: "AddPost", Err: errors.New("dial tcp: connection refused")}
+
+	tests := []struct {
+		name      string
+		room      string
+		ignoreIDs bool
+		failures  []addFailure
+		// How many posts the two Sends send, how many end up in the room,
+		// and the post's state afterwards, or "" if it's out of the outbox
+		wantSent  int
+		wantPosts int
+		wantState string
+	}{
+		{"sent", "", false, nil, 1, 1, ""},
+		{"unreachable, then sent", "", false, []addFailure{{false, unreachable}}, 1, 1, ""},
+		{"answer lost", "", false, []addFailure{{true, unreachable}}, 1, 1, ""},
+		{"answer lost, IDs ignored", "", true, []addFailure{{true, unreachable}}, 1, 1, ""},
+		{"still unreachable", "", false, []addFailure{{false, unreachable}, {false, unreachable}}, 0, 0, "pending"},
+		{"server error", "", false, []addFailure{{false, &Error{Operation: "AddPost", StatusCode: 502}}}, 1, 1, ""},
+		{"crashed function", "", false, []addFailure{{false, &Error{Operation: "AddPost"}}}, 1, 1, ""},
+		{"refused", "nowhere", false, nil, 0, 0, "failed"},
+		{"can't send as is", "", false, []addFailure{{false, errors.New("Error marshalling AddPost request")}}, 0, 0, "failed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, now := newTestBackend(t)
+			token := signInTestUser(t, m, "bob")
+			backend := &flakyBackend{MemoryBackend: m, failures: test.failures, ignoreIDs: test.ignoreIDs}
+
+			room := test.room
+
+			if room == "" {
+				room = m.DefaultRoom
+			}
+
+			o := &Outbox{}
+			post := o.Add("bob", room, "hi")
+			o.Posts[0].Created = strconv.FormatInt(now.Unix(), 10)
+
+			sent := 0
+
+			for i := 0; i < 2; i++ {
+				// A post sent twice would be two posts
+				*now = now.Add(time.Second)
+				o.lastSent = time.Time{}
+
+				n, _ := o.Send(backend, token, "bob")
+				sent += n
+			}
+
+			if sent != test.wantSent {
+				t.Errorf("sent %d, want %d", sent, test.wantSent)
+			}
+
+			posts, err := m.GetPosts(room, -1)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(posts) != test.wantPosts {
+				t.Errorf("got %d posts, want %d", len(posts), test.wantPosts)
+			}
+
+			waiting, ok := o.Get("bob", post.ID)
+			state := ""
+
+			if ok {
+				state = waiting.State()
+			}
+
+			if state != test.wantState {
+				t.Errorf("got state %q, want %q", state, test.wantState)
+			}
+		})
+	}
+}
+
+func TestOutboxSendOrder(t *testing.T) {
+	m, now := newTestBackend(t)
+	token := signInTestUser(t, m, "bob")
+	unreachable := &UnreachableError{Operation: "AddPost", Err: errors.New("i/o timeout")}
+	backend := &flakyBackend{MemoryBackend: m, failures: []addFailure{{false, unreachable}}, now: now}
+
+	o := &Outbox{}
+	o.Add("bob", m.DefaultRoom, "first")
+	o.Add("bob", m.DefaultRoom, "second")
+
+	sent, err := o.Send(backend, token, "bob")
+
+	if sent != 0 || err != unreachable {
+		t.Fatalf("got %d sent, error %v; want 0, %v", sent, err, unreachable)
+	}
+
+	// The second one waits behind the first
+	if o.Pending("bob") != 2 || o.Posts[1].Attempts != 0 {
+		t.Fatalf("got %+v, want both pending and the second untried", o.Posts)
+	}
+
+	*now = now.Add(time.Second)
+	sent, err = o.Send(backend, token, "bob")
+
+	if sent != 2 || err != nil {
+		t.Fatalf("got %d sent, error %v; want 2", sent, err)
+	}
+
+	if !sameStrings(backend.added, []string{"first", "second"}) {
+		t.Errorf("added %v, want first, then second", backend.added)
+	}
+}
+
+func TestOutboxSendTooSoon(t *testing.T) {
+	m, now := newTestBackend(t)
+	token := signInTestUser(t, m, "bob")
+
+	// Another client of bob's posted in this second
+	if err := m.AddPost(token, m.DefaultRoom, "elsewhere"); err != nil {
+		t.Fatal(err)
+	}
+
+	o := &Outbox{}
+	post := o.Add("bob", m.DefaultRoom, "hi")
+
+	sent, err := o.SendReady(m, token, "bob")
+
+	if sent != 0 || err != nil {
+		t.Fatalf("got %d sent, error %v; want 0", sent, err)
+	}
+
+	// It waits for the next second, rather than replacing the other post or failing
+	waiting, ok := o.Get("bob", post.ID)
+
+	if !ok || waiting.State() != "pending" || o.Wait() == 0 {
+		t.Fatalf("got %+v, wait %v; want it pending, with a wait", waiting, o.Wait())
+	}
+
+	*now = now.Add(time.Second)
+	o.lastSent = time.Time{}
+
+	sent, err = o.SendReady(m, token, "bob")
+
+	if sent != 1 || err != nil {
+		t.Fatalf("got %d sent, error %v; want 1", sent, err)
+	}
+
+	posts, err := m.GetPosts(m.DefaultRoom, -1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posts) != 2 {
+		t.Errorf("got %d posts, want 2", len(posts))
+	}
+}
+
+func TestOutboxUpdate(t *testing.T) {
+	m, _ := newTestBackend(t)
+	token := signInTestUser(t, m, "bob")
+	unreachable := &UnreachableError{Operation: "AddPost", Err: errors.New("i/o timeout")}
+
+	tests := []struct {
+		name     string
+		failures []addFailure
+		// Discard the post while it's being sent
+		discard bool
+		// The post's state afterwards, or "" if it's out of the outbox
+		wantState    string
+		wantAttempts int
+	}{
+		{"sent", nil, false, "", 0},
+		{"unreachable", []addFailure{{false, unreachable}}, false, "pending", 1},
+		{"sent after it was discarded", nil, true, "", 0},
+		{"unreachable after it was discarded", []addFailure{{false, unreachable}}, true, "", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := &flakyBackend{MemoryBackend: m, failures: test.failures}
+
+			o := &Outbox{}
+			o.Add("bob", m.DefaultRoom, "first")
+			next, ok := o.Next("bob")
+
+			if !ok {
+				t.Fatal("got no post to send")
+			}
+
+			if test.discard {
+				o.Discard("bob", next.ID)
+			}
+
+			sent, err := SendPost(backend, token, next)
+			o.Update(sent, err)
+
+			waiting, ok := o.Get("bob", next.ID)
+			state := ""
+
+			if ok {
+				state = waiting.State()
+			}
+
+			if state != test.wantState || waiting.Attempts != test.wantAttempts {
+				t.Errorf("got state %q after %d tries, want %q after %d", state, waiting.Attempts, test.wantState, test.wantAttempts)
+			}
+
+			if err == nil && o.Wait() == 0 {
+				t.Error("got no wait after sending a post")
+			}
+		})
+	}
+}
+
+func TestAlreadyPosted(t *testing.T) {
+	tests := []struct {
+		name string
+		// The post in the room since the outbox post was written, by bob,
+		// with this message and ID, if any
+		message string
+		id      string
+		// Whether the last try at sending the outbox post couldn't reach the backend
+		maybeSent bool
+		want      bool
+	}{
+		{"its ID", "hi", "outbox-post", true, true},
+		{"another post with the same message", "hi", "another-post", true, false},
+		{"no ID, same message", "hi", "", true, true},
+		{"no ID, another message", "bye", "", true, false},
+		{"never tried", "hi", "outbox-post", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, now := newTestBackend(t)
+			token := signInTestUser(t, m, "bob")
+
+			p := OutboxPost{ID: "outbox-post", Alias: "bob", Room: m.DefaultRoom, Message: "hi", Created: strconv.FormatInt(now.Unix(), 10), MaybeSent: test.maybeSent}
+
+			*now = now.Add(time.Second)
+
+			var err error
+
+			if test.id != "" {
+				err = m.AddPostOnce(token, m.DefaultRoom, test.message, test.id)
+			} else {
+				err = m.AddPost(token, m.DefaultRoom, test.message)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := alreadyPosted(m, p)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
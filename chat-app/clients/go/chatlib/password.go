@@ -0,0 +1,239 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Password policy:
+
+  A PasswordPolicy has the same values as the PasswordPolicy
+  of an Amazon Cognito user pool, so the clients can reject a password
+  before sending it, and say everything that's wrong with it at once
+  instead of one rule at a time.
+
+  The user pool still has the last word; keep the clients' policy
+  the same as the one in ../../setup/cognito/ChatRoomPool.yaml.
+*/
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type PasswordPolicy struct {
+	MinimumLength    int
+	RequireNumbers   bool
+	RequireSymbols   bool
+	RequireUppercase bool
+	RequireLowercase bool
+}
+
+// The policy of the user pool in ../../setup/cognito/ChatRoomPool.yaml
+var DefaultPasswordPolicy = PasswordPolicy{MinimumLength: 6, RequireNumbers: true}
+
+// The characters Amazon Cognito counts as symbols
+const PasswordSymbols = "^$*.[]{}()?\"!@#%&/\\,><':;|_~`=+- "
+
+// Get the policy, or DefaultPasswordPolicy if it isn't set
+func (p PasswordPolicy) OrDefault() PasswordPolicy {
+	if p == (PasswordPolicy{}) {
+		return DefaultPasswordPolicy
+	}
+
+	return p
+}
+
+// Describe the policy, such as "at least 6 characters, with a number"
+func (p PasswordPolicy) String() string {
+	var with []string
+
+	if p.RequireNumbers {
+		with = append(with, "a number")
+	}
+
+	if p.RequireSymbols {
+		with = append(with, "a symbol")
+	}
+
+	if p.RequireUppercase {
+		with = append(with, "an uppercase letter")
+	}
+
+	if p.RequireLowercase {
+		with = append(with, "a lowercase letter")
+	}
+
+	description := "at least " + strconv.Itoa(p.MinimumLength) + " characters"
+
+	if len(with) > 0 {
+		description += ", with " + joinWords(with)
+	}
+
+	return description
+}
+
+// Join words as "a, b, and c"
+func joinWords(words []string) string {
+	switch len(words) {
+	case 1:
+		return words[0]
+	case 2:
+		return words[0] + " and " + words[1]
+	}
+
+	return strings.Join(words[:len(words)-1], ", ") + ", and " + words[len(words)-1]
+}
+
+// What kinds of characters a password has
+type passwordClasses struct {
+	length    int
+	numbers   bool
+	symbols   bool
+	uppercase bool
+	lowercase bool
+	other     bool
+}
+
+func classifyPassword(password []byte) passwordClasses {
+	var classes passwordClasses
+
+	for i := 0; i < len(password); {
+		r, size := utf8.DecodeRune(password[i:])
+		i += size
+		classes.length++
+
+		switch {
+		case r < utf8.RuneSelf && strings.IndexByte(PasswordSymbols, byte(r)) >= 0:
+			classes.symbols = true
+		case unicode.IsDigit(r):
+			classes.numbers = true
+		case unicode.IsUpper(r):
+			classes.uppercase = true
+		case unicode.IsLower(r):
+			classes.lowercase = true
+		default:
+			classes.other = true
+		}
+	}
+
+	return classes
+}
+
+// Get every rule of the policy the password breaks, or nil if it breaks none
+func (p PasswordPolicy) Check(password []byte) []string {
+	var violations []string
+
+	classes := classifyPassword(password)
+
+	if classes.length < p.MinimumLength {
+		violations = append(violations, "Password must have at least "+strconv.Itoa(p.MinimumLength)+" characters")
+	}
+
+	if p.RequireNumbers && !classes.numbers {
+		violations = append(violations, "Password must have a number")
+	}
+
+	if p.RequireSymbols && !classes.symbols {
+		violations = append(violations, "Password must have a symbol, such as ! or #")
+	}
+
+	if p.RequireUppercase && !classes.uppercase {
+		violations = append(violations, "Password must have an uppercase letter")
+	}
+
+	if p.RequireLowercase && !classes.lowercase {
+		violations = append(violations, "Password must have a lowercase letter")
+	}
+
+	return violations
+}
+
+// A password that breaks one or more rules of a policy
+type PasswordError struct {
+	Violations []string
+}
+
+func (e *PasswordError) Error() string {
+	return strings.Join(e.Violations, "; ")
+}
+
+// Check the password, and return a *PasswordError with every rule it breaks
+func (p PasswordPolicy) Validate(password []byte) error {
+	violations := p.Check(password)
+
+	if len(violations) > 0 {
+		return &PasswordError{Violations: violations}
+	}
+
+	return nil
+}
+
+// How hard a password is to guess
+type PasswordStrength int
+
+const (
+	WeakPassword PasswordStrength = iota
+	FairPassword
+	GoodPassword
+	StrongPassword
+)
+
+func (s PasswordStrength) String() string {
+	switch s {
+	case FairPassword:
+		return "fair"
+	case GoodPassword:
+		return "good"
+	case StrongPassword:
+		return "strong"
+	}
+
+	return "weak"
+}
+
+// Rate a password by its length and how many kinds of characters it has.
+// A password that breaks the policy is always weak.
+// static/chat.js in the GUI rates passwords the same way.
+func (p PasswordPolicy) Strength(password []byte) PasswordStrength {
+	if len(p.Check(password)) > 0 {
+		return WeakPassword
+	}
+
+	classes := classifyPassword(password)
+	kinds := 0
+
+	for _, has := range []bool{classes.numbers, classes.symbols, classes.uppercase, classes.lowercase, classes.other} {
+		if has {
+			kinds++
+		}
+	}
+
+	score := kinds
+
+	if classes.length >= 10 {
+		score++
+	}
+
+	if classes.length >= 14 {
+		score++
+	}
+
+	switch {
+	case score >= 5:
+		return StrongPassword
+	case score >= 4:
+		return GoodPassword
+	case score >= 2:
+		return FairPassword
+	}
+
+	return WeakPassword
+}
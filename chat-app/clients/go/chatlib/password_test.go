@@ -0,0 +1,111 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Every rule, like a user pool with all of them turned on
+var strictPasswordPolicy = PasswordPolicy{MinimumLength: 8, RequireNumbers: true, RequireSymbols: true, RequireUppercase: true, RequireLowercase: true}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		want     []string
+	}{
+		{"default, good", DefaultPasswordPolicy, "abc123", nil},
+		{"default, short", DefaultPasswordPolicy, "abc12", []string{"Password must have at least 6 characters"}},
+		{"default, no number", DefaultPasswordPolicy, "abcdef", []string{"Password must have a number"}},
+		{"default, empty", DefaultPasswordPolicy, "", []string{"Password must have at least 6 characters", "Password must have a number"}},
+		{"strict, good", strictPasswordPolicy, "Passw0rd!", nil},
+		{"strict, only lowercase", strictPasswordPolicy, "password", []string{
+			"Password must have a number",
+			"Password must have a symbol, such as ! or #",
+			"Password must have an uppercase letter",
+		}},
+		{"strict, space is a symbol", strictPasswordPolicy, "Pass w0rd", nil},
+		{"strict, non-ASCII uppercase", strictPasswordPolicy, "PÄSSW0RD!", []string{"Password must have a lowercase letter"}},
+		{"length counts characters, not bytes", PasswordPolicy{MinimumLength: 7}, "ééééé1", []string{"Password must have at least 7 characters"}},
+		{"unset policy allows anything", PasswordPolicy{}, "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.Validate([]byte(test.password))
+
+			if test.want == nil {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+
+				return
+			}
+
+			passwordError, ok := err.(*PasswordError)
+
+			if !ok {
+				t.Fatalf("got error %v, want a *PasswordError", err)
+			}
+
+			if !reflect.DeepEqual(passwordError.Violations, test.want) {
+				t.Errorf("got violations %q, want %q", passwordError.Violations, test.want)
+			}
+		})
+	}
+}
+
+func TestPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		want     PasswordStrength
+	}{
+		{"abc12", WeakPassword},
+		{"111111", WeakPassword},
+		{"abc123", FairPassword},
+		{"abcdef1234", FairPassword},
+		{"abcDEF123", FairPassword},
+		{"abcDEF1234", GoodPassword},
+		{"abcdefghij12345", GoodPassword},
+		{"abcDEF123!", StrongPassword},
+		{"abcdefghij1234!", StrongPassword},
+	}
+
+	for _, test := range tests {
+		t.Run(test.password, func(t *testing.T) {
+			got := DefaultPasswordPolicy.Strength([]byte(test.password))
+
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyString(t *testing.T) {
+	tests := []struct {
+		policy PasswordPolicy
+		want   string
+	}{
+		{DefaultPasswordPolicy, "at least 6 characters, with a number"},
+		{PasswordPolicy{MinimumLength: 8}, "at least 8 characters"},
+		{PasswordPolicy{MinimumLength: 8, RequireUppercase: true, RequireLowercase: true}, "at least 8 characters, with an uppercase letter and a lowercase letter"},
+		{strictPasswordPolicy, "at least 8 characters, with a number, a symbol, an uppercase letter, and a lowercase letter"},
+	}
+
+	for _, test := range tests {
+		if got := test.policy.String(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
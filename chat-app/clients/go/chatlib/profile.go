@@ -0,0 +1,110 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"time"
+)
+
+// Tokens that expire sooner than this are about to expire
+const ExpiringSoon = 5 * time.Minute
+
+// Who is signed in, and with what, as the whoami command
+// and the profile panel show it
+type Profile struct {
+	UserName      string
+	Email         string
+	EmailVerified bool
+	ExpiresAt     time.Time
+	// Whether we got a refresh token when the user signed in
+	HasRefreshToken bool
+	// Whether the tokens were checked against the user pool's keys
+	Verified bool
+	Region   string
+	// The Lambda functions that aren't deployed under their own names,
+	// as "Name: FunctionName" or "Name: FunctionName:Qualifier"
+	Deployment []string
+	// How many of the posts we got are the user's
+	Posts int
+	// The MFA the user signs in with, such as SOFTWARE_TOKEN_MFA;
+	// "" for none, and MFAUnknown if we could not find out
+	MFA string
+}
+
+// Profile.MFA when the backend could not tell us
+const MFAUnknown = "unknown"
+
+// Start a profile from the claims of an access token and an ID token.
+// The ID token can be empty.
+func NewProfile(access Claims, id Claims, verified bool) Profile {
+	return Profile{
+		UserName:      access.UserName(),
+		Email:         id.Email,
+		EmailVerified: id.EmailVerified,
+		ExpiresAt:     access.ExpiresAt(),
+		Verified:      verified,
+	}
+}
+
+// Describe the user's email address
+func (p Profile) EmailStatus() string {
+	switch {
+	case p.Email == "":
+		return "(unknown)"
+	case p.EmailVerified:
+		return p.Email + " (verified)"
+	default:
+		return p.Email + " (not verified)"
+	}
+}
+
+// Describe when the tokens expire
+func (p Profile) ExpiryStatus(now time.Time) string {
+	left := p.ExpiresAt.Sub(now).Round(time.Second)
+	when := p.ExpiresAt.Local().Format(time.RFC1123)
+
+	switch {
+	case left <= 0:
+		return "Expired at " + when
+	case left < ExpiringSoon:
+		return "Expires soon, at " + when + " (in " + left.String() + ")"
+	default:
+		return "Expires at " + when + " (in " + left.String() + ")"
+	}
+}
+
+// Describe whether the tokens can be refreshed
+func (p Profile) RefreshStatus(now time.Time) string {
+	if !p.HasRefreshToken {
+		return "No refresh token; sign in again once your tokens expire"
+	}
+
+	if !now.Before(p.ExpiresAt) {
+		return "Have a refresh token, but the tokens are not refreshed automatically; sign in again"
+	}
+
+	return "Have a refresh token; the tokens are not refreshed automatically"
+}
+
+// Describe what the user needs, besides their password, to sign in
+func (p Profile) MFAStatus() string {
+	switch p.MFA {
+	case "":
+		return "Off; signing in only takes your password"
+	case SoftwareTokenMFAChallenge:
+		return "On; signing in asks for a code from your authenticator app"
+	case SMSMFAChallenge:
+		return "On; signing in asks for a code we text you"
+	case MFAUnknown:
+		return "(unknown)"
+	}
+
+	return "On (" + p.MFA + ")"
+}
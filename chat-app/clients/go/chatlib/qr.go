@@ -0,0 +1,92 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  QR codes:
+
+  To add the chat app to an authenticator app, the user scans
+  a QR code of the otpauth:// URI from TOTPURI.
+  The GUI shows it as a PNG; the command-line app draws it with
+  half-block characters, so each line of text holds two rows of the code.
+*/
+
+import (
+	"errors"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Get the modules of a QR code for content, with its quiet zone; true is dark
+func qrModules(content string) ([][]bool, error) {
+	code, err := qrcode.New(content, qrcode.Medium)
+
+	if err != nil {
+		return nil, errors.New("Error creating QR code: " + err.Error())
+	}
+
+	return code.Bitmap(), nil
+}
+
+// Get a QR code for content as a PNG image, size pixels on a side
+func QRCodePNG(content string, size int) ([]byte, error) {
+	png, err := qrcode.Encode(content, qrcode.Medium, size)
+
+	if err != nil {
+		return nil, errors.New("Error creating QR code: " + err.Error())
+	}
+
+	return png, nil
+}
+
+// ANSI escapes that draw bright white on black, and go back to the terminal's colors
+const (
+	qrColors     = "\x1b[97;40m"
+	qrResetColor = "\x1b[0m"
+)
+
+// Draw a QR code for content with half blocks, for a terminal.
+// The blocks draw the light modules, in white on black,
+// so the code scans whatever colors the terminal uses.
+func QRCodeHalfBlocks(content string) (string, error) {
+	modules, err := qrModules(content)
+
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+
+	for row := 0; row < len(modules); row += 2 {
+		text.WriteString(qrColors)
+
+		for column := range modules[row] {
+			topLight := !modules[row][column]
+			// An odd number of rows leaves a light row below the code
+			bottomLight := row+1 >= len(modules) || !modules[row+1][column]
+
+			switch {
+			case topLight && bottomLight:
+				text.WriteString("█")
+			case topLight:
+				text.WriteString("▀")
+			case bottomLight:
+				text.WriteString("▄")
+			default:
+				text.WriteString(" ")
+			}
+		}
+
+		text.WriteString(qrResetColor + "\n")
+	}
+
+	return text.String(), nil
+}
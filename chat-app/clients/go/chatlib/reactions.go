@@ -0,0 +1,181 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Reactions:
+
+  A signed-in user can react to any post with an emoji,
+  named by a shortcode such as :thumbsup: or :tada:.
+  Each user gets one reaction of each kind per post:
+  adding one they already have, or removing one they don't, does nothing.
+
+  A post keeps, for each shortcode, the set of users who reacted with it;
+  in the Posts table, that's a Reactions map of string sets.
+  ReactionCounts adds them up for the clients to show under the message.
+*/
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The reactions users can pick from, in the order the clients offer them
+var ReactionNames = []string{"thumbsup", "thumbsdown", "heart", "laughing", "tada", "eyes", "thinking", "rocket"}
+
+// The emoji for each reaction
+var reactionEmoji = map[string]string{
+	"thumbsup":   "\U0001F44D",
+	"thumbsdown": "\U0001F44E",
+	"heart":      "\u2764\uFE0F",
+	"laughing":   "\U0001F606",
+	"tada":       "\U0001F389",
+	"eyes":       "\U0001F440",
+	"thinking":   "\U0001F914",
+	"rocket":     "\U0001F680",
+}
+
+// Other names people type for the reactions
+var reactionAliases = map[string]string{
+	"+1":     "thumbsup",
+	"-1":     "thumbsdown",
+	"like":   "thumbsup",
+	"love":   "heart",
+	"laugh":  "laughing",
+	"joy":    "laughing",
+	"hooray": "tada",
+}
+
+// Turn what a user typed, such as :+1: or Tada, into the name of a reaction
+func NormalizeReaction(reaction string) string {
+	reaction = strings.ToLower(strings.Trim(strings.TrimSpace(reaction), ":"))
+
+	if name, ok := reactionAliases[reaction]; ok {
+		return name
+	}
+
+	return reaction
+}
+
+// Get the problem with a normalized reaction name, or "" if there isn't one
+func CheckReaction(reaction string) string {
+	if reaction == "" {
+		return "Pick a reaction"
+	}
+
+	if _, ok := reactionEmoji[reaction]; !ok {
+		return "There is no reaction :" + reaction + ":; pick one of :" + strings.Join(ReactionNames, ": :") + ":"
+	}
+
+	return ""
+}
+
+// Get the emoji for a reaction, or its shortcode if we don't know it
+func ReactionEmoji(reaction string) string {
+	if emoji, ok := reactionEmoji[reaction]; ok {
+		return emoji
+	}
+
+	return ":" + reaction + ":"
+}
+
+// How many users reacted to a post with one reaction
+type ReactionCount struct {
+	Name  string
+	Emoji string
+	Count int
+	// Whether the user we asked about is one of them
+	Mine bool
+}
+
+// Add up the reactions to p, most first;
+// userName, if it isn't empty, sets Mine
+func (p Post) ReactionCounts(userName string) []ReactionCount {
+	var counts []ReactionCount
+
+	for name, users := range p.Reactions {
+		if len(users) == 0 {
+			continue
+		}
+
+		count := ReactionCount{Name: name, Emoji: ReactionEmoji(name), Count: len(users)}
+
+		for _, user := range users {
+			if userName != "" && user == userName {
+				count.Mine = true
+			}
+		}
+
+		counts = append(counts, count)
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+
+		return counts[i].Name < counts[j].Name
+	})
+
+	return counts
+}
+
+// True if userName reacted to p with reaction
+func (p Post) Reacted(userName string, reaction string) bool {
+	for _, user := range p.Reactions[reaction] {
+		if user == userName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Show counts on one line, such as "👍 2  🎉 1", or "" if there are none
+func ReactionSummary(counts []ReactionCount) string {
+	var parts []string
+
+	for _, count := range counts {
+		parts = append(parts, count.Emoji+" "+strconv.Itoa(count.Count))
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+// Copy reactions, adding or removing userName's reaction,
+// so posts that share the old map don't change
+func withReaction(reactions map[string][]string, userName string, reaction string, add bool) map[string][]string {
+	result := make(map[string][]string)
+
+	for name, users := range reactions {
+		if name != reaction {
+			result[name] = users
+		}
+	}
+
+	var users []string
+
+	for _, user := range reactions[reaction] {
+		if user != userName {
+			users = append(users, user)
+		}
+	}
+
+	if add {
+		users = append(users, userName)
+	}
+
+	if len(users) > 0 {
+		result[reaction] = users
+	}
+
+	return result
+}
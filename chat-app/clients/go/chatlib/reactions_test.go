@@ -0,0 +1,132 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithReaction(t *testing.T) {
+	tests := []struct {
+		name      string
+		reactions map[string][]string
+		userName  string
+		reaction  string
+		add       bool
+		want      map[string][]string
+	}{
+		{"first reaction", nil, "bob", "tada", true, map[string][]string{"tada": {"bob"}}},
+		{"another user", map[string][]string{"tada": {"bob"}}, "alice", "tada", true, map[string][]string{"tada": {"bob", "alice"}}},
+		{"another reaction", map[string][]string{"tada": {"bob"}}, "bob", "heart", true, map[string][]string{"tada": {"bob"}, "heart": {"bob"}}},
+		{"added twice", map[string][]string{"tada": {"bob"}}, "bob", "tada", true, map[string][]string{"tada": {"bob"}}},
+		{"removed", map[string][]string{"tada": {"bob", "alice"}}, "bob", "tada", false, map[string][]string{"tada": {"alice"}}},
+		{"last one removed", map[string][]string{"tada": {"bob"}, "heart": {"alice"}}, "bob", "tada", false, map[string][]string{"heart": {"alice"}}},
+		{"removed without reacting", map[string][]string{"tada": {"alice"}}, "bob", "tada", false, map[string][]string{"tada": {"alice"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := copyReactions(test.reactions)
+			got := withReaction(test.reactions, test.userName, test.reaction, test.add)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+
+			if !reflect.DeepEqual(test.reactions, before) {
+				t.Errorf("changed the old reactions to %v", test.reactions)
+			}
+		})
+	}
+}
+
+func copyReactions(reactions map[string][]string) map[string][]string {
+	if reactions == nil {
+		return nil
+	}
+
+	c := make(map[string][]string)
+
+	for name, users := range reactions {
+		c[name] = append([]string(nil), users...)
+	}
+
+	return c
+}
+
+func TestReactionCounts(t *testing.T) {
+	p := Post{Reactions: map[string][]string{
+		"tada":     {"alice"},
+		"thumbsup": {"alice", "bob"},
+		"heart":    {"bob"},
+		"eyes":     {},
+	}}
+
+	tests := []struct {
+		name     string
+		userName string
+		want     []ReactionCount
+	}{
+		{"signed in", "bob", []ReactionCount{
+			{Name: "thumbsup", Emoji: ReactionEmoji("thumbsup"), Count: 2, Mine: true},
+			{Name: "heart", Emoji: ReactionEmoji("heart"), Count: 1, Mine: true},
+			{Name: "tada", Emoji: ReactionEmoji("tada"), Count: 1},
+		}},
+		{"signed out", "", []ReactionCount{
+			{Name: "thumbsup", Emoji: ReactionEmoji("thumbsup"), Count: 2},
+			{Name: "heart", Emoji: ReactionEmoji("heart"), Count: 1},
+			{Name: "tada", Emoji: ReactionEmoji("tada"), Count: 1},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := p.ReactionCounts(test.userName); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	if got := (Post{}).ReactionCounts("bob"); len(got) != 0 {
+		t.Errorf("got %+v for a post without reactions", got)
+	}
+}
+
+func TestNormalizeReaction(t *testing.T) {
+	tests := []struct {
+		typed string
+		want  string
+		// The problem CheckReaction has with it, if any
+		problem bool
+	}{
+		{"tada", "tada", false},
+		{":tada:", "tada", false},
+		{" Tada ", "tada", false},
+		{":+1:", "thumbsup", false},
+		{":LOVE:", "heart", false},
+		{"::", "", true},
+		{":sparkles:", "sparkles", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.typed, func(t *testing.T) {
+			got := NormalizeReaction(test.typed)
+
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+
+			if problem := CheckReaction(got); (problem != "") != test.problem {
+				t.Errorf("got problem %q", problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Chat rooms:
+
+  Every post is in a room. Anyone can read any room,
+  but only a room's members can post to it, or reply to the posts in it.
+  A user joins a room with JoinRoom, or by creating it with CreateRoom.
+
+  Everyone is a member of the default room, which always exists,
+  DefaultRoom unless the backend is created with another one (NewBackend).
+  Posts from before there were rooms don't have a room,
+  so the backend puts them in the default room too, and every post it returns has a Room.
+
+  Room names are short and lowercase, such as general or release-2,
+  so they fit in the CLI's prompt and in a URL as they are.
+*/
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The room everyone is in, and where posts without a room are,
+// unless the configuration names another
+const DefaultRoom = "general"
+
+// The longest room name we accept
+const MaximumRoomNameLength = 32
+
+type Room struct {
+	Name string
+	// Who created it, and when, as Unix time in seconds;
+	// empty for the default room
+	CreatedBy string
+	CreatedAt string
+	// The users who can post to it
+	Members []string
+	// True for the default room, which everyone can post to
+	Everyone bool
+}
+
+// Whether userName can post to the room
+func (r Room) HasMember(userName string) bool {
+	if r.Everyone {
+		return true
+	}
+
+	for _, member := range r.Members {
+		if member == userName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Trim a room name, drop the # people put in front of it, and make it lowercase
+func NormalizeRoomName(name string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+}
+
+// Get every problem with a normalized room name, or nil if there are none
+func CheckRoomName(name string) []string {
+	if name == "" {
+		return []string{"Enter a room name"}
+	}
+
+	var problems []string
+
+	if utf8.RuneCountInString(name) > MaximumRoomNameLength {
+		problems = append(problems, "Room name must have at most "+strconv.Itoa(MaximumRoomNameLength)+" characters")
+	}
+
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			problems = append(problems, "Room name can only have the letters a to z, numbers, - and _")
+			break
+		}
+	}
+
+	return problems
+}
+
+// Make sure rooms has defaultRoom, marked as everyone's,
+// and sort them by name, with defaultRoom first
+func withDefaultRoom(rooms []Room, defaultRoom string) []Room {
+	found := false
+
+	for i := range rooms {
+		if rooms[i].Name == defaultRoom {
+			rooms[i].Everyone = true
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		rooms = append(rooms, Room{Name: defaultRoom, Everyone: true})
+	}
+
+	sort.SliceStable(rooms, func(i, j int) bool {
+		if rooms[i].Everyone || rooms[j].Everyone {
+			return rooms[i].Everyone && !rooms[j].Everyone
+		}
+
+		return rooms[i].Name < rooms[j].Name
+	})
+
+	return rooms
+}
+
+// Find a room by name
+func FindRoom(rooms []Room, name string) (Room, bool) {
+	for _, room := range rooms {
+		if room.Name == name {
+			return room, true
+		}
+	}
+
+	return Room{}, false
+}
@@ -0,0 +1,539 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Searching posts:
+
+  The backend can only get the latest posts in a room,
+  so the clients keep a SearchIndex of every post they've gotten from GetPosts,
+  in a file, and search that. Each page of posts a client gets goes into the index,
+  so it grows as the clients are used; RefreshIndex adds the latest posts in every room.
+
+  A query is words, "exact phrases", and these, in any order:
+
+    from:bob          posts by bob (more than one from: means any of them)
+    in:dev            posts in the dev room
+    after:2017-11-01  posts on or after November 1, 2017
+    before:2017-11-02 posts before November 2, 2017
+
+  A post matches if it has every word (in any case, as a whole word),
+  every phrase, and passes every filter.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// The format of before: and after: dates
+const SearchDateFormat = "2006-01-02"
+
+type Query struct {
+	// Lowercase words, all of which a post must have
+	Words []string
+	// Lowercase phrases, all of which a post must have
+	Phrases []string
+	// The users whose posts match; empty for anyone
+	From []string
+	// The room whose posts match; empty for any room
+	Room string
+	// Only posts at or after After, and before Before; zero for no limit
+	After  time.Time
+	Before time.Time
+}
+
+// Split text into lowercase words: runs of letters, marks, and numbers
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.In(r, unicode.L, unicode.M, unicode.N)
+	})
+}
+
+// Lowercase text and collapse its spaces, so phrases match however they're spaced
+func searchText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// Split a query into terms: words, operators, and quoted phrases (with their quotes)
+func queryTerms(query string) []string {
+	var terms []string
+	var term strings.Builder
+	quoted := false
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			term.WriteRune(r)
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+		default:
+			term.WriteRune(r)
+		}
+	}
+
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+
+	return terms
+}
+
+// Parse a query; dates are midnight in location
+func ParseQuery(query string, location *time.Location) (Query, error) {
+	var q Query
+
+	for _, term := range queryTerms(query) {
+		operator := ""
+		value := term
+
+		if colon := strings.Index(term, ":"); colon > 0 && !strings.Contains(term[:colon], "\"") {
+			operator = strings.ToLower(term[:colon])
+			value = strings.Trim(term[colon+1:], "\"")
+		}
+
+		switch operator {
+		case "from":
+			value = strings.TrimPrefix(value, "@")
+
+			if value == "" {
+				return q, errors.New("from: takes a user name, such as from:bob")
+			}
+
+			q.From = append(q.From, value)
+		case "in":
+			value = NormalizeRoomName(value)
+
+			if value == "" {
+				return q, errors.New("in: takes a room, such as in:general")
+			}
+
+			q.Room = value
+		case "before", "after":
+			date, err := time.ParseInLocation(SearchDateFormat, value, location)
+
+			if err != nil {
+				return q, errors.New(operator + ": takes a date such as " + operator + ":2017-11-01, not " + value)
+			}
+
+			if operator == "before" {
+				q.Before = date
+			} else {
+				q.After = date
+			}
+		default:
+			if strings.HasPrefix(term, "\"") {
+				if phrase := searchText(strings.Trim(term, "\"")); phrase != "" {
+					q.Phrases = append(q.Phrases, phrase)
+				}
+			} else {
+				q.Words = append(q.Words, searchWords(term)...)
+			}
+		}
+	}
+
+	if len(q.Words) == 0 && len(q.Phrases) == 0 && len(q.From) == 0 && q.Room == "" && q.After.IsZero() && q.Before.IsZero() {
+		return q, errors.New("Enter something to search for")
+	}
+
+	return q, nil
+}
+
+// True if p matches every part of q
+func (q Query) Matches(p Post) bool {
+	if len(q.From) > 0 {
+		from := false
+
+		for _, alias := range q.From {
+			if strings.EqualFold(alias, p.Alias) {
+				from = true
+			}
+		}
+
+		if !from {
+			return false
+		}
+	}
+
+	if q.Room != "" && q.Room != p.Room {
+		return false
+	}
+
+	seconds, _ := strconv.ParseInt(p.Timestamp, 10, 64)
+	posted := time.Unix(seconds, 0)
+
+	if !q.After.IsZero() && posted.Before(q.After) {
+		return false
+	}
+
+	if !q.Before.IsZero() && !posted.Before(q.Before) {
+		return false
+	}
+
+	words := make(map[string]bool)
+
+	for _, word := range searchWords(p.Message) {
+		words[word] = true
+	}
+
+	for _, word := range q.Words {
+		if !words[word] {
+			return false
+		}
+	}
+
+	text := searchText(p.Message)
+
+	for _, phrase := range q.Phrases {
+		if !strings.Contains(text, phrase) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// A piece of a message: text, or a word or phrase a query matched
+type SearchPart struct {
+	Text  string
+	Match bool
+}
+
+// Cut message into the words and phrases of q, and the text between them.
+// Joining the Text of the parts gives back message.
+func (q Query) Highlight(message string) []SearchPart {
+	runes := []rune(message)
+	lower := []rune(strings.ToLower(message))
+	matched := make([]bool, len(runes))
+
+	// ToLower can change the length of some text; don't highlight that
+	if len(lower) != len(runes) {
+		return []SearchPart{{Text: message}}
+	}
+
+	words := make(map[string]bool)
+
+	for _, word := range q.Words {
+		words[word] = true
+	}
+
+	isWord := func(r rune) bool { return unicode.In(r, unicode.L, unicode.M, unicode.N) }
+
+	for start := 0; start < len(lower); {
+		if !isWord(lower[start]) {
+			start++
+			continue
+		}
+
+		end := start
+
+		for end < len(lower) && isWord(lower[end]) {
+			end++
+		}
+
+		if words[string(lower[start:end])] {
+			for i := start; i < end; i++ {
+				matched[i] = true
+			}
+		}
+
+		start = end
+	}
+
+	for _, phrase := range q.Phrases {
+		p := []rune(phrase)
+
+		for start := 0; start < len(lower); start++ {
+			// Match the phrase, letting any run of spaces match one space
+			i, j := start, 0
+
+			for i < len(lower) && j < len(p) {
+				if p[j] == ' ' && unicode.IsSpace(lower[i]) {
+					for i < len(lower) && unicode.IsSpace(lower[i]) {
+						i++
+					}
+
+					j++
+				} else if lower[i] == p[j] {
+					i++
+					j++
+				} else {
+					break
+				}
+			}
+
+			if j == len(p) {
+				for k := start; k < i; k++ {
+					matched[k] = true
+				}
+			}
+		}
+	}
+
+	var parts []SearchPart
+
+	for start := 0; start < len(runes); {
+		end := start
+
+		for end < len(runes) && matched[end] == matched[start] {
+			end++
+		}
+
+		parts = append(parts, SearchPart{Text: string(runes[start:end]), Match: matched[start]})
+		start = end
+	}
+
+	return parts
+}
+
+// The posts a client has gotten, to search
+type SearchIndex struct {
+	mutex sync.Mutex
+	posts map[PostKey]Post
+	// The posts with each word
+	words map[string]map[PostKey]bool
+	// True if the index changed since it was loaded or saved
+	changed bool
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{posts: make(map[PostKey]Post), words: make(map[string]map[PostKey]bool)}
+}
+
+func (s *SearchIndex) remove(key PostKey) {
+	old, ok := s.posts[key]
+
+	if !ok {
+		return
+	}
+
+	for _, word := range searchWords(old.Message) {
+		delete(s.words[word], key)
+
+		if len(s.words[word]) == 0 {
+			delete(s.words, word)
+		}
+	}
+
+	delete(s.posts, key)
+}
+
+func (s *SearchIndex) add(p Post) {
+	s.remove(p.Key())
+	s.posts[p.Key()] = p
+
+	for _, word := range searchWords(p.Message) {
+		if s.words[word] == nil {
+			s.words[word] = make(map[PostKey]bool)
+		}
+
+		s.words[word][p.Key()] = true
+	}
+}
+
+// Add posts, or replace the ones with the same keys, such as edited posts.
+// Returns how many are new or changed.
+func (s *SearchIndex) Add(posts []Post) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+
+	for _, p := range posts {
+		if old, ok := s.posts[p.Key()]; ok && old.Message == p.Message && old.EditedAt == p.EditedAt && old.Room == p.Room {
+			// Keep the latest reactions, without counting it as a change
+			s.posts[p.Key()] = p
+			continue
+		}
+
+		s.add(p)
+		count++
+	}
+
+	if count > 0 {
+		s.changed = true
+	}
+
+	return count
+}
+
+// Forget a post, such as one that was deleted
+func (s *SearchIndex) Remove(key PostKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.posts[key]; ok {
+		s.remove(key)
+		s.changed = true
+	}
+}
+
+// How many posts the index has
+func (s *SearchIndex) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.posts)
+}
+
+// Get the posts that match q, newest first
+func (s *SearchIndex) Search(q Query) []Post {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Start with the posts that have the rarest word, if there are words
+	var candidates map[PostKey]bool
+
+	for i, word := range q.Words {
+		if i == 0 || len(s.words[word]) < len(candidates) {
+			candidates = s.words[word]
+		}
+	}
+
+	var found []Post
+
+	if len(q.Words) > 0 {
+		for key := range candidates {
+			if p := s.posts[key]; q.Matches(p) {
+				found = append(found, p)
+			}
+		}
+	} else {
+		for _, p := range s.posts {
+			if q.Matches(p) {
+				found = append(found, p)
+			}
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		a, _ := strconv.ParseInt(found[i].Timestamp, 10, 64)
+		b, _ := strconv.ParseInt(found[j].Timestamp, 10, 64)
+
+		if a != b {
+			return a > b
+		}
+
+		return found[i].Alias < found[j].Alias
+	})
+
+	return found
+}
+
+// Add the latest maxPosts posts in every room to the index.
+// Returns how many posts are new or changed.
+func RefreshIndex(backend Backend, index *SearchIndex, maxPosts int) (int, error) {
+	rooms, err := backend.ListRooms()
+
+	if err != nil {
+		return 0, errors.New("Could not get rooms: " + err.Error())
+	}
+
+	count := 0
+
+	for _, room := range rooms {
+		posts, err := backend.GetPosts(room.Name, maxPosts)
+
+		if err != nil {
+			return count, errors.New("Could not get the posts in " + room.Name + ": " + err.Error())
+		}
+
+		count += index.Add(posts)
+	}
+
+	return count, nil
+}
+
+// The file a SearchIndex is saved in
+type searchIndexFile struct {
+	Posts []Post
+}
+
+// Read the index saved in filename.
+// If filename doesn't exist, the index is empty.
+func LoadSearchIndex(filename string) (*SearchIndex, error) {
+	index := NewSearchIndex()
+
+	data, err := ioutil.ReadFile(filename)
+
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+
+	if err != nil {
+		return index, errors.New("Error reading search index: " + err.Error())
+	}
+
+	var file searchIndexFile
+
+	err = json.Unmarshal(data, &file)
+
+	if err != nil {
+		return index, errors.New("Error parsing search index in " + filename + ": " + err.Error())
+	}
+
+	for _, p := range file.Posts {
+		index.add(p)
+	}
+
+	return index, nil
+}
+
+// Save the index in filename, if it changed since it was loaded or last saved
+func (s *SearchIndex) Save(filename string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.changed {
+		return nil
+	}
+
+	file := searchIndexFile{Posts: []Post{}}
+
+	for _, p := range s.posts {
+		file.Posts = append(file.Posts, p)
+	}
+
+	sort.Slice(file.Posts, func(i, j int) bool {
+		if file.Posts[i].Timestamp != file.Posts[j].Timestamp {
+			return file.Posts[i].Timestamp < file.Posts[j].Timestamp
+		}
+
+		return file.Posts[i].Alias < file.Posts[j].Alias
+	})
+
+	data, err := json.MarshalIndent(file, "", "    ")
+
+	if err != nil {
+		return errors.New("Error marshalling search index: " + err.Error())
+	}
+
+	err = ioutil.WriteFile(filename, data, 0600)
+
+	if err != nil {
+		return errors.New("Error saving search index: " + err.Error())
+	}
+
+	s.changed = false
+
+	return nil
+}
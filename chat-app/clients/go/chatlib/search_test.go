@@ -0,0 +1,155 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	date := func(s string) time.Time {
+		d, _ := time.ParseInLocation(SearchDateFormat, s, time.UTC)
+		return d
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  Query
+		// Part of the error we want, if any
+		err string
+	}{
+		{"words", "Lunch today", Query{Words: []string{"lunch", "today"}}, ""},
+		{"punctuation splits words", "re:lunch, today?", Query{Words: []string{"re", "lunch", "today"}}, ""},
+		{"word with an accent", "Café", Query{Words: []string{"café"}}, ""},
+		{"phrase", `"Lunch   Today"`, Query{Phrases: []string{"lunch today"}}, ""},
+		{"unclosed phrase", `"lunch today`, Query{Phrases: []string{"lunch today"}}, ""},
+		{"empty phrase", `"" lunch`, Query{Words: []string{"lunch"}}, ""},
+		{"operator in a phrase", `"from:bob"`, Query{Phrases: []string{"from:bob"}}, ""},
+		{"from", "from:bob from:@alice", Query{From: []string{"bob", "alice"}}, ""},
+		{"quoted from", `from:"bob"`, Query{From: []string{"bob"}}, ""},
+		{"operator in any case", "FROM:Bob", Query{From: []string{"Bob"}}, ""},
+		{"in", "in:#Dev", Query{Room: "dev"}, ""},
+		{"dates", "after:2017-11-01 before:2017-11-02", Query{After: date("2017-11-01"), Before: date("2017-11-02")}, ""},
+		{"everything", `from:bob in:general after:2017-11-01 "lunch today" pizza`, Query{
+			Words:   []string{"pizza"},
+			Phrases: []string{"lunch today"},
+			From:    []string{"bob"},
+			Room:    "general",
+			After:   date("2017-11-01"),
+		}, ""},
+		{"bad date", "before:2017-13-01", Query{}, "before: takes a date"},
+		{"not a date", "after:yesterday", Query{}, "after: takes a date"},
+		{"empty from", "from: lunch", Query{}, "from: takes a user name"},
+		{"empty in", "in:#", Query{}, "in: takes a room"},
+		{"nothing", "   ", Query{}, "Enter something to search for"},
+		{"only punctuation", "?!", Query{}, "Enter something to search for"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseQuery(test.query, time.UTC)
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	midnight := time.Date(2017, 11, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+	post := func(alias string, seconds int64, message string) Post {
+		return Post{Alias: alias, Timestamp: strconv.FormatInt(seconds, 10), Message: message, Room: "general"}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		post  Post
+		want  bool
+	}{
+		{"every word", "lunch today", post("bob", midnight, "Today: lunch!"), true},
+		{"missing a word", "lunch today", post("bob", midnight, "lunch tomorrow"), false},
+		{"whole words only", "lunch", post("bob", midnight, "lunchtime"), false},
+		{"phrase", `"lunch today"`, post("bob", midnight, "Lunch   today?"), true},
+		{"phrase out of order", `"lunch today"`, post("bob", midnight, "today lunch"), false},
+		{"from, any case", "from:BOB", post("bob", midnight, "hi"), true},
+		{"from someone else", "from:alice", post("bob", midnight, "hi"), false},
+		{"any of the froms", "from:alice from:bob", post("bob", midnight, "hi"), true},
+		{"in the room", "in:general", post("bob", midnight, "hi"), true},
+		{"in another room", "in:dev", post("bob", midnight, "hi"), false},
+		{"after includes midnight", "after:2017-11-01", post("bob", midnight, "hi"), true},
+		{"after excludes the day before", "after:2017-11-01", post("bob", midnight-1, "hi"), false},
+		{"before excludes midnight", "before:2017-11-01", post("bob", midnight, "hi"), false},
+		{"before includes the day before", "before:2017-11-01", post("bob", midnight-1, "hi"), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := ParseQuery(test.query, time.UTC)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := q.Matches(test.post); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestQueryHighlight(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		message string
+		want    []SearchPart
+	}{
+		{"word", "lunch", "Lunch at noon", []SearchPart{{"Lunch", true}, {" at noon", false}}},
+		{"whole words only", "lunch", "lunchtime lunch", []SearchPart{{"lunchtime ", false}, {"lunch", true}}},
+		{"phrase across spaces", `"see you"`, "OK, see   you!", []SearchPart{{"OK, ", false}, {"see   you", true}, {"!", false}}},
+		{"word and phrase", `noon "see you"`, "See you at noon", []SearchPart{{"See you", true}, {" at ", false}, {"noon", true}}},
+		{"nothing matched", "pizza", "Lunch at noon", []SearchPart{{"Lunch at noon", false}}},
+		{"operators aren't highlighted", "from:bob", "bob", []SearchPart{{"bob", false}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := ParseQuery(test.query, time.UTC)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := q.Highlight(test.message); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
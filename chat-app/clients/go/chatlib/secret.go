@@ -0,0 +1,61 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Passwords:
+
+  Passwords are []byte, not string, so we can zero them when we're done,
+  and AppendSecret adds a password to a request payload
+  without making a string out of it.
+*/
+
+import (
+	"fmt"
+)
+
+func ZeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Add "name": "secret" to the JSON object in payload,
+// without copying secret into a string (which we could not zero).
+// Zero the result once it's sent.
+func AppendSecret(payload []byte, name string, secret []byte) []byte {
+	// Big enough that append never has to copy (and leave behind) the secret
+	result := make([]byte, 0, len(payload)+len(name)+6*len(secret)+8)
+
+	result = append(result, payload[:len(payload)-1]...)
+
+	if len(payload) > 2 {
+		result = append(result, ',')
+	}
+
+	result = append(result, '"')
+	result = append(result, name...)
+	result = append(result, '"', ':', '"')
+
+	for _, c := range secret {
+		switch {
+		case c == '"' || c == '\\':
+			result = append(result, '\\', c)
+		case c < 0x20:
+			result = append(result, fmt.Sprintf(`\u%04x`, c)...)
+		default:
+			result = append(result, c)
+		}
+	}
+
+	result = append(result, '"', '}')
+
+	return result
+}
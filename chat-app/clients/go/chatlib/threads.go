@@ -0,0 +1,143 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Threads:
+
+  A reply is a post with a Parent: the Alias and Timestamp of the post it answers.
+  GroupThreads puts each reply under its parent, so the clients can show:
+
+    bob@4:45 PM <1509555845> (2 replies):
+    Where is the meeting today?
+        alice@4:46 PM <1509555905>:
+        Room 12
+            bob@4:47 PM <1509555965>:
+            Thanks
+
+  GetPosts only returns the latest posts, so a reply's parent can be missing.
+  Such a reply starts its own thread, marked as an Orphan.
+*/
+
+import (
+	"sort"
+	"strconv"
+)
+
+// A post and the replies to it
+type Thread struct {
+	Post Post
+	// Oldest first
+	Replies []*Thread
+	// True if the post is a reply, but we don't have its parent
+	Orphan bool
+}
+
+// A post in a thread, and how many replies deep it is
+type ThreadPost struct {
+	Post  Post
+	Depth int
+	// How many replies it has, all the way down
+	Replies int
+}
+
+// Count the replies to the thread's post, and to those replies, and so on
+func (t *Thread) Count() int {
+	count := len(t.Replies)
+
+	for _, reply := range t.Replies {
+		count += reply.Count()
+	}
+
+	return count
+}
+
+// Get the thread's posts, each followed by its replies, oldest first.
+// If collapsed returns true for a post, its replies are left out.
+func (t *Thread) Flatten(collapsed func(Post) bool) []ThreadPost {
+	var posts []ThreadPost
+
+	var walk func(thread *Thread, depth int)
+
+	walk = func(thread *Thread, depth int) {
+		posts = append(posts, ThreadPost{Post: thread.Post, Depth: depth, Replies: thread.Count()})
+
+		if collapsed != nil && collapsed(thread.Post) {
+			return
+		}
+
+		for _, reply := range thread.Replies {
+			walk(reply, depth+1)
+		}
+	}
+
+	walk(t, 0)
+
+	return posts
+}
+
+func postTime(p Post) int64 {
+	seconds, _ := strconv.ParseInt(p.Timestamp, 10, 64)
+	return seconds
+}
+
+// Group posts into threads.
+// The threads are in the same order as the posts that start them,
+// such as newest first from GetPosts; replies are oldest first.
+func GroupThreads(posts []Post) []*Thread {
+	threads := make(map[PostKey]*Thread, len(posts))
+
+	for _, p := range posts {
+		threads[p.Key()] = &Thread{Post: p}
+	}
+
+	var roots []*Thread
+
+	for _, p := range posts {
+		thread := threads[p.Key()]
+		parent, ok := threads[p.Parent]
+
+		if !p.IsReply() || !ok || p.Parent == p.Key() {
+			thread.Orphan = p.IsReply()
+			roots = append(roots, thread)
+			continue
+		}
+
+		parent.Replies = append(parent.Replies, thread)
+	}
+
+	for _, thread := range threads {
+		sort.SliceStable(thread.Replies, func(i, j int) bool {
+			return postTime(thread.Replies[i].Post) < postTime(thread.Replies[j].Post)
+		})
+	}
+
+	// Replies that only lead back to each other never reach a root;
+	// show them on their own rather than lose them
+	reached := make(map[PostKey]bool, len(posts))
+
+	for _, root := range roots {
+		for _, p := range root.Flatten(nil) {
+			reached[p.Post.Key()] = true
+		}
+	}
+
+	for _, p := range posts {
+		if !reached[p.Key()] {
+			thread := threads[p.Key()]
+			thread.Replies = nil
+			thread.Orphan = true
+			roots = append(roots, thread)
+			reached[p.Key()] = true
+		}
+	}
+
+	return roots
+}
@@ -0,0 +1,116 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A post by alias at timestamp, replying to parent if it isn't empty, as "alias@timestamp"
+func threadPost(alias string, timestamp string, parent string) Post {
+	p := Post{Alias: alias, Timestamp: timestamp, Message: alias + "@" + timestamp}
+
+	if at := strings.Index(parent, "@"); at >= 0 {
+		p.Parent = PostKey{Alias: parent[:at], Timestamp: parent[at+1:]}
+	}
+
+	return p
+}
+
+// Describe each post of a flattened thread as "message/depth/replies"
+func describeThread(posts []ThreadPost) []string {
+	var described []string
+
+	for _, p := range posts {
+		described = append(described, p.Post.Message+"/"+strconv.Itoa(p.Depth)+"/"+strconv.Itoa(p.Replies))
+	}
+
+	return described
+}
+
+func TestGroupThreads(t *testing.T) {
+	// Newest first, as GetPosts returns them
+	posts := []Post{
+		threadPost("zoe", "150", "zoe@150"),
+		threadPost("yan", "141", "xia@140"),
+		threadPost("xia", "140", "yan@141"),
+		threadPost("dave", "130", "eve@50"),
+		threadPost("carol", "120", ""),
+		threadPost("bob", "110", "alice@105"),
+		threadPost("carol", "107", "bob@100"),
+		threadPost("alice", "105", "bob@100"),
+		threadPost("bob", "100", ""),
+	}
+
+	threads := GroupThreads(posts)
+
+	// Threads in the order of the posts that start them; replies in a loop go last
+	tests := []struct {
+		root   string
+		orphan bool
+		// The thread, flattened
+		want []string
+	}{
+		{"zoe@150", true, []string{"zoe@150/0/0"}},
+		{"dave@130", true, []string{"dave@130/0/0"}},
+		{"carol@120", false, []string{"carol@120/0/0"}},
+		{"bob@100", false, []string{"bob@100/0/3", "alice@105/1/1", "bob@110/2/0", "carol@107/1/0"}},
+		{"yan@141", true, []string{"yan@141/0/0"}},
+		{"xia@140", true, []string{"xia@140/0/0"}},
+	}
+
+	if len(threads) != len(tests) {
+		t.Fatalf("got %d threads, want %d", len(threads), len(tests))
+	}
+
+	for i, test := range tests {
+		thread := threads[i]
+
+		if thread.Post.Message != test.root || thread.Orphan != test.orphan {
+			t.Errorf("thread %d: got %s, orphan %v; want %s, orphan %v", i+1, thread.Post.Message, thread.Orphan, test.root, test.orphan)
+			continue
+		}
+
+		if got := describeThread(thread.Flatten(nil)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("thread %s: got %v, want %v", test.root, got, test.want)
+		}
+	}
+}
+
+func TestThreadFlattenCollapsed(t *testing.T) {
+	threads := GroupThreads([]Post{
+		threadPost("bob", "110", "alice@105"),
+		threadPost("alice", "105", "bob@100"),
+		threadPost("bob", "100", ""),
+	})
+
+	tests := []struct {
+		name      string
+		collapsed string
+		want      []string
+	}{
+		{"nothing collapsed", "", []string{"bob@100/0/2", "alice@105/1/1", "bob@110/2/0"}},
+		{"root collapsed", "bob@100", []string{"bob@100/0/2"}},
+		{"reply collapsed, still counted", "alice@105", []string{"bob@100/0/2", "alice@105/1/1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := describeThread(threads[0].Flatten(func(p Post) bool { return p.Message == test.collapsed }))
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+// Package chatlib contains the code that the command-line and GUI chat app
+// clients share.
+package chatlib
+
+/*
+  Verifying Amazon Cognito tokens:
+
+  When a user signs in, Amazon Cognito returns an access token and an ID token.
+  Both are JSON Web Tokens (JWTs) signed with one of the keys
+  in the user pool's JSON Web Key Set (JWKS), at
+  https://cognito-idp.REGION.amazonaws.com/USER_POOL_ID/.well-known/jwks.json
+  (the UserPoolIDLongURL output of the user pool template).
+
+  A Verifier checks a token without calling AWS:
+  the signature, that it hasn't expired, that our user pool issued it,
+  that it was issued to our app client, and that it's the kind of token we expect.
+
+  Amazon Cognito can rotate the keys in the key set. A cached key set older than
+  KeySetMaxAge is downloaded again, and a token signed with a key we don't have
+  gets the key set downloaded again, at most once every KeySetRefreshInterval,
+  before the token is rejected.
+*/
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long we use a cached key set before we download it again
+const KeySetMaxAge = 24 * time.Hour
+
+// How often a token signed with a key we don't have can make us download the key set,
+// so tokens with made-up key IDs can't make us download it for every request
+const KeySetRefreshInterval = time.Minute
+
+var (
+	ErrTokenMalformed = errors.New("Token is not a valid JWT")
+	ErrTokenSignature = errors.New("Token signature is not valid")
+	ErrTokenExpired   = errors.New("Token has expired")
+	ErrTokenIssuer    = errors.New("Token was not issued by our user pool")
+	ErrTokenClient    = errors.New("Token was not issued to our app client")
+	ErrTokenUse       = errors.New("Token is the wrong kind of token")
+	ErrUnknownKey     = errors.New("Token was signed with a key that is not in the key set")
+)
+
+// The claims we use from access and ID tokens
+type Claims struct {
+	Subject  string `json:"sub"`
+	Issuer   string `json:"iss"`
+	TokenUse string `json:"token_use"`
+	// Access tokens only
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	// ID tokens only
+	Audience        string `json:"aud"`
+	CognitoUsername string `json:"cognito:username"`
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+
+	Expires  int64 `json:"exp"`
+	IssuedAt int64 `json:"iat"`
+	AuthTime int64 `json:"auth_time"`
+}
+
+// The user name from either kind of token
+func (c Claims) UserName() string {
+	if c.Username != "" {
+		return c.Username
+	}
+
+	return c.CognitoUsername
+}
+
+func (c Claims) ExpiresAt() time.Time {
+	return time.Unix(c.Expires, 0)
+}
+
+func (c Claims) IssuedAtTime() time.Time {
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// The public keys that tokens can be signed with, by key ID (kid)
+type KeySet struct {
+	keys map[string]*rsa.PublicKey
+}
+
+// Create a key set from keys you already have, such as ones you generated to sign test tokens
+func NewKeySet(keys map[string]*rsa.PublicKey) *KeySet {
+	set := &KeySet{keys: make(map[string]*rsa.PublicKey)}
+
+	for kid, key := range keys {
+		set.keys[kid] = key
+	}
+
+	return set
+}
+
+func (s *KeySet) Key(kid string) (*rsa.PublicKey, bool) {
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// Parse a JWKS document, such as the one at a user pool's jwks.json URL
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var jwks jsonWebKeySet
+
+	err := json.Unmarshal(data, &jwks)
+
+	if err != nil {
+		return nil, errors.New("Error parsing key set: " + err.Error())
+	}
+
+	set := &KeySet{keys: make(map[string]*rsa.PublicKey)}
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+
+		if err != nil {
+			return nil, errors.New("Error parsing modulus of key " + jwk.Kid + ": " + err.Error())
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+
+		if err != nil {
+			return nil, errors.New("Error parsing exponent of key " + jwk.Kid + ": " + err.Error())
+		}
+
+		set.keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(set.keys) == 0 {
+		return nil, errors.New("Key set has no RSA keys")
+	}
+
+	return set, nil
+}
+
+// Read a key set from a JWKS file
+func LoadKeySet(filename string) (*KeySet, error) {
+	data, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, errors.New("Error reading key set: " + err.Error())
+	}
+
+	return ParseKeySet(data)
+}
+
+// The URL of a user pool's key set
+func KeySetURL(region string, userPoolID string) string {
+	return IssuerURL(region, userPoolID) + "/.well-known/jwks.json"
+}
+
+// The iss claim of tokens that a user pool issues
+func IssuerURL(region string, userPoolID string) string {
+	return "https://cognito-idp." + region + ".amazonaws.com/" + userPoolID
+}
+
+// Get a user pool's key set from cacheFile, if it exists and is newer than KeySetMaxAge,
+// otherwise download it from the user pool and save it in cacheFile.
+// If the download fails, an older cacheFile is still used.
+// If cacheFile is empty, always download the key set.
+func FetchKeySet(region string, userPoolID string, cacheFile string) (*KeySet, error) {
+	if cacheFile == "" {
+		return DownloadKeySet(region, userPoolID, cacheFile)
+	}
+
+	info, err := os.Stat(cacheFile)
+
+	if err != nil {
+		return DownloadKeySet(region, userPoolID, cacheFile)
+	}
+
+	if time.Since(info.ModTime()) < KeySetMaxAge {
+		return LoadKeySet(cacheFile)
+	}
+
+	set, err := DownloadKeySet(region, userPoolID, cacheFile)
+
+	if set == nil {
+		cached, cacheErr := LoadKeySet(cacheFile)
+
+		if cacheErr != nil {
+			return nil, err
+		}
+
+		return cached, errors.New("Using the cached key set; " + err.Error())
+	}
+
+	return set, err
+}
+
+// Download a user pool's key set and, unless cacheFile is empty, save it in cacheFile
+func DownloadKeySet(region string, userPoolID string, cacheFile string) (*KeySet, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	resp, err := client.Get(KeySetURL(region, userPoolID))
+
+	if err != nil {
+		return nil, errors.New("Error getting key set: " + err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Error getting key set: " + resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, errors.New("Error reading key set: " + err.Error())
+	}
+
+	set, err := ParseKeySet(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheFile != "" {
+		os.MkdirAll(filepath.Dir(cacheFile), 0700)
+
+		err = ioutil.WriteFile(cacheFile, data, 0600)
+
+		if err != nil {
+			return set, errors.New("Got key set, but could not save it: " + err.Error())
+		}
+	}
+
+	return set, nil
+}
+
+// Checks tokens issued by one user pool to one app client
+type Verifier struct {
+	Keys     *KeySet
+	Issuer   string
+	ClientID string
+	// Returns the current time; replace it to test expiration
+	Now func() time.Time
+	// Gets the key set again, when a token is signed with a key that isn't in Keys;
+	// nil if the key set can't change
+	Refresh func() (*KeySet, error)
+
+	// Guards Keys and refreshed while Refresh runs
+	mutex     sync.Mutex
+	refreshed time.Time
+}
+
+func NewVerifier(keys *KeySet, region string, userPoolID string, clientID string) *Verifier {
+	return &Verifier{
+		Keys:     keys,
+		Issuer:   IssuerURL(region, userPoolID),
+		ClientID: clientID,
+		Now:      time.Now,
+	}
+}
+
+// Create a verifier for a user pool, getting its key set with FetchKeySet
+func LoadVerifier(region string, userPoolID string, clientID string, cacheFile string) (*Verifier, error) {
+	keys, err := FetchKeySet(region, userPoolID, cacheFile)
+
+	if keys == nil {
+		return nil, err
+	}
+
+	v := NewVerifier(keys, region, userPoolID, clientID)
+
+	v.Refresh = func() (*KeySet, error) {
+		return DownloadKeySet(region, userPoolID, cacheFile)
+	}
+
+	// err can still tell us the key set wasn't cached
+	return v, err
+}
+
+// Get the key a token was signed with,
+// getting the key set again with Refresh if we don't have it
+func (v *Verifier) key(kid string) (*rsa.PublicKey, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	key, ok := v.Keys.Key(kid)
+
+	if ok || v.Refresh == nil || time.Since(v.refreshed) < KeySetRefreshInterval {
+		return key, ok
+	}
+
+	v.refreshed = time.Now()
+
+	// If we can't get it, such as with no connection, we only have the keys we had
+	keys, _ := v.Refresh()
+
+	if keys == nil {
+		return nil, false
+	}
+
+	v.Keys = keys
+
+	return v.Keys.Key(kid)
+}
+
+// Check an access token and get its claims
+func (v *Verifier) VerifyAccessToken(token string) (Claims, error) {
+	return v.verify(token, "access")
+}
+
+// Check an ID token and get its claims
+func (v *Verifier) VerifyIDToken(token string) (Claims, error) {
+	return v.verify(token, "id")
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Split a token into its header, claims, and signature,
+// and decode the header and claims
+func parseToken(token string) (tokenHeader, Claims, []string, error) {
+	var header tokenHeader
+	var claims Claims
+
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return header, claims, parts, ErrTokenMalformed
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+	if err != nil || json.Unmarshal(data, &header) != nil {
+		return header, claims, parts, ErrTokenMalformed
+	}
+
+	data, err = base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil || json.Unmarshal(data, &claims) != nil {
+		return header, claims, parts, ErrTokenMalformed
+	}
+
+	return header, claims, parts, nil
+}
+
+// Get the claims of a token WITHOUT checking it.
+// Only use them for display, never to decide what the user can do.
+func DecodeClaims(token string) (Claims, error) {
+	_, claims, _, err := parseToken(token)
+	return claims, err
+}
+
+func (v *Verifier) verify(token string, use string) (Claims, error) {
+	header, claims, parts, err := parseToken(token)
+
+	if err != nil {
+		return claims, err
+	}
+
+	if header.Alg != "RS256" {
+		return claims, ErrTokenSignature
+	}
+
+	key, ok := v.key(header.Kid)
+
+	if !ok {
+		return claims, ErrUnknownKey
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return claims, ErrTokenMalformed
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) != nil {
+		return claims, ErrTokenSignature
+	}
+
+	now := time.Now
+
+	if v.Now != nil {
+		now = v.Now
+	}
+
+	if !now().Before(claims.ExpiresAt()) {
+		return claims, ErrTokenExpired
+	}
+
+	if claims.Issuer != v.Issuer {
+		return claims, ErrTokenIssuer
+	}
+
+	if claims.TokenUse != use {
+		return claims, ErrTokenUse
+	}
+
+	// Access tokens name the client in client_id, ID tokens in aud
+	clientID := claims.ClientID
+
+	if use == "id" {
+		clientID = claims.Audience
+	}
+
+	if clientID != v.ClientID {
+		return claims, ErrTokenClient
+	}
+
+	return claims, nil
+}
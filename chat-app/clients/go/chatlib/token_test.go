@@ -0,0 +1,180 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const (
+	testRegion     = "us-west-2"
+	testUserPoolID = "us-west-2_test"
+	testClientID   = "testclient"
+)
+
+func testKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// Sign claims with key, as key ID kid
+func testToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	body, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	hash := sha256.Sum256([]byte(signed))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifier(t *testing.T) {
+	key := testKey(t)
+	otherKey := testKey(t)
+	now := time.Unix(1500000000, 0)
+
+	// The claims of a good token, with changes
+	claims := func(use string, changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":       "1234",
+			"iss":       IssuerURL(testRegion, testUserPoolID),
+			"token_use": use,
+			"exp":       now.Add(time.Hour).Unix(),
+			"iat":       now.Unix(),
+		}
+
+		if use == "access" {
+			c["client_id"] = testClientID
+			c["username"] = "bob"
+		} else {
+			c["aud"] = testClientID
+			c["cognito:username"] = "bob"
+		}
+
+		for k, v := range changes {
+			c[k] = v
+		}
+
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		use   string
+		want  error
+	}{
+		{"access token", testToken(t, key, "k1", claims("access", nil)), "access", nil},
+		{"ID token", testToken(t, key, "k1", claims("id", nil)), "id", nil},
+		{"expired", testToken(t, key, "k1", claims("access", map[string]interface{}{"exp": now.Unix()})), "access", ErrTokenExpired},
+		{"wrong issuer", testToken(t, key, "k1", claims("access", map[string]interface{}{"iss": IssuerURL(testRegion, "us-west-2_other")})), "access", ErrTokenIssuer},
+		{"wrong client", testToken(t, key, "k1", claims("access", map[string]interface{}{"client_id": "other"})), "access", ErrTokenClient},
+		{"wrong audience", testToken(t, key, "k1", claims("id", map[string]interface{}{"aud": "other"})), "id", ErrTokenClient},
+		{"ID token as access token", testToken(t, key, "k1", claims("id", nil)), "access", ErrTokenUse},
+		{"unknown kid", testToken(t, key, "k2", claims("access", nil)), "access", ErrUnknownKey},
+		{"bad signature", testToken(t, otherKey, "k1", claims("access", nil)), "access", ErrTokenSignature},
+		{"not a JWT", "not.a.jwt", "access", ErrTokenMalformed},
+		{"two parts", "abc.def", "access", ErrTokenMalformed},
+	}
+
+	v := NewVerifier(NewKeySet(map[string]*rsa.PublicKey{"k1": &key.PublicKey}), testRegion, testUserPoolID, testClientID)
+	v.Now = func() time.Time { return now }
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var c Claims
+			var err error
+
+			if test.use == "id" {
+				c, err = v.VerifyIDToken(test.token)
+			} else {
+				c, err = v.VerifyAccessToken(test.token)
+			}
+
+			if err != test.want {
+				t.Fatalf("got error %v, want %v", err, test.want)
+			}
+
+			if err == nil && c.UserName() != "bob" {
+				t.Errorf("got user name %q, want bob", c.UserName())
+			}
+		})
+	}
+}
+
+func TestVerifierRefresh(t *testing.T) {
+	oldKey := testKey(t)
+	newKey := testKey(t)
+	now := time.Now()
+
+	token := testToken(t, newKey, "new", map[string]interface{}{
+		"sub":       "1234",
+		"iss":       IssuerURL(testRegion, testUserPoolID),
+		"token_use": "access",
+		"client_id": testClientID,
+		"username":  "bob",
+		"exp":       now.Add(time.Hour).Unix(),
+	})
+
+	tests := []struct {
+		name string
+		// The key set Refresh gets
+		refreshed *KeySet
+		want      error
+		refreshes int
+	}{
+		{"rotated key", NewKeySet(map[string]*rsa.PublicKey{"old": &oldKey.PublicKey, "new": &newKey.PublicKey}), nil, 1},
+		{"still unknown", NewKeySet(map[string]*rsa.PublicKey{"old": &oldKey.PublicKey}), ErrUnknownKey, 1},
+		{"can't download", nil, ErrUnknownKey, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := NewVerifier(NewKeySet(map[string]*rsa.PublicKey{"old": &oldKey.PublicKey}), testRegion, testUserPoolID, testClientID)
+			refreshes := 0
+
+			v.Refresh = func() (*KeySet, error) {
+				refreshes++
+				return test.refreshed, nil
+			}
+
+			// The second time, it's either in the key set or too soon to download it again
+			for i := 0; i < 2; i++ {
+				_, err := v.VerifyAccessToken(token)
+
+				if err != test.want {
+					t.Fatalf("try %d: got error %v, want %v", i+1, err, test.want)
+				}
+			}
+
+			if refreshes != test.refreshes {
+				t.Errorf("got %d refreshes, want %d", refreshes, test.refreshes)
+			}
+		})
+	}
+}
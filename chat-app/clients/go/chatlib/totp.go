@@ -0,0 +1,140 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  Time-based one-time passwords (TOTP):
+
+  An authenticator app and Amazon Cognito share a secret,
+  and each turns the secret and the current 30-second period
+  into the same 6-digit code (RFC 6238, with HMAC-SHA1, like Cognito).
+  The memory backend uses these to act like a user pool with MFA.
+
+  To add an app, the user scans a QR code of TOTPURI,
+  or types in the secret, then enters the app's first code.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+)
+
+// What authenticator apps call the app, unless the configuration says otherwise
+const DefaultTOTPIssuer = "Chat App"
+
+// Secrets are base32, without padding, as authenticator apps expect
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+
+	if err != nil {
+		return nil, errors.New("TOTP secret is not base32: " + err.Error())
+	}
+
+	return key, nil
+}
+
+// Create a random secret, 160 bits as RFC 4226 recommends
+func newTOTPSecret() string {
+	key := make([]byte, 20)
+
+	_, err := rand.Read(key)
+
+	if err != nil {
+		panic("Error getting random bytes: " + err.Error())
+	}
+
+	return totpEncoding.EncodeToString(key)
+}
+
+// Get the otpauth:// URI for an authenticator app,
+// which shows the account as issuer (account)
+func TOTPURI(issuer string, account string, secret string) string {
+	// url.Values would escape spaces as +, which some apps show as is
+	return "otpauth://totp/" + url.PathEscape(issuer) + ":" + url.PathEscape(account) +
+		"?secret=" + url.QueryEscape(secret) +
+		"&issuer=" + url.PathEscape(issuer) +
+		"&algorithm=SHA1&digits=" + strconv.Itoa(TOTPDigits) +
+		"&period=" + strconv.Itoa(int(TOTPPeriod/time.Second))
+}
+
+// Split a secret into groups of 4 characters, to make it easier to type
+func FormatTOTPSecret(secret string) string {
+	var groups []string
+
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+
+	return strings.Join(append(groups, secret), " ")
+}
+
+// Get the code for secret at time t
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(TOTPPeriod/time.Second)))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, from RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	code := make([]byte, TOTPDigits)
+
+	for i := TOTPDigits - 1; i >= 0; i-- {
+		code[i] = byte('0' + value%10)
+		value /= 10
+	}
+
+	return string(code), nil
+}
+
+// Check a code for secret at time t,
+// allowing one period either way for clocks that don't agree
+func VerifyTOTP(secret string, code string, t time.Time) bool {
+	for _, skew := range []time.Duration{0, -TOTPPeriod, TOTPPeriod} {
+		expected, err := TOTPCode(secret, t.Add(skew))
+
+		if err != nil {
+			return false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,228 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+/*
+  User names and email addresses:
+
+  What a user types can look right and still not be what they meant:
+  leading or trailing spaces, an é typed as e plus a combining accent,
+  or Bob when the user pool doesn't care about case.
+  So the clients normalize both values before using them:
+
+  - Trim the spaces around them.
+  - Convert them to Unicode NFC, so the same text is always the same bytes.
+  - Fold the case of user names if the user pool is case insensitive,
+    and the case of the domain of email addresses, which is never case sensitive.
+
+  Then, when a user registers, they check both values
+  and report what's wrong with each field, rather than letting
+  Amazon Cognito reject them with one generic message.
+*/
+
+import (
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The names of the fields in a ValidationError,
+// the same as the fields of the GUI's forms
+const (
+	UserNameField = "username"
+	EmailField    = "email"
+	PasswordField = "password"
+)
+
+// The user names the user pool accepts
+type UserNamePolicy struct {
+	MinimumLength int
+	MaximumLength int
+	// True if the user pool treats Bob and bob as the same user
+	CaseInsensitive bool
+}
+
+// The user pool in ../../setup/cognito/ChatRoomPool.yaml
+// allows up to 128 characters, and is case sensitive
+var DefaultUserNamePolicy = UserNamePolicy{MinimumLength: 1, MaximumLength: 128}
+
+// The longest email address we accept
+const MaximumEmailLength = 254
+
+// Fill in the lengths that aren't set from DefaultUserNamePolicy
+func (p UserNamePolicy) OrDefault() UserNamePolicy {
+	if p.MinimumLength == 0 {
+		p.MinimumLength = DefaultUserNamePolicy.MinimumLength
+	}
+
+	if p.MaximumLength == 0 {
+		p.MaximumLength = DefaultUserNamePolicy.MaximumLength
+	}
+
+	return p
+}
+
+// Trim a user name, convert it to NFC, and fold its case if the pool is case insensitive
+func (p UserNamePolicy) Normalize(userName string) string {
+	userName = norm.NFC.String(strings.TrimSpace(userName))
+
+	if p.CaseInsensitive {
+		userName = cases.Fold().String(userName)
+	}
+
+	return userName
+}
+
+// Get every problem with a normalized user name, or nil if there are none.
+// Like Amazon Cognito, we allow letters, marks, numbers, punctuation, and symbols,
+// but not spaces or control characters.
+func (p UserNamePolicy) Check(userName string) []string {
+	var problems []string
+
+	length := utf8.RuneCountInString(userName)
+
+	if length == 0 {
+		return []string{"Enter a user name"}
+	}
+
+	if length < p.MinimumLength {
+		problems = append(problems, "User name must have at least "+strconv.Itoa(p.MinimumLength)+" characters")
+	}
+
+	if length > p.MaximumLength {
+		problems = append(problems, "User name must have at most "+strconv.Itoa(p.MaximumLength)+" characters")
+	}
+
+	if !utf8.ValidString(userName) {
+		problems = append(problems, "User name is not valid UTF-8")
+		return problems
+	}
+
+	for _, r := range userName {
+		if unicode.IsSpace(r) {
+			problems = append(problems, "User name must not have spaces")
+			break
+		}
+
+		if !unicode.In(r, unicode.L, unicode.M, unicode.N, unicode.P, unicode.S) {
+			problems = append(problems, "User name must not have control or other invisible characters")
+			break
+		}
+	}
+
+	return problems
+}
+
+// Trim an email address, convert it to NFC, and fold the case of its domain.
+// The part before the @ can be case sensitive, so we leave it alone.
+func NormalizeEmail(email string) string {
+	email = norm.NFC.String(strings.TrimSpace(email))
+
+	at := strings.LastIndex(email, "@")
+
+	if at < 0 {
+		return email
+	}
+
+	return email[:at+1] + cases.Fold().String(email[at+1:])
+}
+
+// Get every problem with a normalized email address, or nil if there are none
+func CheckEmail(email string) []string {
+	if email == "" {
+		return []string{"Enter an email address"}
+	}
+
+	var problems []string
+
+	if utf8.RuneCountInString(email) > MaximumEmailLength {
+		problems = append(problems, "Email address must have at most "+strconv.Itoa(MaximumEmailLength)+" characters")
+	}
+
+	// ParseAddress also accepts "Bob <bob@example.com>", which isn't an address
+	address, err := mail.ParseAddress(email)
+
+	if err != nil || address.Address != email || address.Name != "" {
+		return append(problems, "Email address must look like name@example.com")
+	}
+
+	at := strings.LastIndex(email, "@")
+	local := email[:at]
+	domain := email[at+1:]
+
+	if len(local) > 64 {
+		problems = append(problems, "The part of the email address before the @ must have at most 64 characters")
+	}
+
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		problems = append(problems, "The part of the email address after the @ must be a domain, such as example.com")
+	}
+
+	return problems
+}
+
+// The problems with each field of a form
+type ValidationError struct {
+	Fields map[string][]string
+}
+
+func (e *ValidationError) Error() string {
+	var messages []string
+
+	for _, field := range []string{UserNameField, EmailField, PasswordField} {
+		messages = append(messages, e.Fields[field]...)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Add the problems with field, if there are any
+func (e *ValidationError) Add(field string, problems []string) {
+	if len(problems) == 0 {
+		return
+	}
+
+	if e.Fields == nil {
+		e.Fields = make(map[string][]string)
+	}
+
+	e.Fields[field] = append(e.Fields[field], problems...)
+}
+
+// Get e, or nil if no field has a problem
+func (e *ValidationError) OrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// Normalize the user name and email address someone registers with,
+// and check them and their password.
+// Returns the normalized values and, if any field has a problem,
+// a *ValidationError with every problem of every field.
+func ValidateRegistration(names UserNamePolicy, passwords PasswordPolicy, userName string, email string, password []byte) (string, string, error) {
+	var validation ValidationError
+
+	userName = names.Normalize(userName)
+	email = NormalizeEmail(email)
+
+	validation.Add(UserNameField, names.Check(userName))
+	validation.Add(EmailField, CheckEmail(email))
+	validation.Add(PasswordField, passwords.Check(password))
+
+	return userName, email, validation.OrNil()
+}
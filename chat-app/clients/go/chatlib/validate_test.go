@@ -0,0 +1,142 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package chatlib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUserNameNormalize(t *testing.T) {
+	sensitive := DefaultUserNamePolicy
+	insensitive := UserNamePolicy{CaseInsensitive: true}
+
+	tests := []struct {
+		name     string
+		policy   UserNamePolicy
+		userName string
+		want     string
+	}{
+		{"trims spaces", sensitive, "  bob\t", "bob"},
+		{"keeps case", sensitive, "Bob", "Bob"},
+		{"folds case", insensitive, "Bob", "bob"},
+		{"combining accent to NFC", sensitive, "e\u0301mile", "\u00e9mile"},
+		{"NFC, then folds case", insensitive, "E\u0301MILE", "\u00e9mile"},
+		{"full case folding", insensitive, "Straße", "strasse"},
+		{"already NFC", sensitive, "\u00e9mile", "\u00e9mile"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.Normalize(test.userName); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUserNamePolicyCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   UserNamePolicy
+		userName string
+		want     []string
+	}{
+		{"good", DefaultUserNamePolicy, "bob", nil},
+		{"letters with accents", DefaultUserNamePolicy, "émile", nil},
+		{"punctuation and symbols", DefaultUserNamePolicy, "bob.smith+chat", nil},
+		{"empty", DefaultUserNamePolicy, "", []string{"Enter a user name"}},
+		{"space", DefaultUserNamePolicy, "bob smith", []string{"User name must not have spaces"}},
+		{"control character", DefaultUserNamePolicy, "bob\x01", []string{"User name must not have control or other invisible characters"}},
+		{"zero-width space", DefaultUserNamePolicy, "bob\u200b", []string{"User name must not have control or other invisible characters"}},
+		{"too short", UserNamePolicy{MinimumLength: 3, MaximumLength: 10}, "bo", []string{"User name must have at least 3 characters"}},
+		{"too long", DefaultUserNamePolicy, strings.Repeat("a", 129), []string{"User name must have at most 128 characters"}},
+		{"length counts characters, not bytes", DefaultUserNamePolicy, strings.Repeat("é", 128), nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.Check(test.userName); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{" bob@example.com ", "bob@example.com"},
+		{"Bob@Example.COM", "Bob@example.com"},
+		{"jose\u0301@example.com", "jos\u00e9@example.com"},
+		{"bob@bÜro.example", "bob@büro.example"},
+		{"bob", "bob"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.email, func(t *testing.T) {
+			if got := NormalizeEmail(test.email); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckEmail(t *testing.T) {
+	lookLikeAddress := []string{"Email address must look like name@example.com"}
+
+	tests := []struct {
+		name  string
+		email string
+		want  []string
+	}{
+		{"good", "bob@example.com", nil},
+		{"plus and dots", "bob.smith+chat@mail.example.com", nil},
+		{"empty", "", []string{"Enter an email address"}},
+		{"no @", "bob", lookLikeAddress},
+		{"with a name", "Bob <bob@example.com>", lookLikeAddress},
+		{"two @", "bob@@example.com", lookLikeAddress},
+		{"no dot in domain", "bob@localhost", []string{"The part of the email address after the @ must be a domain, such as example.com"}},
+		{"long local part", strings.Repeat("b", 65) + "@example.com", []string{"The part of the email address before the @ must have at most 64 characters"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := CheckEmail(test.email); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateRegistration(t *testing.T) {
+	userName, email, err := ValidateRegistration(UserNamePolicy{CaseInsensitive: true}.OrDefault(), DefaultPasswordPolicy, " Bob ", "Bob@EXAMPLE.com", []byte("abc123"))
+
+	if err != nil || userName != "bob" || email != "Bob@example.com" {
+		t.Fatalf("got %q, %q, %v; want bob, Bob@example.com, no error", userName, email, err)
+	}
+
+	_, _, err = ValidateRegistration(DefaultUserNamePolicy, DefaultPasswordPolicy, "bob smith", "bob", []byte("abc"))
+
+	validation, ok := err.(*ValidationError)
+
+	if !ok {
+		t.Fatalf("got error %v, want a *ValidationError", err)
+	}
+
+	for _, field := range []string{UserNameField, EmailField, PasswordField} {
+		if len(validation.Fields[field]) == 0 {
+			t.Errorf("got no problems with %s", field)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Account self-service:
+
+  Once they log in, home.tmpl lets users:
+
+  - Change their password (/password, PasswordServer),
+    by entering their current password and a new one.
+  - Change their email address (/email, EmailServer).
+    The first post sends a code to the new address and sets the status
+    to 'Verifying email', so HomeServer shows email.tmpl;
+    the second checks the code. The old address stays until then.
+  - Download every post they wrote as a JSON archive (/export, ExportServer).
+  - Delete their account (/unregister, UnregisterServer).
+    The first post sets the status to 'Deleting account',
+    so HomeServer shows unregister.tmpl; the second needs their password,
+    or the phrase it shows, and can delete their posts too.
+    Either way, chatlib.DeleteAccount does the work, and we show its report.
+*/
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+// The address the user is changing their email to, until they enter the code
+var pendingEmail string
+
+// What deleting their account removed, escaped and ready for the start page
+var deletionMessage string
+
+// Change the logged-in user's password
+func PasswordServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("PasswordServer called with status: " + getStatusValue())
+
+	if status == NOT_LOGGED_IN {
+		StartServer(w, req)
+		return
+	}
+
+	req.ParseForm() // Parses the request body
+
+	oldPassword := []byte(req.PostForm.Get("old_password"))
+	newPassword := []byte(req.PostForm.Get("password"))
+	defer chatlib.ZeroBytes(oldPassword)
+	defer chatlib.ZeroBytes(newPassword)
+
+	err := checkNewPassword(newPassword)
+
+	if err == nil {
+		Debug.Println("Calling ChangePassword")
+
+		err = backend.ChangePassword(token, oldPassword, newPassword)
+
+		if err != nil {
+			setFailureMessage(err.Error())
+		}
+	}
+
+	if err != nil {
+		Debug.Println("Could not change password: " + err.Error())
+		status = PASSWORD_CHANGE_FAILED
+	} else {
+		status = PASSWORD_CHANGED
+	}
+
+	HomeServer(w, req)
+}
+
+// Start changing the logged-in user's email address, or check the code we sent
+func EmailServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("EmailServer called with status: " + getStatusValue())
+
+	switch status {
+	case NOT_LOGGED_IN:
+		StartServer(w, req)
+	case EMAIL_VERIFYING:
+		req.ParseForm() // Parses the request body
+
+		if req.PostForm.Get("cancel") != "" {
+			// The old address is still theirs
+			pendingEmail = ""
+			status = LOGGED_IN
+			HomeServer(w, req)
+			return
+		}
+
+		Debug.Println("Calling FinishEmailChange")
+
+		err := backend.FinishEmailChange(token, strings.TrimSpace(req.PostForm.Get("code")))
+
+		if err != nil {
+			// Still verifying, so they can try again
+			Debug.Println("Could not verify email: " + err.Error())
+			setFailureMessage(err.Error())
+		} else {
+			pendingEmail = ""
+			status = EMAIL_CHANGED
+		}
+
+		HomeServer(w, req)
+	default:
+		req.ParseForm() // Parses the request body
+
+		email := chatlib.NormalizeEmail(req.PostForm.Get("email"))
+
+		var delivery chatlib.CodeDelivery
+		var err error
+
+		if problems := chatlib.CheckEmail(email); len(problems) > 0 {
+			err = errors.New(strings.Join(problems, "; "))
+		} else {
+			Debug.Println("Calling StartEmailChange")
+
+			delivery, err = backend.StartEmailChange(token, email)
+		}
+
+		if err != nil {
+			Debug.Println("Could not change email: " + err.Error())
+			setFailureMessage(err.Error())
+			status = EMAIL_CHANGE_FAILED
+		} else {
+			setCodeMessage("We sent a verification code to", delivery, chatlib.MaskDestination(email))
+			pendingEmail = email
+			status = EMAIL_VERIFYING
+		}
+
+		HomeServer(w, req)
+	}
+}
+
+// Send every post the logged-in user wrote, as a JSON file to save
+func ExportServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("ExportServer called with status: " + getStatusValue())
+
+	if status == NOT_LOGGED_IN {
+		StartServer(w, req)
+		return
+	}
+
+	Debug.Println("Calling ExportPosts")
+
+	posts, err := backend.ExportPosts(token)
+
+	var data []byte
+	var archive chatlib.PostArchive
+
+	if err == nil {
+		archive = chatlib.NewPostArchive(username, posts, time.Now())
+		data, err = archive.JSON()
+	}
+
+	if err != nil {
+		Debug.Println("Could not export posts: " + err.Error())
+		setFailureMessage(err.Error())
+		status = EXPORT_FAILED
+		HomeServer(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archive.FileName()}))
+	w.Write(data)
+}
+
+// Ask the logged-in user to confirm deleting their account, then delete it
+func UnregisterServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("UnregisterServer called with status: " + getStatusValue())
+
+	switch status {
+	case NOT_LOGGED_IN:
+		StartServer(w, req)
+	case DELETING:
+		req.ParseForm() // Parses the request body
+
+		if req.PostForm.Get("cancel") != "" {
+			status = LOGGED_IN
+			HomeServer(w, req)
+			return
+		}
+
+		confirmation := []byte(req.PostForm.Get("confirmation"))
+		defer chatlib.ZeroBytes(confirmation)
+
+		Debug.Println("Calling DeleteAccount")
+
+		report, err := chatlib.DeleteAccount(backend, chatlib.DeletionRequest{
+			UserName:     username,
+			AccessToken:  token,
+			Confirmation: confirmation,
+			DeletePosts:  req.PostForm.Get("delete_posts") != "",
+		})
+
+		if report.AccountDeleted {
+			clearTokens()
+
+			deletionMessage = template.HTMLEscapeString(report.String())
+			status = ACCOUNT_DELETED
+			StartServer(w, req)
+			return
+		}
+
+		Debug.Println("Could not delete account: " + err.Error())
+
+		if err == chatlib.ErrDeletionNotConfirmed || err == chatlib.ErrDeletionNeedsPhrase {
+			// Still deleting, so they can try again
+			setFailureMessage(err.Error())
+		} else {
+			setFailureMessage(err.Error() + ". " + report.String())
+			status = DELETE_FAILED
+		}
+
+		HomeServer(w, req)
+	default:
+		status = DELETING
+		HomeServer(w, req)
+	}
+}
@@ -0,0 +1,170 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Verifying tokens:
+
+  When a user logs in, we check the access and ID tokens Amazon Cognito
+  gives us against the user pool's keys (see chatlib.Verifier),
+  and we check the access token again on every request.
+  Once it expires, or if it isn't valid, we log the user out
+  and tell them their session has expired.
+
+  To do that we need UserPoolID and ClientID in conf.json.
+  We get the keys from JWKSFile if it exists,
+  otherwise from the user pool, and save them in JWKSFile.
+  Without UserPoolID and ClientID we can't check that a token came from
+  the user pool at all, so the lambda backend won't start without them,
+  and nobody can log in. The memory backend checks its own tokens.
+*/
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+// The ID and refresh tokens of the logged-in user
+var idToken string
+var refreshToken string
+
+var verifier *chatlib.Verifier
+var verifierMutex sync.Mutex
+
+var errNoUserPool = errors.New("UserPoolID and ClientID are not set in " + configFile + ", so tokens can't be verified")
+
+// Get the verifier for the configured user pool,
+// loading the user pool's keys the first time
+func getVerifier() (*chatlib.Verifier, error) {
+	verifierMutex.Lock()
+	defer verifierMutex.Unlock()
+
+	if verifier != nil {
+		return verifier, nil
+	}
+
+	// The memory backend signs its own tokens
+	if memory, ok := backend.(*chatlib.MemoryBackend); ok {
+		verifier = memory.Verifier()
+		return verifier, nil
+	}
+
+	c := currentConfiguration()
+
+	if c.UserPoolID == "" || c.ClientID == "" {
+		return nil, errNoUserPool
+	}
+
+	v, err := chatlib.LoadVerifier(c.Region, c.UserPoolID, c.ClientID, c.JWKSFile)
+
+	if v == nil {
+		return nil, errors.New("Could not get the keys of user pool " + c.UserPoolID + ": " + err.Error())
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	verifier = v
+
+	return verifier, nil
+}
+
+// Check an access token and get its claims
+func verifyAccessToken(accessToken string) (chatlib.Claims, error) {
+	v, err := getVerifier()
+
+	if err != nil {
+		return chatlib.Claims{}, err
+	}
+
+	return v.VerifyAccessToken(accessToken)
+}
+
+// Check the tokens from logging in and, if they're good,
+// make them the logged-in user's tokens
+func setTokens(result chatlib.Tokens) error {
+	claims, err := verifyAccessToken(result.AccessToken)
+
+	if err != nil {
+		return errors.New("Access token is not valid: " + err.Error())
+	}
+
+	v, err := getVerifier()
+
+	if err == nil && result.IdToken != "" {
+		_, err = v.VerifyIDToken(result.IdToken)
+
+		if err != nil {
+			return errors.New("ID token is not valid: " + err.Error())
+		}
+	}
+
+	// Posts still going out in the background are for whoever was logged in
+	stopOutbox()
+
+	token = result.AccessToken
+	idToken = result.IdToken
+	refreshToken = result.RefreshToken
+
+	if claims.UserName() != "" {
+		username = claims.UserName()
+	}
+
+	return nil
+}
+
+// If a user is logged in, make sure their access token is still good,
+// otherwise log them out
+func checkSession() {
+	if token == "" {
+		return
+	}
+
+	_, err := verifyAccessToken(token)
+
+	if err == nil {
+		return
+	}
+
+	Info.Println("Logging out user: " + err.Error())
+
+	clearTokens()
+	status = SESSION_EXPIRED
+}
+
+// Forget the logged-in user
+func clearTokens() {
+	stopOutbox()
+
+	token = ""
+	idToken = ""
+	refreshToken = ""
+	username = ""
+	totpSecret = ""
+	pendingEmail = ""
+}
+
+// Make sure we can verify tokens; without a user pool to check them against,
+// we can't start
+func checkVerifier() {
+	_, err := getVerifier()
+
+	if err == errNoUserPool {
+		log.Fatal(err.Error() + "; set them to the UserPoolID and UserPoolClientID outputs of the user pool template")
+	}
+
+	if err != nil {
+		log.Println(err.Error() + "; nobody can log in until we get them")
+	}
+}
@@ -0,0 +1,225 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Direct messages:
+
+  Once they log in, the sidebar in posts.tmpl links to /dm/ (DirectServer),
+  which lists their conversations, with how many messages they haven't read,
+  and lets them message anyone. Each conversation has its own URL, /dm/NAME,
+  which shows its messages and marks them read.
+
+  direct.tmpl takes the place of posts.tmpl and home.tmpl,
+  so the public posts aren't on the same page as private messages.
+  Sending a message posts to /dm (MessageServer), which shows the conversation again.
+*/
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+// A conversation in the list of them
+type ConversationEntry struct {
+	With string
+	URL  string
+	// How many messages to them they haven't read
+	Unread int
+	// True for the conversation we're showing
+	Current bool
+}
+
+// A message in a conversation
+type MessageEntry struct {
+	From    string
+	Date    string
+	Message string
+	// True if the logged-in user sent it
+	Mine bool
+}
+
+type DirectContext struct {
+	CSRFToken     string
+	Conversations []ConversationEntry
+	// Who the conversation we're showing is with; empty for the list alone
+	With     string
+	Messages []MessageEntry
+	// The room to go back to
+	Room string
+}
+
+func conversationURL(with string) string {
+	return "/dm/" + url.PathEscape(with)
+}
+
+// How many direct messages the logged-in user hasn't read, for the sidebar
+func unreadMessages() int {
+	if !backend.Supports("ListConversations") {
+		return 0
+	}
+
+	conversations, err := backend.ListConversations(token)
+
+	if err != nil {
+		Debug.Println("Could not get direct messages: " + err.Error())
+		return 0
+	}
+
+	return chatlib.UnreadCount(conversations)
+}
+
+// Get the messages between the logged-in user and with, oldest first,
+// which marks them read
+func getMessages(with string) ([]MessageEntry, error) {
+	data, err := backend.GetDirectMessages(token, with, currentConfiguration().MaxMessages)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []MessageEntry
+
+	for i := range data {
+		m := data[len(data)-i-1]
+		message := MessageEntry{From: m.From, Message: m.Message, Mine: m.From == username}
+
+		numTime, err := strconv.ParseInt(m.Timestamp, 10, 64)
+
+		if err == nil {
+			thisTime := time.Unix(numTime, 0)
+			message.Date = FormatAsDate(thisTime).String() + " " + FormatAsTime(thisTime).String()
+		} else {
+			message.Date = "???"
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// Show the logged-in user's conversations and, unless with is empty, the one with with
+func showConversation(w http.ResponseWriter, req *http.Request, with string) {
+	message := ""
+
+	switch status {
+	case DM_SENT:
+		message = getStatusValue()
+	case DM_FAILED:
+		message = "<b>" + getStatusValue() + "!</b> " + takeFailureMessage()
+	}
+
+	if status == DM_SENT || status == DM_FAILED {
+		status = LOGGED_IN
+	}
+
+	context := DirectContext{CSRFToken: getSession(req).CSRFToken, With: with, Room: roomName()}
+
+	// Get the messages first, so the list doesn't count them as unread
+	if with != "" {
+		messages, err := getMessages(with)
+
+		if err != nil {
+			Debug.Println("Could not get direct messages: " + err.Error())
+			message += "<b>Could not get your messages with " + template.HTMLEscapeString(with) + "!</b> " + template.HTMLEscapeString(err.Error())
+		}
+
+		context.Messages = messages
+	}
+
+	conversations, err := backend.ListConversations(token)
+
+	if err != nil {
+		Debug.Println("Could not get direct messages: " + err.Error())
+		message += "<b>Could not get your direct messages!</b> " + template.HTMLEscapeString(err.Error())
+	}
+
+	for _, c := range conversations {
+		context.Conversations = append(context.Conversations, ConversationEntry{
+			With:    c.With,
+			URL:     conversationURL(c.With),
+			Unread:  c.Unread,
+			Current: c.With == with,
+		})
+	}
+
+	theme := requestTheme(req)
+	headerContext := HeaderContext{Message: message, Title: "Direct messages - " + theme.Title, Theme: theme}
+
+	s1 := lookupTemplate("header.tmpl")
+	s1.Execute(w, headerContext)
+
+	// Instead of posts.tmpl and home.tmpl
+	s2 := lookupTemplate("direct.tmpl")
+	s2.Execute(w, context)
+
+	s3 := lookupTemplate("footer.tmpl")
+	s3.Execute(w, newFooterContext(req))
+}
+
+// Show the logged-in user's conversations: /dm/, or one of them: /dm/NAME
+func DirectServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("DirectServer called with status: " + getStatusValue())
+
+	if token == "" {
+		StartServer(w, req)
+		return
+	}
+
+	showConversation(w, req, userNamePolicy().Normalize(strings.TrimPrefix(req.URL.Path, "/dm/")))
+}
+
+// Send a direct message, and show the conversation it's in
+func MessageServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("MessageServer called with status: " + getStatusValue())
+
+	if token == "" {
+		StartServer(w, req)
+		return
+	}
+
+	req.ParseForm() // Parses the request body
+
+	to := userNamePolicy().Normalize(req.PostForm.Get("to"))
+	message := strings.TrimSpace(req.PostForm.Get("message"))
+
+	var err error
+
+	switch {
+	case to == "":
+		err = errors.New("Enter who to send the message to")
+	case message == "":
+		err = errors.New("Enter a message")
+	default:
+		Debug.Println("Calling SendDirectMessage")
+
+		err = backend.SendDirectMessage(token, to, message)
+	}
+
+	if err != nil {
+		Debug.Println("Could not send direct message: " + err.Error())
+		setFailureMessage(err.Error())
+		status = DM_FAILED
+	} else {
+		status = DM_SENT
+	}
+
+	showConversation(w, req, to)
+}
@@ -0,0 +1,147 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Post history:
+
+  The posts we show come from a chatlib.History, a SQLite database in HistoryFile,
+  after we add the room's new posts to it (see chatlib/history.go).
+  If we can't get the new posts, such as when there's no connection,
+  we show the ones we have, and posts.tmpl says so.
+
+  The history keeps every post we've gotten, so the page can show more than MaxMessages:
+  Older posts goes to the same page with ?posts=N.
+*/
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+// Every post we've gotten; nil if we could not open HistoryFile
+var history *chatlib.History
+
+// Why the posts we show might not be the latest; empty if they are
+var historyProblem string
+
+// Where we keep the history of posts
+func historyFile() string {
+	file := currentConfiguration().HistoryFile
+
+	if file == "" {
+		return "history.db"
+	}
+
+	return file
+}
+
+func openHistory() {
+	var err error
+
+	history, err = chatlib.OpenHistory(historyFile())
+
+	if err != nil {
+		log.Println(err.Error())
+		log.Println("Getting posts without keeping them")
+		history = nil
+	}
+}
+
+// Get the latest maxMessages posts in the room, newest first, from the history,
+// after adding the room's new posts to it; a negative maxMessages gets every one
+func historyPosts(maxMessages int) ([]chatlib.Post, error) {
+	historyProblem = ""
+
+	// Send any posts that are waiting first, so they're among the posts
+	_, err := sendOutbox()
+
+	if err != nil {
+		Debug.Println("Could not send outbox: " + err.Error())
+	}
+
+	if history == nil {
+		if maxMessages < 0 {
+			maxMessages = currentConfiguration().MaxMessages
+		}
+
+		return backend.GetPosts(roomName(), maxMessages)
+	}
+
+	Debug.Println("Calling Sync")
+
+	result, syncErr := history.Sync(backend, roomName(), currentConfiguration().MaxMessages)
+
+	if syncErr == nil {
+		Debug.Println("Sync added " + strconv.Itoa(result.Added) + ", changed " + strconv.Itoa(result.Changed) + ", and deleted " + strconv.Itoa(result.Deleted) + " posts")
+	} else {
+		log.Println(syncErr.Error())
+	}
+
+	posts, err := history.Posts(roomName(), maxMessages)
+
+	if err != nil {
+		return posts, err
+	}
+
+	if syncErr != nil {
+		if len(posts) == 0 {
+			return posts, syncErr
+		}
+
+		historyProblem = "Could not get the latest posts, so these are the ones we already had."
+	}
+
+	return posts, nil
+}
+
+// Take a deleted post out of the history
+func forgetPost(key chatlib.PostKey) {
+	if history == nil {
+		return
+	}
+
+	err := history.Remove(key)
+
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// How many posts to show: MaxMessages, or more with ?posts=N
+func postsToShow(req *http.Request) int {
+	maxMessages := currentConfiguration().MaxMessages
+
+	n, err := strconv.Atoi(req.URL.Query().Get("posts"))
+
+	if err == nil && n > maxMessages {
+		return n
+	}
+
+	return maxMessages
+}
+
+// How many posts Older posts shows, if the history has more than shown; otherwise 0
+func olderPosts(shown int) int {
+	if history == nil {
+		return 0
+	}
+
+	count, err := history.Count(roomName())
+
+	if err != nil || count <= shown {
+		return 0
+	}
+
+	return shown + currentConfiguration().MaxMessages
+}
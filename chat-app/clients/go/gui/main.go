@@ -16,6 +16,11 @@ package main
              LoginServer calls / with status 'Not logged in' (start over).
              Otherwise it sets the status to 'Logged in'
              and calls HomeServer.
+        iv.  If the user pool answers with a challenge, such as an MFA code
+             or a new password, LoginServer sets the status to 'Answering challenge'
+             and StartServer shows challenge.tmpl, which posts the answer
+             to /challenge (ChallengeServer). Registering and resetting a password
+             sign the user in the same way, so they can get a challenge too.
      c. Register with username, email address, and password
         i.   Button -> posts username, email address, and password to /register (RegisterServer)
         ii.  RegisterServer calls startRegisterUser with username, email address, and password
@@ -54,9 +59,7 @@ import (
     "text/template"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
 )
 
 // Used for status
@@ -71,12 +74,58 @@ const (
     MESSAGE_DELETE_FAILED
     MESSAGE_POSTED
     MESSAGE_FAILED
+    MESSAGE_EDITED
+    MESSAGE_EDIT_FAILED
+    REPLY_POSTED
+    REPLY_FAILED
+    // Reacting to a post, or taking the reaction back
+    REACTION_ADDED
+    REACTION_REMOVED
+    REACTION_FAILED
     // REGISTERED -> LOGGED_IN
     REGISTERING
     REGISTRATION_FAILED
+    // Registered, but we no longer have their password, so they must log in
+    REGISTERED
     // RESET -> LOGGED_IN
     RESETTING
     RESET_FAILED
+    // Their token expired or isn't valid, so we logged them out
+    SESSION_EXPIRED
+    // We sent them their user name (if their email address belongs to an account)
+    USERNAME_SENT
+    USERNAME_FAILED
+    // CHALLENGED -> LOGGED_IN
+    CHALLENGED
+    // Adding an authenticator app; see mfa.go
+    // MFA_ENROLLING -> MFA_ENABLED -> LOGGED_IN
+    MFA_ENROLLING
+    MFA_ENABLED
+    MFA_DISABLED
+    MFA_FAILED
+    // Changing their password or email address, and exporting their posts; see account.go
+    PASSWORD_CHANGED
+    PASSWORD_CHANGE_FAILED
+    // EMAIL_VERIFYING -> EMAIL_CHANGED -> LOGGED_IN
+    EMAIL_VERIFYING
+    EMAIL_CHANGED
+    EMAIL_CHANGE_FAILED
+    EXPORT_FAILED
+    // Confirming they want to delete their account; see account.go
+    // DELETING -> ACCOUNT_DELETED -> NOT_LOGGED_IN
+    DELETING
+    DELETE_FAILED
+    ACCOUNT_DELETED
+    // Creating, joining, or going to a room; see rooms.go
+    ROOM_CREATED
+    ROOM_JOINED
+    ROOM_FAILED
+    // Sending a direct message; see direct.go
+    DM_SENT
+    DM_FAILED
+    // Posts that weren't sent; see outbox.go
+    MESSAGE_QUEUED
+    OUTBOX_DISCARDED
 )
 
 // Status
@@ -102,14 +151,78 @@ func getStatusValue() string {
         value = "Message posted"
     case MESSAGE_FAILED:
         value = "Failed to post message"
+    case MESSAGE_EDITED:
+        value = "Message edited"
+    case MESSAGE_EDIT_FAILED:
+        value = "Failed to edit message"
+    case REPLY_POSTED:
+        value = "Reply posted"
+    case REPLY_FAILED:
+        value = "Failed to post reply"
+    case REACTION_ADDED:
+        value = "Reaction added"
+    case REACTION_REMOVED:
+        value = "Reaction removed"
+    case REACTION_FAILED:
+        value = "Failed to react"
     case REGISTERING:
         value = "Registering"
     case REGISTRATION_FAILED:
         value = "Registration failed"
+    case REGISTERED:
+        value = "Registered"
     case RESETTING:
         value = "Resetting password"
     case RESET_FAILED:
         value = "Resetting password failed"
+    case SESSION_EXPIRED:
+        value = "Session expired"
+    case USERNAME_SENT:
+        value = "User name sent"
+    case USERNAME_FAILED:
+        value = "Sending user name failed"
+    case CHALLENGED:
+        value = "Answering challenge"
+    case MFA_ENROLLING:
+        value = "Setting up MFA"
+    case MFA_ENABLED:
+        value = "MFA turned on"
+    case MFA_DISABLED:
+        value = "MFA turned off"
+    case MFA_FAILED:
+        value = "Could not change MFA"
+    case PASSWORD_CHANGED:
+        value = "Password changed"
+    case PASSWORD_CHANGE_FAILED:
+        value = "Could not change password"
+    case EMAIL_VERIFYING:
+        value = "Verifying email"
+    case EMAIL_CHANGED:
+        value = "Email changed"
+    case EMAIL_CHANGE_FAILED:
+        value = "Could not change email"
+    case EXPORT_FAILED:
+        value = "Could not export posts"
+    case DELETING:
+        value = "Deleting account"
+    case DELETE_FAILED:
+        value = "Could not delete account"
+    case ACCOUNT_DELETED:
+        value = "Account deleted"
+    case ROOM_CREATED:
+        value = "Room created"
+    case ROOM_JOINED:
+        value = "Joined room"
+    case ROOM_FAILED:
+        value = "Could not go to room"
+    case DM_SENT:
+        value = "Message sent"
+    case DM_FAILED:
+        value = "Could not send message"
+    case MESSAGE_QUEUED:
+        value = "Message not sent yet"
+    case OUTBOX_DISCARDED:
+        value = "Post discarded"
     }
 
     return value
@@ -118,6 +231,7 @@ func getStatusValue() string {
 // Global variables
 // Log
 var Debug *log.Logger
+var Info *log.Logger
 
 type Configuration struct {
     Region      string
@@ -125,16 +239,127 @@ type Configuration struct {
     MaxMessages int
     RefreshSeconds int
     Debug bool
+    // One of debug, info (the default), or error
+    LogLevel string
+    // The theme to use unless the user picks another one
+    Theme string
+    // Themes in addition to the built-in light and dark themes
+    Themes map[string]Theme
+    // Folder of static files that replace or add to the built-in ones
+    StaticDir string
+    // To serve HTTPS, the certificate and private key files
+    TLSCertFile string
+    TLSKeyFile string
+    // The user pool and app client whose tokens we accept
+    UserPoolID string
+    ClientID   string
+    // Where we keep the user pool's keys; downloaded if it doesn't exist
+    JWKSFile string
+    // Where we keep users and posts: lambda (the default) or memory
+    Backend string
+    // The user pool's password policy, so we can check passwords before sending them
+    PasswordPolicy chatlib.PasswordPolicy
+    // The user names the user pool accepts, and whether it ignores their case
+    UserNamePolicy chatlib.UserNamePolicy
+    // What authenticator apps call this app; defaults to Chat App
+    TOTPIssuer string
+    // The room the app starts in; defaults to general
+    DefaultRoom string
+    // Where we keep, for each user, the newest mention of them they've seen
+    MentionsFile string
+    // Where we keep every post we've gotten, to search
+    SearchIndexFile string
+    // The SQLite database where we keep every post we've gotten, to show them from
+    HistoryFile string
+    // Where we keep the posts we haven't sent yet
+    OutboxFile string
+    // Maps the function names used in this app to the deployed functions
+    Functions map[string]chatlib.FunctionConfig
 }
 
 // Configuration
 var configuration Configuration
 
+// Where we get the configuration
+const configFile = "conf.json"
+
 // User token
 var token string
 
+// Where we last sent a code or their user name, masked and ready for the page
+var codeMessage string
+
+// Why the last action failed, escaped and ready for the page;
+// shown once, with the status message
+var failureMessage string
+
+// The passwords the user pool accepts
+func passwordPolicy() chatlib.PasswordPolicy {
+    return currentConfiguration().PasswordPolicy.OrDefault()
+}
+
+// The user names the user pool accepts
+func userNamePolicy() chatlib.UserNamePolicy {
+    return currentConfiguration().UserNamePolicy.OrDefault()
+}
+
+// The problems with each field of the register form, and what they entered,
+// so start.tmpl can show them next to the fields; shown once
+var fieldErrors map[string][]string
+var fieldValues map[string]string
+
+// Get fieldErrors and fieldValues, and forget them
+func takeFieldErrors() (map[string][]string, map[string]string) {
+    problems, values := fieldErrors, fieldValues
+    fieldErrors, fieldValues = nil, nil
+
+    return problems, values
+}
+
+// Check a new password against the policy.
+// If it breaks any rules, list them all in failureMessage.
+func checkNewPassword(password []byte) error {
+    err := passwordPolicy().Validate(password)
+
+    if err != nil {
+        setFailureMessage(err.Error())
+    }
+
+    return err
+}
+
+// Set failureMessage to why something failed, escaped, as a sentence
+func setFailureMessage(why string) {
+    failureMessage = template.HTMLEscapeString(why)
+
+    if !strings.HasSuffix(why, ".") {
+        failureMessage += "."
+    }
+}
+
+// Get failureMessage, if there is one, and forget it
+func takeFailureMessage() string {
+    message := failureMessage
+    failureMessage = ""
+
+    if message != "" {
+        message += " "
+    }
+
+    return message
+}
+
 var username string
-var password string
+
+// What the user pool wants before it signs the user in, such as an MFA code;
+// nil unless status is CHALLENGED
+var challenge *chatlib.Challenge
+
+// How many wrong answers the user gave to challenge
+var challengeAttempts int
+
+// How many wrong answers we take before we make them sign in again
+const maxChallengeAttempts = 3
 
 // Templates
 var templates *template.Template
@@ -165,6 +390,7 @@ func usage() {
 }
 
 func initLog(debugHandle io.Writer) {
+	Info = log.New(os.Stdout, "", 0)
 	Debug = log.New(debugHandle, "", 0) // To add info about date/time:
 	//		"DEBUG: ",
 	//		log.Ldate|log.Ltime|log.Lshortfile)
@@ -188,55 +414,35 @@ func (t FormatAsDate) Equals(t2 FormatAsDate) bool {
 	return t.String() == t2.String()
 }
 
-type getPostsRequest struct {
-	SortBy     string
-	SortOrder  string
-	PostsToGet int
-}
-
-type getPostsResponseAlias struct {
-	S string
-}
-
-type getPostsResponseTimestamp struct {
-	S string
-}
-
-type getPostsResponseMessage struct {
-	S string
-}
-
-type getPostsResponseData struct {
-	Alias     getPostsResponseAlias
-	Timestamp getPostsResponseTimestamp
-	Message   getPostsResponseMessage
-}
-
-type getPostsResponseBody struct {
-	Result string                 `json:"result"`
-	Data   []getPostsResponseData `json:"data"`
-}
-
-type getPostsResponseHeaders struct {
-	ContentType string `json:"Content-Type"`
-}
-
-type getPostsResponse struct {
-	StatusCode int                     `json:"statusCode"`
-	Headers    getPostsResponseHeaders `json:"headers"`
-	Body       getPostsResponseBody    `json:"body"`
-}
-
 type PostEntry struct {
     Date string
     Message string
     Timestamp string
-}
+    // Who posted it; empty for the date lines
+    Alias string
+    // True if its author changed the message
+    Edited bool
+    // How many replies deep it is, and &nbsp;s to indent it that much
+    Depth int
+    Indent string
+    // How many replies it has, and whether the user hid them
+    Replies int
+    Collapsed bool
+    // The message, escaped, with each mention of a known user in a span
+    MessageHTML string
+    // True if it mentions the logged-in user
+    MentionsYou bool
+    // The reactions to it, such as 👍 2  🎉 1; empty if there are none
+    Reactions string
+}
+
+// The threads whose replies the user hid, by the key of the post that starts them
+var collapsedThreads = make(map[chatlib.PostKey]bool)
 
 func SetConfiguration() {
-    if configuration == (Configuration{}) {
+    if configuration.Region == "" {
         // Get configuration values
-        file, _ := os.Open("conf.json")
+        file, _ := os.Open(configFile)
         decoder := json.NewDecoder(file)
 
         err := decoder.Decode(&configuration)
@@ -252,138 +458,177 @@ func SetConfiguration() {
     }
 }
 
-var client *lambda.Lambda
+// Where we keep users and posts
+var backend chatlib.Backend
 
-func getLambdaClient() (*lambda.Lambda)  {
-    if client == nil { // *(lambda.Lambda{}) {
-        // Create Lambda service client
-        sess := session.Must(session.NewSessionWithOptions(session.Options{
-            SharedConfigState: session.SharedConfigEnable,
-        }))
+// Remember where the backend sent something, for the status message.
+// otherwise, which must already be masked, is used if the backend didn't say.
+func setCodeMessage(prefix string, delivery chatlib.CodeDelivery, otherwise string) {
+    destination := delivery.MaskedDestination()
 
-        client = lambda.New(sess, &aws.Config{Region: aws.String(configuration.Region)})
+    if destination == "" {
+        destination = otherwise
     }
 
-    return client
+    codeMessage = prefix + " " + template.HTMLEscapeString(destination) + "."
 }
 
-// Get all posts as an array of postEntry items
-func getAllPosts() ([]PostEntry) {
-    svc := getLambdaClient()
-
-    var resp getPostsResponse
+// Get the latest maxMessages posts as an array of postEntry items
+func getAllPosts(maxMessages int) ([]PostEntry) {
     var posts []PostEntry
 
-    // Flags and their default values:
-    maxMessages := configuration.MaxMessages
-
-	// Get the latest maxMessages posts
-	request := getPostsRequest{"timestamp", "descending", maxMessages}
+    // From the history, unless there isn't one
+    data, err := historyPosts(maxMessages)
 
-	payload, err := json.Marshal(request)
+    if err != nil {
+        // Such as no connection, before we have any posts
+        log.Println("Error getting posts: " + err.Error())
+        historyProblem = "Could not get the posts."
+    }
 
-	if err != nil {
-        log.Fatal("Error marshalling GetPosts request: " + err.Error())
-	}
+    indexPosts(data)
 
-	result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("GetPosts"), Payload: payload})
+    numPosts := len(data)
 
-	if err != nil {
-        log.Fatal("Error calling Lambda function GetPosts: " + err.Error())
-	}
+    if numPosts > 0 {
+        var origDate FormatAsDate
 
-	err = json.Unmarshal(result.Payload, &resp)
+        // Replies go under the posts they answer, unless the user collapsed the thread
+        threads := chatlib.GroupThreads(data)
+        collapsed := func(p chatlib.Post) bool { return collapsedThreads[p.Key()] }
+        known := knownUsers(data)
 
-	if err != nil {
-        log.Fatal("Error unmarshalling GetPosts response: " + err.Error())
-	}
+        for i := range threads {
+            thread := threads[len(threads)-i-1]
 
-	// Check the status code
-	if resp.StatusCode != 200 {
-        log.Fatal("Error getting posts, StatusCode: " + strconv.Itoa(resp.StatusCode))
-	}
+            for _, tp := range thread.Flatten(collapsed) {
+                p := tp.Post
+                var post PostEntry
+                // Doug @ 4:45 PM PST <ID>:
+                // Where is the meeting today?
 
-	numPosts := len(resp.Body.Data)
+                // Convert date/time from UTC
+                numTime, err := strconv.ParseInt(p.Timestamp, 10, 64)
 
-	if numPosts > 0 {
-		var origDate FormatAsDate
+                if err == nil {
+                    thisTime := time.Unix(numTime, 0)
 
-        var post PostEntry
+                    theDate := FormatAsDate(thisTime)
+                    theTime := FormatAsTime(thisTime)
 
-		for i := range resp.Body.Data {
-			p := resp.Body.Data[len(resp.Body.Data)-i-1]
-			// Doug @ 4:45 PM PST <ID>:
-			// Where is the meeting today?
+                    // If we have a new date, show it; replies stay with their thread
+                    if tp.Depth == 0 && !origDate.Equals(theDate) {
+                        var blankPost PostEntry
+                        blankPost.Date = "=== " + theDate.String() + " ==="
+                        blankPost.Message = ""
+                        blankPost.Timestamp = ""
 
-			// Convert date/time from UTC
-			numTime, err := strconv.ParseInt(p.Timestamp.S, 10, 64)
+                        posts = append(posts, blankPost)
 
-			if err == nil {
-				thisTime := time.Unix(numTime, 0)
+                        origDate = theDate
+                    }
 
-				theDate := FormatAsDate(thisTime)
-				theTime := FormatAsTime(thisTime)
+                    post.Date = p.Alias + "@" + theTime.String()
+                } else {
+                    post.Date = p.Alias + "@??? "
+                }
 
-				// If we have a new date, show it
-				if !origDate.Equals(theDate) {
-                    var blankPost PostEntry
-                    blankPost.Date = "=== " + theDate.String() + " ==="
-                    blankPost.Message = ""
-                    blankPost.Timestamp = ""
+                post.Message = p.Message
+                post.MessageHTML = mentionsHTML(p.Message, known)
+                post.MentionsYou = token != "" && p.Alias != username && chatlib.Mentioned(userNamePolicy(), p.Message, username)
+                post.Reactions = chatlib.ReactionSummary(p.ReactionCounts(""))
+                post.Timestamp = p.Timestamp
+                post.Alias = p.Alias
+                post.Edited = p.Edited()
 
-                    posts = append(posts, blankPost)
+                if post.Edited {
+                    post.Date += " (edited)"
+                }
 
-					origDate = theDate
-				}
+                post.Depth = tp.Depth
+                post.Replies = tp.Replies
+                post.Collapsed = collapsed(p)
 
-				post.Date = p.Alias.S + "@" + theTime.String()
-				post.Message = p.Message.S
-				post.Timestamp = p.Timestamp.S
-			} else {
-				post.Date = p.Alias.S + "@??? "
-				post.Message = p.Message.S
-				post.Timestamp = p.Timestamp.S
-			}
+                if tp.Depth > 0 {
+                    post.Indent = strings.Repeat("&nbsp;&nbsp;&nbsp;&nbsp;", tp.Depth)
+                    post.Date = "&#8627; " + post.Date
+                } else if thread.Orphan {
+                    post.Date += " (reply to " + p.Parent.Alias + ")"
+                }
 
-            posts = append(posts, post)
-		}
-	}
+                switch {
+                case post.Replies == 1:
+                    post.Date += " (1 reply"
+                case post.Replies > 1:
+                    post.Date += " (" + strconv.Itoa(post.Replies) + " replies"
+                }
 
-	return posts
-}
+                if post.Replies > 0 {
+                    if post.Collapsed {
+                        post.Date += ", hidden"
+                    }
 
-func ParseTemplates() {
-    var allFiles []string
+                    post.Date += ")"
+                }
 
-    files, err := ioutil.ReadDir(".")
-
-    if err != nil {
-        log.Fatal("Error getting files in current folder: " + err.Error())
-    }
-
-    for _, file := range files {
-        filename := file.Name()
-
-        if strings.HasSuffix(filename, ".tmpl") {
-            allFiles = append(allFiles, "./"+filename)
+                posts = append(posts, post)
+            }
         }
     }
 
-    // Parse all .tmpl files in this folder
-    templates, err = template.ParseFiles(allFiles...)
-
-    if err != nil {
-        log.Fatal("Error parsing templates: " + err.Error())
-    }
+    return posts
 }
 
 type HeaderContext struct {
     Message string
     Title string
+    Theme Theme
 }
 
 type PostsContext struct {
     Posts []PostEntry
+    // For the form that hides or shows the replies to a post
+    CSRFToken string
+    // Set once they log in, so posts.tmpl can let them edit their posts and reply
+    UserName string
+    // For the sidebar: every room, and the one the posts are from
+    Rooms []RoomEntry
+    Room string
+    // True if they're logged in, but can't post to Room yet
+    CanJoin bool
+    // How many direct messages the logged-in user hasn't read
+    Unread int
+    // How many posts mention the logged-in user since they last looked
+    Mentions int
+    // The reactions they can pick from
+    ReactionChoices []ReactionChoice
+    // Why the posts might not be the latest, such as no connection
+    Offline string
+    // How many posts Older posts shows; 0 if there are no more
+    Older int
+    // Their posts in the room that haven't been sent
+    Outbox []OutboxEntry
+}
+
+func newPostsContext(req *http.Request, posts []PostEntry) PostsContext {
+    context := PostsContext{Posts: posts, CSRFToken: getSession(req).CSRFToken, Room: roomName(), ReactionChoices: reactionChoices()}
+    context.Offline = historyProblem
+    context.Older = olderPosts(postsToShow(req))
+    context.Outbox = outboxEntries()
+
+    if token != "" {
+        context.Rooms = roomEntries(username)
+    } else {
+        context.Rooms = roomEntries("")
+    }
+
+    for _, room := range context.Rooms {
+        if room.Current && token != "" && !room.Joined {
+            context.CanJoin = true
+        }
+    }
+
+    return context
 }
 
 // See the following web page for info on automatically refreshing the posts
@@ -416,84 +661,138 @@ func StartServer(w http.ResponseWriter, req *http.Request) {
         HomeServer(w, req)
 
     case RESETTING:
-        message = "Enter your confirmation code and click <b>Submit</b> to finish resetting your password"
+        message = takeFailureMessage() + codeMessage + " Enter your confirmation code and click <b>Submit</b> to finish resetting your password"
+        var headerContext HeaderContext
+        theme := requestTheme(req)
+        headerContext = HeaderContext{Message: message, Title: theme.Title, Theme: theme}
+        s1 := lookupTemplate("header.tmpl")
+        s1.Execute(w, headerContext)
+
+        var postContext PostsContext
+        posts := getAllPosts(postsToShow(req))
+        postContext = newPostsContext(req, posts)
+        s2 := lookupTemplate("posts.tmpl")
+        s2.Execute(w, postContext)
+
+        s3 := lookupTemplate("reset.tmpl")
+        s3.Execute(w, newFormContext(req))
+
+        s4 := lookupTemplate("footer.tmpl")
+        s4.Execute(w, newFooterContext(req))
+
+    case CHALLENGED:
+        message = takeFailureMessage() + template.HTMLEscapeString(challenge.Prompt()) + " and click <b>Submit</b> to finish logging in"
         var headerContext HeaderContext
-        headerContext = HeaderContext{Message: message, Title: "Chat App"}
-        s1 := templates.Lookup("header.tmpl")
+        theme := requestTheme(req)
+        headerContext = HeaderContext{Message: message, Title: theme.Title, Theme: theme}
+        s1 := lookupTemplate("header.tmpl")
         s1.Execute(w, headerContext)
 
         var postContext PostsContext
-        posts := getAllPosts()
-        postContext = PostsContext{Posts: posts}
-        s2 := templates.Lookup("posts.tmpl")
+        posts := getAllPosts(postsToShow(req))
+        postContext = newPostsContext(req, posts)
+        s2 := lookupTemplate("posts.tmpl")
         s2.Execute(w, postContext)
 
-        s3 := templates.Lookup("reset.tmpl")
-        s3.Execute(w, nil)
+        formContext := newFormContext(req)
+        formContext.Challenge = challenge
+
+        s3 := lookupTemplate("challenge.tmpl")
+        s3.Execute(w, formContext)
 
-        s4 := templates.Lookup("footer.tmpl")
-        s4.Execute(w, nil)
+        s4 := lookupTemplate("footer.tmpl")
+        s4.Execute(w, newFooterContext(req))
 
     case REGISTERING:
-        message = "Enter your confirmation code and click <b>Submit</b> to finish registering"
+        message = codeMessage + " Enter your confirmation code and click <b>Submit</b> to finish registering"
         var headerContext HeaderContext
-        headerContext = HeaderContext{Message: message, Title: "Chat App"}
-        s1 := templates.Lookup("header.tmpl")
+        theme := requestTheme(req)
+        headerContext = HeaderContext{Message: message, Title: theme.Title, Theme: theme}
+        s1 := lookupTemplate("header.tmpl")
         s1.Execute(w, headerContext)
 
         var postContext PostsContext
-        posts := getAllPosts()
-        postContext = PostsContext{Posts: posts}
-        s2 := templates.Lookup("posts.tmpl")
+        posts := getAllPosts(postsToShow(req))
+        postContext = newPostsContext(req, posts)
+        s2 := lookupTemplate("posts.tmpl")
         s2.Execute(w, postContext)
 
-        s3 := templates.Lookup("register.tmpl")
-        s3.Execute(w, nil)
+        s3 := lookupTemplate("register.tmpl")
+        s3.Execute(w, newFormContext(req))
 
-        s4 := templates.Lookup("footer.tmpl")
-        s4.Execute(w, nil)
+        s4 := lookupTemplate("footer.tmpl")
+        s4.Execute(w, newFooterContext(req))
 
     default:
         // Change message if attempt to login, register, or reset password failed
         if status == LOGIN_FAILED {
-            message = "<b>Login failed!</b> " + message
+            message = "<b>Login failed!</b> " + takeFailureMessage() + message
         }
 
         if status == REGISTRATION_FAILED {
-            message = "<b>Registration failed!</b>! " + message
+            message = "<b>Registration failed!</b> " + takeFailureMessage() + message
+        }
+
+        if status == REGISTERED {
+            message = "<b>You are registered!</b> Log in to post, delete a post, or delete your account."
         }
 
         if status == RESET_FAILED {
             message = "<b>Resetting password failed!</b> " + message
         }
 
+        if status == SESSION_EXPIRED {
+            message = "<b>Your session has expired!</b> Log in again to post, delete a post, or delete your account."
+        }
+
+        if status == USERNAME_SENT {
+            message = codeMessage + " " + message
+        }
+
+        if status == ACCOUNT_DELETED {
+            message = deletionMessage + " " + message
+            deletionMessage = ""
+        }
+
+        if status == ROOM_FAILED {
+            message = "<b>" + getStatusValue() + "!</b> " + takeFailureMessage() + message
+        }
+
+        if status == USERNAME_FAILED {
+            message = "<b>Could not send your user name!</b> " + takeFailureMessage() + message
+        }
+
         status = NOT_LOGGED_IN
 
         // Beginning HTML tags, includinge common message (paragraph)
         var headerContext HeaderContext
-        headerContext = HeaderContext{Message: message, Title: "Chat App"}
-        s1 := templates.Lookup("header.tmpl")
+        theme := requestTheme(req)
+        headerContext = HeaderContext{Message: message, Title: theme.Title, Theme: theme}
+        s1 := lookupTemplate("header.tmpl")
         s1.Execute(w, headerContext)
 
         // Display the posts
-        posts := getAllPosts()
+        posts := getAllPosts(postsToShow(req))
 
         numMsgs := len(posts)
 
         Debug.Println("Got: " + strconv.Itoa(numMsgs) + " posts")
 
         var postContext PostsContext
-        postContext = PostsContext{Posts: posts}
-        s2 := templates.Lookup("posts.tmpl")
+        postContext = newPostsContext(req, posts)
+        s2 := lookupTemplate("posts.tmpl")
         s2.Execute(w, postContext)
 
         // Forms for log in, register, reset password
-        s3 := templates.Lookup("start.tmpl")
-        s3.Execute(w, nil)
+        formContext := newFormContext(req)
+        formContext.Errors, formContext.Values = takeFieldErrors()
+
+        s3 := lookupTemplate("start.tmpl")
+        s3.Execute(w, formContext)
 
         // Closing HTML tags
-        s4 := templates.Lookup("footer.tmpl")
-        s4.Execute(w, nil)
+        s4 := lookupTemplate("footer.tmpl")
+        s4.Execute(w, newFooterContext(req))
     }
 }
 
@@ -503,16 +802,17 @@ func AboutServer(w http.ResponseWriter, req *http.Request) {
     message := ""
 
     var headerContext HeaderContext
-    headerContext = HeaderContext{Message: message, Title: "About the Chat App"}
+    theme := requestTheme(req)
+    headerContext = HeaderContext{Message: message, Title: "About the " + theme.Title, Theme: theme}
 
-    s1 := templates.Lookup("header.tmpl")
+    s1 := lookupTemplate("header.tmpl")
     s1.Execute(w, headerContext)
 
-    s2 := templates.Lookup("about.tmpl")
+    s2 := lookupTemplate("about.tmpl")
     s2.Execute(w, nil)
 
-    s3 := templates.Lookup("footer.tmpl")
-    s3.Execute(w, nil)
+    s3 := lookupTemplate("footer.tmpl")
+    s3.Execute(w, newFooterContext(req))
 }
 
 func ContactServer(w http.ResponseWriter, req *http.Request) {
@@ -521,16 +821,17 @@ func ContactServer(w http.ResponseWriter, req *http.Request) {
     message := ""
 
     var headerContext HeaderContext
-    headerContext = HeaderContext{Message: message, Title: "Contact info for the Chat App"}
+    theme := requestTheme(req)
+    headerContext = HeaderContext{Message: message, Title: "Contact info for the " + theme.Title, Theme: theme}
 
-    s1 := templates.Lookup("header.tmpl")
+    s1 := lookupTemplate("header.tmpl")
     s1.Execute(w, headerContext)
 
-    s2 := templates.Lookup("contact.tmpl")
+    s2 := lookupTemplate("contact.tmpl")
     s2.Execute(w, nil)
 
-    s3 := templates.Lookup("footer.tmpl")
-    s3.Execute(w, nil)
+    s3 := lookupTemplate("footer.tmpl")
+    s3.Execute(w, newFooterContext(req))
 }
 
 func HomeServer(w http.ResponseWriter, req *http.Request) {
@@ -543,111 +844,126 @@ func HomeServer(w http.ResponseWriter, req *http.Request) {
         Debug.Println("Calling StartServer from HomeServer")
         StartServer(w, req)
 
-    default:
-        message := getStatusValue()
-        status = LOGGED_IN
-        Debug.Println("Setting status to " + getStatusValue() + " in HomeServer")
+    case MFA_ENROLLING:
+        message := takeFailureMessage() + "Scan the QR code with your authenticator app, then enter the code it shows and click <b>Submit</b>"
 
         var headerContext HeaderContext
-        headerContext = HeaderContext{Message: message, Title: "Chat App"}
-        s1 := templates.Lookup("header.tmpl")
+        theme := requestTheme(req)
+        headerContext = HeaderContext{Message: message, Title: theme.Title, Theme: theme}
+        s1 := lookupTemplate("header.tmpl")
         s1.Execute(w, headerContext)
 
         var postContext PostsContext
-        posts := getAllPosts()
-        postContext = PostsContext{Posts: posts}
-        s2 := templates.Lookup("posts.tmpl")
+        posts := getAllPosts(postsToShow(req))
+        postContext = newPostsContext(req, posts)
+        s2 := lookupTemplate("posts.tmpl")
         s2.Execute(w, postContext)
 
-        // Form for submitting a post and
-        // buttons for deleting a selected post, logging out, deleting account
-        s3 := templates.Lookup("home.tmpl")
-        s3.Execute(w, nil)
+        // QR code and a field for the first code, instead of home.tmpl
+        s3 := lookupTemplate("mfa.tmpl")
+        s3.Execute(w, newMFAContext(req))
 
-        s4 := templates.Lookup("footer.tmpl")
-        s4.Execute(w, nil)
-    }
-}
+        s4 := lookupTemplate("footer.tmpl")
+        s4.Execute(w, newFooterContext(req))
 
-type user struct {
-    UserName string
-    Password string
-}
+    case EMAIL_VERIFYING:
+        message := takeFailureMessage() + codeMessage + " Enter your verification code and click <b>Submit</b> to change your email address"
 
-type userResponseAuthenticationResult struct {
-    AccessToken  string
-    ExpiresIn    int
-    TokenType    string
-    RefreshToken string
-    IdToken      string
-}
+        var headerContext HeaderContext
+        theme := requestTheme(req)
+        headerContext = HeaderContext{Message: message, Title: theme.Title, Theme: theme}
+        s1 := lookupTemplate("header.tmpl")
+        s1.Execute(w, headerContext)
 
-type userResponseData struct {
-    ChallengeParameters  interface{} // {} is always returned
-    AuthenticationResult userResponseAuthenticationResult
-}
+        var postContext PostsContext
+        posts := getAllPosts(postsToShow(req))
+        postContext = newPostsContext(req, posts)
+        s2 := lookupTemplate("posts.tmpl")
+        s2.Execute(w, postContext)
 
-type userResponseBody struct {
-    Result string           `json:"result"`
-    Data   userResponseData `json:"data"`
-}
+        // A field for the code, instead of home.tmpl
+        s3 := lookupTemplate("email.tmpl")
+        s3.Execute(w, newFormContext(req))
 
-type userResponse struct {
-    StatusCode int                 `json:"statusCode"`
-    Headers    userResponseHeaders `json:"headers"`
-    Body       userResponseBody    `json:"body"`
-}
+        s4 := lookupTemplate("footer.tmpl")
+        s4.Execute(w, newFooterContext(req))
 
-func logInUser(userName string, password string) (string, error) {
-    var newToken = ""
-    var myError error
+    case DELETING:
+        // Checking the password of a user with SMS MFA would text them a code
+        phraseOnly := chatlib.DeletionNeedsPhrase(backend, token)
+        instructions := "Enter your password, or the phrase shown,"
 
-    user := user{userName, password}
+        if phraseOnly {
+            instructions = "Type the phrase shown,"
+        }
 
-    payload, err := json.Marshal(user)
+        message := takeFailureMessage() + "Deleting your account can't be undone. " + instructions + " and click <b>Delete account</b>"
 
-    if err != nil {
-        myError = errors.New("Error marshalling SignInCognitoUser request: " + err.Error())
-        return newToken, myError
-    }
+        var headerContext HeaderContext
+        theme := requestTheme(req)
+        headerContext = HeaderContext{Message: message, Title: theme.Title, Theme: theme}
+        s1 := lookupTemplate("header.tmpl")
+        s1.Execute(w, headerContext)
 
-    svc := getLambdaClient()
+        var postContext PostsContext
+        posts := getAllPosts(postsToShow(req))
+        postContext = newPostsContext(req, posts)
+        s2 := lookupTemplate("posts.tmpl")
+        s2.Execute(w, postContext)
 
-    result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("SignInCognitoUser"), Payload: payload})
+        // The confirmation form, instead of home.tmpl
+        formContext := newFormContext(req)
+        formContext.DeletionPhrase = chatlib.DeletionPhrase(username)
+        formContext.DeletionPhraseOnly = phraseOnly
 
-    if err != nil {
-        myError = errors.New("Error calling SignInCognitoUser: " + err.Error())
-        return newToken, myError
-    }
+        s3 := lookupTemplate("unregister.tmpl")
+        s3.Execute(w, formContext)
 
-    var resp userResponse
-    err = json.Unmarshal(result.Payload, &resp)
+        s4 := lookupTemplate("footer.tmpl")
+        s4.Execute(w, newFooterContext(req))
 
-    if err != nil {
-        myError = errors.New("Error unmarshalling SignInCognitoUser response: " + err.Error())
-        return newToken,myError
-    }
+    default:
+        message := getStatusValue()
 
-    // Did we not get a 200?
-    if resp.StatusCode != 200 {
-        var respFailure registerUserResponseFailure
+        switch status {
+        case MESSAGE_FAILED, MESSAGE_EDIT_FAILED, REPLY_FAILED, REACTION_FAILED, ROOM_FAILED, MFA_FAILED, PASSWORD_CHANGE_FAILED, EMAIL_CHANGE_FAILED, EXPORT_FAILED, DELETE_FAILED:
+            message = "<b>" + message + "!</b> " + takeFailureMessage()
+        case MESSAGE_QUEUED:
+            message = "<b>" + message + ".</b> " + takeFailureMessage()
+        case EMAIL_CHANGED:
+            message = "Your email address is changed; your profile shows it the next time you log in."
+        }
 
-        err = json.Unmarshal(result.Payload, &respFailure)
+        status = LOGGED_IN
+        Debug.Println("Setting status to " + getStatusValue() + " in HomeServer")
 
-        Debug.Println("Got a status code of: " + strconv.Itoa(respFailure.StatusCode))
-        Debug.Println("and a message of:      " + respFailure.Body.Error.Message)
+        var headerContext HeaderContext
+        theme := requestTheme(req)
+        headerContext = HeaderContext{Message: message, Title: theme.Title, Theme: theme}
+        s1 := lookupTemplate("header.tmpl")
+        s1.Execute(w, headerContext)
 
-        myError = errors.New(respFailure.Body.Error.Message)
-        return newToken, myError
-    }
+        var postContext PostsContext
+        posts := getAllPosts(postsToShow(req))
+        postContext = newPostsContext(req, posts)
+        postContext.UserName = username
+        postContext.Unread = unreadMessages()
+        postContext.Mentions = newMentions()
+        s2 := lookupTemplate("posts.tmpl")
+        s2.Execute(w, postContext)
 
-    if resp.Body.Result != "success" {
-        Debug.Println("Got a result of: " + resp.Body.Result)
-        myError = errors.New("Got a result of: " + resp.Body.Result)
-        return newToken, myError
-    }
+        // Form for submitting a post and
+        // buttons for deleting a selected post, logging out, deleting account
+        s3 := lookupTemplate("home.tmpl")
+        s3.Execute(w, newFormContext(req))
 
-    return resp.Body.Data.AuthenticationResult.AccessToken, myError
+        // Who they are and the state of their account
+        s4 := lookupTemplate("profile.tmpl")
+        s4.Execute(w, newProfileContext(req, posts))
+
+        s5 := lookupTemplate("footer.tmpl")
+        s5.Execute(w, newFooterContext(req))
+    }
 }
 
 func LoginServer(w http.ResponseWriter, req *http.Request) {
@@ -655,7 +971,6 @@ func LoginServer(w http.ResponseWriter, req *http.Request) {
     Debug.Println("LoginServer called")
 
     username := ""
-    password := ""
 
     switch status {
 
@@ -667,186 +982,116 @@ func LoginServer(w http.ResponseWriter, req *http.Request) {
         // Get username and password and log them in
         req.ParseForm()    // Parses the request body
 
-        username = req.Form.Get("username")
-        password = req.Form.Get("password")
+        // As it was when they registered
+        username = userNamePolicy().Normalize(req.PostForm.Get("username"))
+        password := []byte(req.PostForm.Get("password"))
 
-        Debug.Println("Calling logInUser with user name: " + username + " and password: " + password)
+        Debug.Println("Calling SignIn with user name: " + username)
 
-        newToken, err := logInUser(username, password)
+        signedIn, err := backend.SignIn(username, password)
+        chatlib.ZeroBytes(password)
 
-        if err != nil {
-            fmt.Println("Login failed")
-            // Login failed, so send them back to start
-            status = LOGIN_FAILED
-            StartServer(w, req)
-        } else {
-            token = newToken
-            fmt.Println("User is now logged in")
-            status = LOGGED_IN
-            Debug.Println("Calling HomeServer from LoginServer")
-            HomeServer(w, req)
-        }
+        // If login fails, send them back to start
+        finishSignIn(w, req, signedIn, err, LOGIN_FAILED)
     }
 }
 
-func LogoutServer(w http.ResponseWriter, req *http.Request) {
-    Debug.Println("")
-    Debug.Println("LogoutServer called")
-    // This shouldn't happen,
-    // but if not logged in,
-    // we have nothing to do,
-    // so just redirect them to the start
-    // Nuke global info
-    token = ""
-    username = ""
-    status = NOT_LOGGED_IN
-    StartServer(w, req)
-}
-
-type startRegisterUserRequest struct {
-    UserName string
-    Password string
-    Email    string
-}
-
-type startRegisterUserResponseData struct {
-    UserConfirmed bool
-}
-
-type startRegisterUserResponseBody struct {
-    Result string                        `json:"result"`
-    Data   startRegisterUserResponseData `json:"data"`
-}
-
-type userResponseHeaders struct {
-    ContentType string `json:"Content-Type"`
-}
-
-type registerUserResponse struct {
-    StatusCode int                           `json:"statusCode"`
-    Headers    userResponseHeaders           `json:"headers"`
-    Body       startRegisterUserResponseBody `json:"body"`
-}
-
-type registerUserResponseError struct {
-    Message    string `json:"message"`
-    Code       string
-    Time       string
-    RequestId  string
-    StatusCode int
-    Retryable  bool
-    RetryDelay float64
-}
-
-type registerUserResponseFailureBody struct {
-    Result string                    `json:"result"`
-    Error  registerUserResponseError `json:"error"`
-}
-
-type registerUserResponseFailure struct {
-    StatusCode int                             `json:"statusCode"`
-    Headers    userResponseHeaders             `json:"headers"`
-    Body       registerUserResponseFailureBody `json:"body"`
-}
-
-func startRegisterUser(name string, password string, email string) error {
-    var myError error
-
-    request := startRegisterUserRequest{name, password, email}
-
-    payload, err := json.Marshal(request)
-
-    if err != nil {
-        myError = errors.New("Error marshalling StartAddingPendingCognitoUser request: " + err.Error())
-        return myError
+// Act on what signing in got: log the user in,
+// or show them the challenge they must answer first.
+// If signing in failed, set the status to failed and send them back to start.
+func finishSignIn(w http.ResponseWriter, req *http.Request, signedIn chatlib.SignInResult, err error, failed StatusType) {
+    if err == nil && signedIn.Challenge != nil {
+        Debug.Println("Got challenge " + signedIn.Challenge.Name)
+        challenge = signedIn.Challenge
+        status = CHALLENGED
+        StartServer(w, req)
+        return
     }
 
-    svc := getLambdaClient()
-
-    result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("StartAddingPendingCognitoUser"), Payload: payload})
+    challenge = nil
+    challengeAttempts = 0
 
-    if err != nil {
-        myError = errors.New("Error calling StartAddingPendingCognitoUser: " + err.Error())
-        return myError
+    if err == nil && signedIn.Tokens.AccessToken == "" {
+        err = errors.New("Did not get a token")
     }
 
-    var resp registerUserResponse
-    err = json.Unmarshal(result.Payload, &resp)
-
-    if err != nil {
-        myError = errors.New("Error unmarshalling StartAddingPendingCognitoUser response: " + err.Error())
-        return myError
+    if err == nil {
+        err = setTokens(signedIn.Tokens)
     }
 
-    if resp.StatusCode == 200 {
-        // Got a valid response, was it success?
-        if resp.Body.Result == "success" {
-            return myError
-        }
+    if err != nil {
+        Info.Println("Login failed")
+        Debug.Println(err.Error())
+        status = failed
+        StartServer(w, req)
+    } else {
+        Info.Println("User is now logged in")
+        status = LOGGED_IN
+        HomeServer(w, req)
     }
-
-    return myError
-}
-
-type finishRegisterRequest struct {
-    UserName         string
-    ConfirmationCode string
 }
 
-type finishRegisterResponseBody struct {
-    Result string `json:"result"`
-}
+// Answer the challenge the user pool sent when the user signed in
+func ChallengeServer(w http.ResponseWriter, req *http.Request) {
+    Debug.Println("")
+    Debug.Println("ChallengeServer called with status: " + getStatusValue())
 
-type finishRegisterResponse struct {
-    StatusCode int                        `json:"statusCode"`
-    Headers    userResponseHeaders        `json:"headers"`
-    Body       finishRegisterResponseBody `json:"body"`
-}
+    if status != CHALLENGED || challenge == nil {
+        // We aren't waiting for an answer
+        StartServer(w, req)
+        return
+    }
 
-func finishRegisterUser(name string, code string, password string) (string, error) {
-    newToken := ""
-    var myError error
+    req.ParseForm()    // Parses the request body
 
-    request := finishRegisterRequest{name, code}
+    answer := []byte(strings.TrimSpace(req.PostForm.Get("answer")))
+    defer chatlib.ZeroBytes(answer)
 
-    payload, err := json.Marshal(request)
+    if challenge.WantsPassword() {
+        err := checkNewPassword(answer)
 
-    if err != nil {
-        myError = errors.New("Error marshalling request for FinishAddingPendingCognitoUser: " + err.Error())
-        return newToken, myError
+        if err != nil {
+            // Still challenged, so they can try another password
+            StartServer(w, req)
+            return
+        }
     }
 
-    svc := getLambdaClient()
+    Debug.Println("Calling RespondToChallenge for " + challenge.Name)
 
-    result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("FinishAddingPendingCognitoUser"), Payload: payload})
+    signedIn, err := backend.RespondToChallenge(*challenge, answer)
 
     if err != nil {
-        myError = errors.New("Error calling FinishAddingPendingCognitoUser: " + err.Error())
-        return newToken, myError
-    }
+        challengeAttempts++
+        code := chatlib.ErrorCode(err)
 
-    var resp finishRegisterResponse
-    err = json.Unmarshal(result.Payload, &resp)
+        // A wrong code or a password the user pool didn't like leaves the challenge open
+        if challengeAttempts < maxChallengeAttempts && (code == "CodeMismatchException" || code == "InvalidPasswordException") {
+            Debug.Println(err.Error())
+            setFailureMessage(err.Error())
+            StartServer(w, req)
+            return
+        }
 
-    if err != nil {
-        myError = errors.New("Error unmarshalling FinishAddingPendingCognitoUser response: " + err.Error())
-        return newToken, myError
+        failureMessage = "You must log in again."
     }
 
-    if resp.StatusCode == 200 {
-        // Got a valid response, was it success?
-        if resp.Body.Result == "success" {
-            // Log them in and get token
-            newToken, err = logInUser(name, password)
-
-            if err != nil {
-                myError = errors.New("Error logging user in: " + err.Error())
-                return "", myError
-            }
-        }
-    }
+    finishSignIn(w, req, signedIn, err, LOGIN_FAILED)
+}
 
-    return newToken, myError
+func LogoutServer(w http.ResponseWriter, req *http.Request) {
+    Debug.Println("")
+    Debug.Println("LogoutServer called")
+    // This shouldn't happen,
+    // but if not logged in,
+    // we have nothing to do,
+    // so just redirect them to the start
+    // Nuke global info
+    clearTokens()
+    challenge = nil
+    challengeAttempts = 0
+    status = NOT_LOGGED_IN
+    StartServer(w, req)
 }
 
 func RegisterServer(w http.ResponseWriter, req *http.Request) {
@@ -863,16 +1108,44 @@ func RegisterServer(w http.ResponseWriter, req *http.Request) {
         // Get request values
         req.ParseForm()    // Parses the request body
 
-        username = req.Form.Get("username")
-        password = req.Form.Get("password")
-        email := req.Form.Get("email")
+        password := []byte(req.PostForm.Get("password"))
+
+        // Check every field at once, so they can fix everything at once
+        var email string
+        var err error
+
+        username, email, err = chatlib.ValidateRegistration(userNamePolicy(), passwordPolicy(),
+            req.PostForm.Get("username"), req.PostForm.Get("email"), password)
 
-        Debug.Println("Calling startRegisterUser with:")
+        if validation, ok := err.(*chatlib.ValidationError); ok {
+            fieldErrors = validation.Fields
+            fieldValues = map[string]string{chatlib.UserNameField: username, chatlib.EmailField: email}
+            failureMessage = "Fix the fields marked below."
+        }
+
+        Debug.Println("Calling StartRegistration with:")
         Debug.Println("   Username: " + username)
-        Debug.Println("   Password: " + password)
         Debug.Println("   Email     " + email)
 
-        err := startRegisterUser(username, password, email)
+        var delivery chatlib.CodeDelivery
+
+        if err == nil {
+            delivery, err = backend.StartRegistration(username, password, email)
+        }
+
+        if err == nil {
+            setCodeMessage("We sent a confirmation code to", delivery, chatlib.MaskDestination(email))
+
+            // So we can log them in once they finish registering,
+            // without keeping their password here
+            pendingErr := setPendingRegistration(w, req, username, password)
+
+            if pendingErr != nil {
+                Debug.Println("Could not save pending registration: " + pendingErr.Error())
+            }
+        }
+
+        chatlib.ZeroBytes(password)
 
         if err == nil {
             status = REGISTERING
@@ -888,146 +1161,33 @@ func RegisterServer(w http.ResponseWriter, req *http.Request) {
 
         req.ParseForm()
 
-        code := req.Form.Get("code")
+        code := req.PostForm.Get("code")
 
-        newToken, err := finishRegisterUser(username, code, password)
+        err := backend.FinishRegistration(username, code)
 
         if err != nil {
+            Debug.Println(err.Error())
             status = REGISTRATION_FAILED
             StartServer(w, req)
-        } else {
-            token = newToken
-            status = LOGGED_IN
-            HomeServer(w, req)
+            return
         }
-    }
-}
-
-type resetPasswordRequest struct {
-    UserName string
-}
-
-type resetPasswordResponseHeaders struct {
-    ContentType string `json:"Conten-Type"`
-}
-
-type resetPasswordResponseCodeDeliveryDetails struct {
-    Destination       string
-    DeliveryMechanism string
-    AttributeName     string
-}
-
-type resetPasswordResponseData struct {
-    CodeDeliveryDetails resetPasswordResponseCodeDeliveryDetails
-}
-
-type resetPasswordResponseBody struct {
-    Result string                    `json:"result"`
-    Data   resetPasswordResponseData `json:"data"`
-}
-
-type resetPasswordResponse struct {
-    StatusCode int                       `json:"statusCode"`
-    Headers    userResponseHeaders       `json:"headers"`
-    Body       resetPasswordResponseBody `json:"body"`
-}
 
-func startResetPassword(userName string) error {
-    var myError error
+        password, err := takePendingRegistration(w, req, username)
 
-     // Create request
-    request := resetPasswordRequest{userName}
-
-    payload, err := json.Marshal(request)
-
-    if err != nil {
-        myError = errors.New("Error marshalling StartChangingForgottenCognitoUserPassword request: " + err.Error())
-        return myError
-    }
-
-    svc := getLambdaClient()
-
-    result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("StartChangingForgottenCognitoUserPassword"), Payload: payload})
-
-    if err != nil {
-        myError = errors.New("Error calling StartChangingForgottenCognitoUserPassword: " + err.Error())
-        return myError
-    }
-
-    var resp resetPasswordResponse
-    err = json.Unmarshal(result.Payload, &resp)
-
-    if err != nil {
-        myError = errors.New("Error unmarshalling StartChangingForgottenCognitoUserPassword response: " + err.Error())
-        return myError
-    }
-
-    if resp.StatusCode == 200 {
-        if resp.Body.Result == "success" {
-            return myError
+        if err != nil {
+            // It expired, or they changed browsers, so they have to log in
+            Debug.Println("Could not get pending registration: " + err.Error())
+            status = REGISTERED
+            StartServer(w, req)
+            return
         }
-    }
-
-    return myError
-}
-
-type finishResetRequest struct {
-    UserName         string
-    ConfirmationCode string
-    NewPassword      string
-}
-
-func finishResetPassword(userName string, cc string, pw string) (string, error) {
-    var myError error
-    theToken := ""
-
-    // Create request
-    request := finishResetRequest{userName, cc, pw}
-
-    payload, err := json.Marshal(request)
-
-    if err != nil {
-        myError = errors.New("Error marshalling request for FinishChangingForgottenCognitoUserPassword: " + err.Error())
-        return theToken, myError
-    }
 
-    svc := getLambdaClient()
+        signedIn, err := backend.SignIn(username, password)
+        chatlib.ZeroBytes(password)
 
-    result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("FinishChangingForgottenCognitoUserPassword"), Payload: payload})
-
-    if err != nil {
-        myError = errors.New("Error calling FinishChangingForgottenCognitoUserPassword: " + err.Error())
-        return theToken, myError
-    }
-
-    var resp resetPasswordResponse
-    err = json.Unmarshal(result.Payload, &resp)
-
-    if err != nil {
-        myError = errors.New("Error unmarshalling FinishChangingForgottenCognitoUserPassword response: " + err.Error())
-        return theToken, myError
-    }
-
-    if resp.StatusCode == 200 {
-        if resp.Body.Result == "success" {
-            theToken, err := logInUser(username, pw)
-
-            if err != nil {
-                myError = errors.New("Error loggging in user in ??: " + err.Error())
-                return theToken, myError
-            }
-        }
-    } else {
-        var failResp registerUserResponseFailure
-        err = json.Unmarshal(result.Payload, &failResp)
-
-        if err == nil {
-            myError = errors.New("Got error message finishing password reset: " + failResp.Body.Error.Message)
-            return theToken, myError
-        }
+        // They're registered even if they can't log in
+        finishSignIn(w, req, signedIn, err, REGISTERED)
     }
-
-    return theToken, myError
 }
 
 func ResetServer(w http.ResponseWriter, req *http.Request) {
@@ -1044,18 +1204,19 @@ func ResetServer(w http.ResponseWriter, req *http.Request) {
         // Get request values
         req.ParseForm()    // Parses the request body
 
-        username = req.Form.Get("username")
+        username = userNamePolicy().Normalize(req.PostForm.Get("username"))
 
-        Debug.Println("Calling startResetPassword with:")
+        Debug.Println("Calling StartPasswordReset with:")
         Debug.Println("   Username: " + username)
 
-        err := startResetPassword(username)
+        delivery, err := backend.StartPasswordReset(username)
 
         if err != nil {
             // Start resetting failed, so shoot them back to start
             status = RESET_FAILED
             StartServer(w, req)
         } else {
+            setCodeMessage("We sent a confirmation code to", delivery, "your email address")
             status = RESETTING
             // StartServer sees
             // status == RESETTING
@@ -1068,221 +1229,324 @@ func ResetServer(w http.ResponseWriter, req *http.Request) {
 
         req.ParseForm()    // Parses the request body
 
-        password := req.Form.Get("password")
-        code := req.Form.Get("code")
+        password := []byte(req.PostForm.Get("password"))
+        code := req.PostForm.Get("code")
 
-        Debug.Println("Calling finishResetPassword with:")
+        Debug.Println("Calling FinishPasswordReset with:")
         Debug.Println("   Username:          " + username)
         Debug.Println("   Verification code: " + code)
-        Debug.Println("   Password:          " + password)
 
-        theToken, err := finishResetPassword(username, code, password)
+        var signedIn chatlib.SignInResult
 
-        if err == nil && theToken != "" {
-            token = theToken
-            status = LOGGED_IN
-            HomeServer(w, req)
-        } else {
-            status = RESET_FAILED
+        err := checkNewPassword(password)
+
+        if err != nil {
+            // Still resetting, so they can try another password
+            chatlib.ZeroBytes(password)
             StartServer(w, req)
+            return
         }
-    }
-}
-
-type deleteAccountRequest struct {
-    AccessToken string
-}
 
-func deleteUserAccount(accessToken string) error {
-    var myError error
+        err = backend.FinishPasswordReset(username, code, password)
 
-    token := deleteAccountRequest{accessToken}
+        if err == nil {
+            signedIn, err = backend.SignIn(username, password)
+        }
 
-    payload, err := json.Marshal(token)
+        chatlib.ZeroBytes(password)
 
-    if err != nil {
-        myError = errors.New("Error marshalling request for DeleteCognitoUser")
-        return myError
+        finishSignIn(w, req, signedIn, err, RESET_FAILED)
     }
+}
 
-    svc := getLambdaClient()
+// Send a new confirmation code to a user who is registering or resetting their password
+func ResendServer(w http.ResponseWriter, req *http.Request) {
+    Debug.Println("")
+    Debug.Println("ResendServer called with status: " + getStatusValue())
 
-    result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("DeleteCognitoUser"), Payload: payload})
+    var delivery chatlib.CodeDelivery
+    var err error
 
-    if err != nil {
-        myError = errors.New("Error calling DeleteCognitoUser")
-        return myError
+    switch status {
+    case REGISTERING:
+        delivery, err = backend.ResendConfirmationCode(username)
+    case RESETTING:
+        // Starting again sends a new code
+        delivery, err = backend.StartPasswordReset(username)
+    default:
+        // We aren't waiting for a code
+        StartServer(w, req)
+        return
     }
 
-    var resp userResponse
-    err = json.Unmarshal(result.Payload, &resp)
-
     if err != nil {
-        myError = errors.New("Error unmarshalling DeleteCognitoUser response")
-        return myError
-    }
-
-    // Did we not get a 200?
-    if resp.StatusCode != 200 {
-        myError = errors.New("Expected a 200 status code, but got: " + strconv.Itoa(resp.StatusCode))
+        Debug.Println("Could not send a new code: " + err.Error())
+        codeMessage = "<b>Could not send a new code!</b>"
+    } else {
+        setCodeMessage("We sent a new confirmation code to", delivery, "your email address")
     }
 
-    return myError
+    // Still waiting for a code, so StartServer shows the same form
+    StartServer(w, req)
 }
 
-func UnregisterServer(w http.ResponseWriter, req *http.Request) {
+// Email a user their user name.
+// We only show where it went, so nobody learns who has an account.
+func ForgotServer(w http.ResponseWriter, req *http.Request) {
     Debug.Println("")
-    Debug.Println("UnregisterServer called")
+    Debug.Println("ForgotServer called with status: " + getStatusValue())
 
-    err := deleteUserAccount(token)
+    switch status {
+    case NOT_LOGGED_IN:
+        req.ParseForm()    // Parses the request body
 
-    if err == nil {
-        token = ""
-        username = ""
-        password = ""
+        email := chatlib.NormalizeEmail(req.PostForm.Get("email"))
 
-        status = NOT_LOGGED_IN
+        var delivery chatlib.CodeDelivery
+        var err error
+
+        if problems := chatlib.CheckEmail(email); len(problems) > 0 {
+            setFailureMessage(strings.Join(problems, "; "))
+            err = errors.New("Email address is not valid")
+        } else {
+            Debug.Println("Calling RemindUserName")
+
+            delivery, err = backend.RemindUserName(email)
+        }
+
+        if err != nil {
+            Debug.Println("Could not send user name: " + err.Error())
+            status = USERNAME_FAILED
+        } else {
+            setCodeMessage("If that address belongs to an account, we sent its user name to", delivery, chatlib.MaskDestination(email))
+            status = USERNAME_SENT
+        }
+
+        StartServer(w, req)
+    default:
+        // They're logged in, or in the middle of registering or resetting
         StartServer(w, req)
     }
 }
 
-type postRequest struct {
-    AccessToken string
-    Message     string
-}
+func PostServer(w http.ResponseWriter, req *http.Request) {
+    Debug.Println("")
+    Debug.Println("PostServer called with status: " + getStatusValue())
 
-type postResponseBody struct {
-    Result string
-}
+    req.ParseForm()    // Parses the request body
 
-type finishSigninResponse struct {
-    StatusCode int                 `json:"statusCode"`
-    Headers    userResponseHeaders `json:"headers"`
-    Body       postResponseBody    `json:"body"`
+    message := req.PostForm.Get("message")
+
+    // Keep it in the outbox until the backend has it, after any posts already waiting
+    post := queuePost(roomName(), message)
+    _, err := sendOutbox()
+    postStatus(post.ID, err)
+
+    HomeServer(w, req)
 }
 
-func postFromSignedInUser(accessToken string, message string) error {
-    var myError error
+func DeleteServer(w http.ResponseWriter, req *http.Request) {
+    Debug.Println("")
+    Debug.Println("DeleteServer called with status: " + getStatusValue())
+
+    req.ParseForm()    // Parses the request body
 
-    request := postRequest{accessToken, message}
+    timestamp := req.PostForm.Get("message_value")
 
-    payload, err := json.Marshal(request)
+    err := backend.DeletePost(token, timestamp)
 
-    if err != nil {
-        myError = errors.New("Error marshalling request for AddPost: " + err.Error())
-        return myError
+    if err == nil {
+        status = MESSAGE_DELETED
+
+        // So searches and the history don't have it
+        key := chatlib.PostKey{Alias: username, Timestamp: timestamp}
+        unindexPost(key)
+        forgetPost(key)
+    } else {
+        status = MESSAGE_DELETE_FAILED
     }
 
-    svc := getLambdaClient()
+    HomeServer(w, req)
+}
 
-    result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("AddPost"), Payload: payload})
+// Replace the message of one of their posts; the backend keeps the old one
+func EditServer(w http.ResponseWriter, req *http.Request) {
+    Debug.Println("")
+    Debug.Println("EditServer called with status: " + getStatusValue())
 
-    if err != nil {
-        myError = errors.New("Error calling AddPost: " + err.Error())
-        return myError
+    if status == NOT_LOGGED_IN {
+        StartServer(w, req)
+        return
     }
 
-    var resp finishSigninResponse
+    req.ParseForm()    // Parses the request body
 
-    err = json.Unmarshal(result.Payload, &resp)
+    timestamp := req.PostForm.Get("message_value")
+    message := req.PostForm.Get("message")
 
-    if err != nil {
-        myError = errors.New("Error unmarshalling AddPost response: " + err.Error())
-        return myError
-    }
+    var err error
+
+    if timestamp == "" {
+        err = errors.New("Select one of your posts to edit")
+    } else {
+        Debug.Println("Calling EditPost")
 
-    // Make sure we got a 200 and success
-    if resp.StatusCode != 200 {
-        myError = errors.New("Got status code: " + strconv.Itoa(resp.StatusCode))
-        return myError
+        err = backend.EditPost(token, timestamp, message)
     }
 
-    if resp.Body.Result != "success" {
-        myError = errors.New("Bad result: " + resp.Body.Result)
-        return myError
+    if err == nil {
+        status = MESSAGE_EDITED
+    } else {
+        Debug.Println("Could not edit post: " + err.Error())
+        setFailureMessage(err.Error())
+        status = MESSAGE_EDIT_FAILED
     }
 
-    return myError
+    HomeServer(w, req)
 }
 
-func PostServer(w http.ResponseWriter, req *http.Request) {
+// Post a reply to the selected post
+func ReplyServer(w http.ResponseWriter, req *http.Request) {
     Debug.Println("")
-    Debug.Println("PostServer called with status: " + getStatusValue())
+    Debug.Println("ReplyServer called with status: " + getStatusValue())
+
+    if status == NOT_LOGGED_IN {
+        StartServer(w, req)
+        return
+    }
 
     req.ParseForm()    // Parses the request body
 
-    message := req.Form.Get("message")
+    parent := chatlib.PostKey{Alias: req.PostForm.Get("parent_alias"), Timestamp: req.PostForm.Get("parent_timestamp")}
+    message := req.PostForm.Get("message")
 
-    err := postFromSignedInUser(token, message)
+    var err error
 
-    if err != nil {
-        status = MESSAGE_FAILED
+    if parent.Alias == "" || parent.Timestamp == "" {
+        err = errors.New("Select the post to reply to")
+    } else if message == "" {
+        err = errors.New("Enter your reply")
     } else {
-        status = MESSAGE_POSTED
+        Debug.Println("Calling AddReply")
+
+        err = backend.AddReply(token, parent, message)
+    }
+
+    if err == nil {
+        status = REPLY_POSTED
+    } else {
+        Debug.Println("Could not post reply: " + err.Error())
+        setFailureMessage(err.Error())
+        status = REPLY_FAILED
     }
 
     HomeServer(w, req)
 }
 
-type deletePostRequest struct {
-    AccessToken     string
-    TimestampOfPost string
+// A reaction in the form that reacts to the selected post
+type ReactionChoice struct {
+    Name string
+    Emoji string
 }
 
-func deletePost(accessToken string, timestamp string) error {
-    var myError error
+func reactionChoices() []ReactionChoice {
+    var choices []ReactionChoice
 
-    req := deletePostRequest{accessToken, timestamp}
+    for _, name := range chatlib.ReactionNames {
+        choices = append(choices, ReactionChoice{Name: name, Emoji: chatlib.ReactionEmoji(name)})
+    }
 
-    payload, err := json.Marshal(req)
+    return choices
+}
 
-    if err != nil {
-        myError = errors.New("Error marshalling request for DeletePost")
-        return myError
+// React to the selected post or, if they already reacted that way, take the reaction back
+func ReactServer(w http.ResponseWriter, req *http.Request) {
+    Debug.Println("")
+    Debug.Println("ReactServer called with status: " + getStatusValue())
+
+    if status == NOT_LOGGED_IN {
+        StartServer(w, req)
+        return
+    }
+
+    req.ParseForm()    // Parses the request body
+
+    key := chatlib.PostKey{Alias: req.PostForm.Get("post_alias"), Timestamp: req.PostForm.Get("post_timestamp")}
+    reaction := chatlib.NormalizeReaction(req.PostForm.Get("reaction"))
+
+    var err error
+    var post chatlib.Post
+
+    if key.Alias == "" || key.Timestamp == "" {
+        err = errors.New("Select the post to react to")
+    } else if problem := chatlib.CheckReaction(reaction); problem != "" {
+        err = errors.New(problem)
+    } else {
+        // Find out whether they already reacted that way
+        post, err = findPost(key)
     }
 
-    svc := getLambdaClient()
+    if err == nil && post.Reacted(username, reaction) {
+        Debug.Println("Calling RemoveReaction")
 
-    result, err := svc.Invoke(&lambda.InvokeInput{FunctionName: aws.String("DeletePost"), Payload: payload})
+        err = backend.RemoveReaction(token, key, reaction)
+        status = REACTION_REMOVED
+    } else if err == nil {
+        Debug.Println("Calling AddReaction")
+
+        err = backend.AddReaction(token, key, reaction)
+        status = REACTION_ADDED
+    }
 
     if err != nil {
-        myError = errors.New("Error calling DeletePost")
-        return myError
+        Debug.Println("Could not react: " + err.Error())
+        setFailureMessage(err.Error())
+        status = REACTION_FAILED
     }
 
-    var resp userResponse
-    err = json.Unmarshal(result.Payload, &resp)
+    HomeServer(w, req)
+}
+
+// Find one of the posts in the room we're showing
+func findPost(key chatlib.PostKey) (chatlib.Post, error) {
+    posts, err := historyPosts(-1)
 
     if err != nil {
-        myError = errors.New("Error unmarshalling response from DeletePost")
-        return myError
+        return chatlib.Post{}, err
     }
 
-    // Did we get anything but a 200?
-    if resp.StatusCode != 200 {
-        myError = errors.New("Got bad status code: " + strconv.Itoa(resp.StatusCode))
+    for _, p := range posts {
+        if p.Key() == key {
+            return p, nil
+        }
     }
 
-    return myError
+    return chatlib.Post{}, errors.New("That post isn't among the posts in #" + roomName())
 }
 
-func DeleteServer(w http.ResponseWriter, req *http.Request) {
+// Hide the replies to the selected post, or show them again
+func ThreadServer(w http.ResponseWriter, req *http.Request) {
     Debug.Println("")
-    Debug.Println("DeleteServer called with status: " + getStatusValue())
+    Debug.Println("ThreadServer called with status: " + getStatusValue())
 
     req.ParseForm()    // Parses the request body
 
-    timestamp := req.Form.Get("message_value")
+    key := chatlib.PostKey{Alias: req.PostForm.Get("parent_alias"), Timestamp: req.PostForm.Get("parent_timestamp")}
 
-    err := deletePost(token, timestamp)
+    if key.Alias != "" && key.Timestamp != "" {
+        if collapsedThreads[key] {
+            delete(collapsedThreads, key)
+        } else {
+            collapsedThreads[key] = true
+        }
+    }
 
-    if err == nil {
-        status = MESSAGE_DELETED
+    // Back to the page they were on
+    if token == "" {
+        StartServer(w, req)
     } else {
-        status = MESSAGE_DELETE_FAILED
+        HomeServer(w, req)
     }
-
-    HomeServer(w, req)
 }
 
 func main() {
@@ -1294,7 +1558,9 @@ func main() {
     maxMsgsPtr := flag.Int("n", configuration.MaxMessages, "Maximum number of messages to download")
     refreshPtr := flag.Int("f", configuration.RefreshSeconds, "Duration, in seconds, between refreshing post list")
     debugPtr := flag.Bool("d", configuration.Debug, "Whether to show debug output")
+    backendPtr := flag.String("backend", configuration.Backend, "Where to keep users and posts: lambda or memory")
     helpPtr := flag.Bool("h", false, "Show usage")
+    flag.StringVar(&templatesDir, "templates-dir", "", "Folder of templates that override the built-in ones and are reloaded when they change")
 
     flag.Parse()
 
@@ -1304,6 +1570,7 @@ func main() {
     configuration.MaxMessages = *maxMsgsPtr
     configuration.RefreshSeconds = *refreshPtr
     configuration.Debug = *debugPtr
+    configuration.Backend = *backendPtr
 
     help := *helpPtr
 
@@ -1312,36 +1579,120 @@ func main() {
         os.Exit(0)
     }
 
-    if configuration.Debug {
-        initLog(os.Stderr)
-    } else {
-        initLog(ioutil.Discard)
+    initLog(ioutil.Discard)
+
+    err := validateConfiguration(configuration)
+
+    if err != nil {
+        log.Fatal(err.Error())
     }
 
+    setLogLevel(configuration)
+
     Debug.Println("Region:     " + configuration.Region)
     Debug.Println("Timezone:   " + configuration.Timezone)
     Debug.Println("Max # msgs: " + strconv.Itoa(configuration.MaxMessages))
     Debug.Println("Refresh:    " + strconv.Itoa(configuration.RefreshSeconds))
 
+    Debug.Println("Backend:    " + configuration.Backend)
+
+    backend, err = chatlib.NewBackend(configuration.Backend, configuration.Region, configuration.Functions, defaultRoom())
+
+    if err != nil {
+        log.Fatal(err.Error())
+    }
+
+    switch b := backend.(type) {
+    case *chatlib.LambdaBackend:
+        b.Debug = Debug
+    case *chatlib.MemoryBackend:
+        b.PasswordPolicy = passwordPolicy()
+
+        // There's no email, so log what we would have sent
+        b.Deliver = func(to string, subject string, body string) {
+            log.Println("(memory backend) To " + to + ": " + subject + ": " + body)
+        }
+
+        log.Println("Using the memory backend; users and posts are lost when the server stops")
+    }
+
+    // Let them know up front if any function is missing
+    missing, err := backend.Check()
+
+    if err != nil {
+        log.Println("Could not check the Lambda functions: " + err.Error())
+    }
+
+    for _, m := range missing {
+        log.Println("Lambda function does not exist in " + configuration.Region + ": " + m)
+    }
+
+    // The rest are optional; we hide what needs them
+    if unsupported := chatlib.Unsupported(backend); len(unsupported) > 0 {
+        log.Println("Optional Lambda functions that do not exist in " + configuration.Region + ", so what needs them is hidden: " + strings.Join(unsupported, ", "))
+    }
+
+    loadSearchIndex()
+    openHistory()
+    loadOutbox()
+
+    err = checkTemplatesDir()
+
+    if err != nil {
+        log.Fatal(err.Error())
+    }
+
     ParseTemplates()
 
+    if templatesDir != "" {
+        log.Println("Using templates in " + templatesDir + " and reloading them when they change")
+        go watchTemplates()
+    }
+
+    // Get the user pool's keys now, rather than when the first user logs in
+    checkVerifier()
+
+    // Apply changes to conf.json (or a SIGHUP) without restarting
+    go watchConfiguration()
+
     // When we start we aren't logged in, so tell them what to do
     status = NOT_LOGGED_IN
 
     Debug.Println("The initial status is: " + getStatusValue())
 
     // The same order as myapp.rb:
-    http.HandleFunc("/", StartServer)
-    http.HandleFunc("/about", AboutServer)
-    http.HandleFunc("/contact", ContactServer)
-    http.HandleFunc("/delete", DeleteServer)
-    http.HandleFunc("/home", HomeServer)
-    http.HandleFunc("/login", LoginServer)
-    http.HandleFunc("/logout", LogoutServer)
-    http.HandleFunc("/post", PostServer)
-    http.HandleFunc("/register", RegisterServer)
-    http.HandleFunc("/reset", ResetServer)
-    http.HandleFunc("/unregister", UnregisterServer)
+    http.HandleFunc("/", handle(http.MethodGet, StartServer))
+    http.HandleFunc("/about", handle(http.MethodGet, AboutServer))
+    http.HandleFunc("/challenge", handle(http.MethodPost, ChallengeServer))
+    http.HandleFunc("/contact", handle(http.MethodGet, ContactServer))
+    http.HandleFunc("/delete", handle(http.MethodPost, DeleteServer))
+    http.HandleFunc("/disablemfa", handle(http.MethodPost, DisableMFAServer))
+    http.HandleFunc("/dm", handle(http.MethodPost, MessageServer))
+    http.HandleFunc("/dm/", handle(http.MethodGet, DirectServer))
+    http.HandleFunc("/edit", handle(http.MethodPost, EditServer))
+    http.HandleFunc("/email", handle(http.MethodPost, EmailServer))
+    http.HandleFunc("/export", handle(http.MethodPost, ExportServer))
+    http.HandleFunc("/forgot", handle(http.MethodPost, ForgotServer))
+    http.HandleFunc("/home", handle(http.MethodGet, HomeServer))
+    http.HandleFunc("/login", handle(http.MethodPost, LoginServer))
+    http.HandleFunc("/logout", handle(http.MethodPost, LogoutServer))
+    http.HandleFunc("/mentions", handle(http.MethodGet, MentionsServer))
+    http.HandleFunc("/mfa", handle(http.MethodPost, MFAServer))
+    http.HandleFunc("/outbox", handle(http.MethodPost, OutboxServer))
+    http.HandleFunc("/password", handle(http.MethodPost, PasswordServer))
+    http.HandleFunc("/post", handle(http.MethodPost, PostServer))
+    http.HandleFunc("/react", handle(http.MethodPost, ReactServer))
+    http.HandleFunc("/register", handle(http.MethodPost, RegisterServer))
+    http.HandleFunc("/reply", handle(http.MethodPost, ReplyServer))
+    http.HandleFunc("/resend", handle(http.MethodPost, ResendServer))
+    http.HandleFunc("/reset", handle(http.MethodPost, ResetServer))
+    http.HandleFunc("/rooms", handle(http.MethodPost, RoomsServer))
+    http.HandleFunc("/rooms/", handle(http.MethodGet, RoomServer))
+    http.HandleFunc("/search", handle(http.MethodGet, SearchServer))
+    http.HandleFunc("/static/", handleStatic(StaticServer))
+    http.HandleFunc("/theme", handle(http.MethodPost, ThemeServer))
+    http.HandleFunc("/thread", handle(http.MethodPost, ThreadServer))
+    http.HandleFunc("/unregister", handle(http.MethodPost, UnregisterServer))
 
 	// Get port # from environemt or use 12345
 	port := os.Getenv("PORT")
@@ -1350,7 +1701,12 @@ func main() {
 		port = ":12345"
 	}
 
-    err := http.ListenAndServe(port, nil)
+    if configuration.TLSCertFile != "" {
+        log.Println("Listening for HTTPS on " + port)
+        err = http.ListenAndServeTLS(port, configuration.TLSCertFile, configuration.TLSKeyFile, nil)
+    } else {
+        err = http.ListenAndServe(port, nil)
+    }
 
     if err != nil {
         log.Fatal("ListenAndServe returned error: ", err)
@@ -0,0 +1,198 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Mentions:
+
+  posts.tmpl shows each @name of a known user in a <span class="mention">,
+  and highlights the posts that mention the logged-in user.
+
+  Once they log in, the sidebar links to /mentions (MentionsServer),
+  which lists the posts, in any room, that mention them since they last looked,
+  and says how many there are. /mentions?all=1 lists every one we find.
+  MentionsFile from conf.json keeps, for each user, the newest mention they've seen.
+*/
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+// Where we save which mentions each user has seen
+func mentionsFile() string {
+	file := currentConfiguration().MentionsFile
+
+	if file == "" {
+		return "mentions.json"
+	}
+
+	return file
+}
+
+// Get the users that @names can mention: the ones the backend lists,
+// and, in case it can't, the authors of posts
+func knownUsers(posts []chatlib.Post) *chatlib.KnownUsers {
+	known := chatlib.NewKnownUsers(userNamePolicy())
+
+	names, err := backend.ListUserNames()
+
+	if err != nil {
+		Debug.Println("Could not get user names: " + err.Error())
+	}
+
+	known.Add(names...)
+
+	for _, p := range posts {
+		known.Add(p.Alias)
+	}
+
+	return known
+}
+
+// Escape message, and put each mention of a known user in a span
+func mentionsHTML(message string, known *chatlib.KnownUsers) string {
+	var html strings.Builder
+
+	for _, part := range chatlib.SplitMentions(message, known.Has) {
+		if part.Mention != "" {
+			html.WriteString(`<span class="mention">` + template.HTMLEscapeString(part.Text) + `</span>`)
+		} else {
+			html.WriteString(template.HTMLEscapeString(part.Text))
+		}
+	}
+
+	return html.String()
+}
+
+// Get the posts that mention the logged-in user since they last looked,
+// or, if all is true, every one we find, and the markers of what everyone has seen
+func findMentions(all bool) ([]chatlib.Post, chatlib.MentionMarkers, error) {
+	markers, err := chatlib.LoadMentionMarkers(mentionsFile())
+
+	if err != nil {
+		Debug.Println(err.Error())
+	}
+
+	since := markers[username]
+
+	if all {
+		since = ""
+	}
+
+	posts, err := chatlib.FindMentions(backend, userNamePolicy(), username, since, currentConfiguration().MaxMessages)
+
+	return posts, markers, err
+}
+
+// How many posts mention the logged-in user since they last looked, for the sidebar
+func newMentions() int {
+	posts, _, err := findMentions(false)
+
+	if err != nil {
+		Debug.Println("Could not get mentions: " + err.Error())
+		return 0
+	}
+
+	return len(posts)
+}
+
+// A post in the list of mentions
+type MentionEntry struct {
+	Alias string
+	Date  string
+	Room  string
+	URL   string
+	// The message, escaped, with its mentions in spans
+	MessageHTML string
+}
+
+type MentionsContext struct {
+	Mentions []MentionEntry
+	// True if Mentions is every one we found, not just the new ones
+	All bool
+	// The room to go back to
+	Room string
+}
+
+// List the posts that mention the logged-in user: /mentions, or /mentions?all=1
+func MentionsServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("MentionsServer called with status: " + getStatusValue())
+
+	if token == "" {
+		StartServer(w, req)
+		return
+	}
+
+	all := req.URL.Query().Get("all") != ""
+	message := ""
+
+	posts, markers, err := findMentions(all)
+
+	if err != nil {
+		Debug.Println("Could not get mentions: " + err.Error())
+		message = "<b>Could not get your mentions!</b> " + template.HTMLEscapeString(err.Error())
+	} else if len(posts) == 1 {
+		message = "1 post mentions you"
+	} else {
+		message = strconv.Itoa(len(posts)) + " posts mention you"
+	}
+
+	if err == nil && !all {
+		message += " since you last looked"
+	}
+
+	context := MentionsContext{All: all, Room: roomName()}
+	known := knownUsers(posts)
+
+	for _, p := range posts {
+		entry := MentionEntry{Alias: p.Alias, Room: p.Room, URL: "/rooms/" + p.Room, MessageHTML: mentionsHTML(p.Message, known)}
+
+		numTime, err := strconv.ParseInt(p.Timestamp, 10, 64)
+
+		if err == nil {
+			thisTime := time.Unix(numTime, 0)
+			entry.Date = FormatAsDate(thisTime).String() + " " + FormatAsTime(thisTime).String()
+		} else {
+			entry.Date = "???"
+		}
+
+		context.Mentions = append(context.Mentions, entry)
+	}
+
+	// They've seen these now
+	if markers.MarkSeen(username, posts) {
+		err = markers.Save(mentionsFile())
+
+		if err != nil {
+			log.Println("Could not save which mentions " + username + " has seen: " + err.Error())
+		}
+	}
+
+	theme := requestTheme(req)
+	headerContext := HeaderContext{Message: message, Title: "Mentions - " + theme.Title, Theme: theme}
+
+	s1 := lookupTemplate("header.tmpl")
+	s1.Execute(w, headerContext)
+
+	// Instead of posts.tmpl and home.tmpl
+	s2 := lookupTemplate("mentions.tmpl")
+	s2.Execute(w, context)
+
+	s3 := lookupTemplate("footer.tmpl")
+	s3.Execute(w, newFooterContext(req))
+}
@@ -0,0 +1,164 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Setting up an authenticator app:
+
+  1. The profile panel has a Set Up Authenticator App button,
+     which posts to /mfa (MFAServer). MFAServer gets a secret from the backend
+     and sets the status to 'Setting up MFA'.
+  2. HomeServer sees that status and shows mfa.tmpl instead of home.tmpl:
+     a QR code of the otpauth:// URI, the secret for typing in,
+     and a field for the app's first code, which posts to /mfa again.
+  3. MFAServer checks the code; if it's right, the app is the user's MFA
+     and the status is 'MFA turned on'. A wrong code leaves
+     the status alone, so they can try the next code.
+
+  Once MFA is on, the profile panel has a Turn Off MFA button instead,
+  which posts to /disablemfa (DisableMFAServer).
+*/
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+// The secret of the authenticator app the user is adding,
+// until they enter its first code
+var totpSecret string
+
+// How many wrong codes they entered for totpSecret
+var totpAttempts int
+
+// How big the QR code is, in pixels
+const qrCodeSize = 256
+
+// What authenticator apps call this app
+func totpIssuer() string {
+	issuer := currentConfiguration().TOTPIssuer
+
+	if issuer == "" {
+		return chatlib.DefaultTOTPIssuer
+	}
+
+	return issuer
+}
+
+type MFAContext struct {
+	CSRFToken string
+	// The QR code, as a data: URI for an img
+	QRCode string
+	// The secret, in groups of 4 characters
+	Secret string
+}
+
+func newMFAContext(req *http.Request) MFAContext {
+	context := MFAContext{CSRFToken: getSession(req).CSRFToken, Secret: chatlib.FormatTOTPSecret(totpSecret)}
+
+	png, err := chatlib.QRCodePNG(chatlib.TOTPURI(totpIssuer(), username, totpSecret), qrCodeSize)
+
+	if err != nil {
+		// They can still type in the secret
+		Debug.Println(err.Error())
+	} else {
+		context.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	}
+
+	return context
+}
+
+// Start adding an authenticator app, or check its first code
+func MFAServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("MFAServer called with status: " + getStatusValue())
+
+	switch status {
+	case NOT_LOGGED_IN:
+		StartServer(w, req)
+	case MFA_ENROLLING:
+		req.ParseForm() // Parses the request body
+
+		if req.PostForm.Get("cancel") != "" {
+			totpSecret = ""
+			status = LOGGED_IN
+			HomeServer(w, req)
+			return
+		}
+
+		code := req.PostForm.Get("code")
+
+		Debug.Println("Calling FinishTOTPEnrollment")
+
+		err := backend.FinishTOTPEnrollment(token, code)
+
+		if err != nil {
+			totpAttempts++
+		}
+
+		if err == nil {
+			totpSecret = ""
+			status = MFA_ENABLED
+		} else if totpAttempts < maxChallengeAttempts && chatlib.ErrorCode(err) == "EnableSoftwareTokenMFAException" {
+			// Still setting up, so they can try the next code
+			failureMessage = "That code didn't match; wait for the next one and try again."
+		} else {
+			Debug.Println("Could not set up MFA: " + err.Error())
+			setFailureMessage(err.Error())
+			totpSecret = ""
+			status = MFA_FAILED
+		}
+
+		HomeServer(w, req)
+	default:
+		Debug.Println("Calling StartTOTPEnrollment")
+
+		secret, err := backend.StartTOTPEnrollment(token)
+
+		if err != nil {
+			Debug.Println("Could not set up MFA: " + err.Error())
+			setFailureMessage(err.Error())
+			status = MFA_FAILED
+		} else {
+			totpSecret = secret
+			totpAttempts = 0
+			status = MFA_ENROLLING
+		}
+
+		HomeServer(w, req)
+	}
+}
+
+// Turn off MFA, so logging in only takes a password
+func DisableMFAServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("DisableMFAServer called with status: " + getStatusValue())
+
+	if status == NOT_LOGGED_IN {
+		StartServer(w, req)
+		return
+	}
+
+	Debug.Println("Calling DisableMFA")
+
+	err := backend.DisableMFA(token)
+
+	if err != nil {
+		Debug.Println("Could not turn off MFA: " + err.Error())
+		setFailureMessage(err.Error())
+		status = MFA_FAILED
+	} else {
+		status = MFA_DISABLED
+	}
+
+	HomeServer(w, req)
+}
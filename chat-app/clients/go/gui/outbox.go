@@ -0,0 +1,348 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  The outbox:
+
+  PostServer puts each post in a chatlib.Outbox, saved in OutboxFile,
+  before it sends it, so a post written when the server can't be reached isn't lost.
+  Each time we show the posts, we send the logged-in user's posts
+  that are waiting, oldest first; the backend only adds each one once.
+  Posts go a second apart, so the ones that can't go right away
+  are sent by drainOutbox, in the background, rather than holding up the page.
+  Only one of them sends at a time, and outboxMutex isn't held while a post
+  is on its way, so a slow server doesn't hold up the pages that show the outbox.
+
+  Under the posts, posts.tmpl lists the ones in the room that haven't been sent,
+  pending or failed, each with buttons that go to /outbox (OutboxServer)
+  to send it now or throw it away.
+*/
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+// Posts we haven't sent yet, and who's using them:
+// a page can send them while another posts
+var outbox = &chatlib.Outbox{}
+var outboxMutex sync.Mutex
+
+// Where we keep the posts we haven't sent yet
+func outboxFile() string {
+	file := currentConfiguration().OutboxFile
+
+	if file == "" {
+		return "outbox.json"
+	}
+
+	return file
+}
+
+// Read the posts that weren't sent the last time the app ran
+func loadOutbox() {
+	var err error
+
+	outbox, err = chatlib.LoadOutbox(outboxFile())
+
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// Call with outboxMutex locked
+func saveOutbox() {
+	err := outbox.Save(outboxFile())
+
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// True while a page or drainOutbox sends posts, so only one does at a time
+// and no post is sent twice; use with outboxMutex locked
+var sending bool
+
+// While drainOutbox runs, closing this stops it, such as when the user logs out;
+// use with outboxMutex locked
+var drainStop chan struct{}
+
+// Send the logged-in user's posts that are waiting, if there are any,
+// as many as can go without waiting; drainOutbox sends the rest, a second apart,
+// so a page doesn't wait on them.
+// Returns how many were sent.
+func sendOutbox() (int, error) {
+	alias := username
+	accessToken := token
+
+	outboxMutex.Lock()
+
+	// drainOutbox sends them if it's running
+	if accessToken == "" || sending || drainStop != nil || outbox.Pending(alias) == 0 {
+		outboxMutex.Unlock()
+		return 0, nil
+	}
+
+	sending = true
+	outboxMutex.Unlock()
+
+	Debug.Println("Sending outbox")
+
+	sent, err := sendReady(alias, accessToken, nil)
+
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+
+	sending = false
+
+	if err == nil && outbox.Pending(alias) > 0 {
+		drainStop = make(chan struct{})
+		go drainOutbox(alias, accessToken, drainStop)
+	}
+
+	return sent, err
+}
+
+// Send alias's pending posts that can go without waiting, oldest first,
+// until one can't reach the server or stop is closed.
+// Call with sending set; outboxMutex is only locked between posts,
+// so pages can use the outbox while a post is on its way.
+// Returns how many were sent.
+func sendReady(alias string, accessToken string, stop chan struct{}) (int, error) {
+	sent := 0
+
+	for {
+		outboxMutex.Lock()
+		p, ok := outbox.Next(alias)
+		wait := outbox.Wait()
+		outboxMutex.Unlock()
+
+		select {
+		case <-stop:
+			return sent, nil
+		default:
+		}
+
+		if !ok || wait > 0 {
+			return sent, nil
+		}
+
+		p, err := chatlib.SendPost(backend, accessToken, p)
+
+		outboxMutex.Lock()
+		outbox.Update(p, err)
+		saveOutbox()
+		outboxMutex.Unlock()
+
+		if err == nil {
+			sent++
+		} else if chatlib.Unreachable(err) {
+			return sent, err
+		}
+	}
+}
+
+// Send alias's posts that are waiting, a second apart, until they're all sent,
+// the server can't be reached, or stop is closed
+func drainOutbox(alias string, accessToken string, stop chan struct{}) {
+	for {
+		outboxMutex.Lock()
+		wait := outbox.Wait()
+		outboxMutex.Unlock()
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+
+		outboxMutex.Lock()
+
+		if drainStop != stop {
+			// They logged out
+			outboxMutex.Unlock()
+			return
+		}
+
+		if outbox.Pending(alias) == 0 {
+			drainStop = nil
+			outboxMutex.Unlock()
+			return
+		}
+
+		sending = true
+		outboxMutex.Unlock()
+
+		sent, err := sendReady(alias, accessToken, stop)
+
+		outboxMutex.Lock()
+		sending = false
+
+		if err != nil && drainStop == stop {
+			drainStop = nil
+		}
+
+		outboxMutex.Unlock()
+
+		if err != nil {
+			Debug.Println("Could not send outbox: " + err.Error())
+			return
+		}
+
+		Debug.Println("Sent " + strconv.Itoa(sent) + " posts from the outbox")
+	}
+}
+
+// Stop sending posts in the background, such as when the user logs out
+func stopOutbox() {
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+
+	if drainStop != nil {
+		close(drainStop)
+		drainStop = nil
+	}
+}
+
+// Put a post in the outbox, and save it, before we send it
+func queuePost(room string, message string) chatlib.OutboxPost {
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+
+	post := outbox.Add(username, room, message)
+	saveOutbox()
+
+	return post
+}
+
+// Set the status for a post we tried to send: posted, still waiting, or refused
+func postStatus(id string, err error) {
+	outboxMutex.Lock()
+	waiting, ok := outbox.Get(username, id)
+	outboxMutex.Unlock()
+
+	switch {
+	case !ok:
+		status = MESSAGE_POSTED
+	case waiting.Failed:
+		setFailureMessage(waiting.LastError + " (the post is in your outbox, under the posts, so you can retry or discard it)")
+		status = MESSAGE_FAILED
+	case err == nil:
+		// It's behind others, which go a second apart
+		setFailureMessage("The post is in your outbox, and it's sent in a few seconds; posts go a second apart")
+		status = MESSAGE_QUEUED
+	default:
+		setFailureMessage("Could not reach the server, so the post is in your outbox; it's sent once the server can be reached: " + err.Error())
+		status = MESSAGE_QUEUED
+	}
+}
+
+// A post in the room that hasn't been sent
+type OutboxEntry struct {
+	ID      string
+	Message string
+	State   string
+	// When they wrote it, and why it wasn't sent
+	Date string
+	Why  string
+}
+
+// The logged-in user's posts in the room that haven't been sent, oldest first
+func outboxEntries() []OutboxEntry {
+	if token == "" {
+		return nil
+	}
+
+	outboxMutex.Lock()
+	posts := outbox.For(username)
+	outboxMutex.Unlock()
+
+	var entries []OutboxEntry
+
+	for _, p := range posts {
+		if p.Room != roomName() {
+			continue
+		}
+
+		entry := OutboxEntry{ID: p.ID, Message: p.Message, State: p.State(), Why: p.LastError}
+
+		numTime, err := strconv.ParseInt(p.Created, 10, 64)
+
+		if err == nil {
+			thisTime := time.Unix(numTime, 0)
+			entry.Date = FormatAsDate(thisTime).String() + " " + FormatAsTime(thisTime).String()
+		} else {
+			entry.Date = "???"
+		}
+
+		if p.Attempts > 1 {
+			entry.Why += " (" + strconv.Itoa(p.Attempts) + " tries)"
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// Send one of their posts in the outbox now, or throw it away
+func OutboxServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("OutboxServer called with status: " + getStatusValue())
+
+	if status == NOT_LOGGED_IN {
+		StartServer(w, req)
+		return
+	}
+
+	req.ParseForm() // Parses the request body
+
+	id := req.PostForm.Get("id")
+
+	switch req.PostForm.Get("action") {
+	case "discard":
+		outboxMutex.Lock()
+		err := outbox.Discard(username, id)
+		saveOutbox()
+		outboxMutex.Unlock()
+
+		if err != nil {
+			setFailureMessage(err.Error())
+			status = MESSAGE_FAILED
+		} else {
+			status = OUTBOX_DISCARDED
+		}
+	case "retry":
+		outboxMutex.Lock()
+		err := outbox.Retry(username, id)
+		saveOutbox()
+		outboxMutex.Unlock()
+
+		if err != nil {
+			setFailureMessage(err.Error())
+			status = MESSAGE_FAILED
+			break
+		}
+
+		_, err = sendOutbox()
+		postStatus(id, err)
+	default:
+		setFailureMessage("Pick retry or discard")
+		status = MESSAGE_FAILED
+	}
+
+	HomeServer(w, req)
+}
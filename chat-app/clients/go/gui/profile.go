@@ -0,0 +1,114 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Profile:
+
+  Once a user logs in, the bottom of the home page (profile.tmpl) shows
+  who they are, from their tokens; when their tokens expire;
+  whether they can be refreshed; the Region and functions we're using;
+  and how many of the posts on the page are theirs;
+  and whether they log in with MFA, with a button to set it up or turn it off.
+*/
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+type ProfileContext struct {
+	CSRFToken  string
+	UserName   string
+	Email      string
+	Expiry     string
+	Refresh    string
+	Region     string
+	UserPoolID string
+	Deployment []string
+	Posts      int
+	// How many posts are on the page
+	Window int
+	// False if the values come from tokens we could not check
+	Verified bool
+	// What they need besides their password to log in,
+	// and whether that's an MFA code, so they can turn it off
+	MFA   string
+	MFAOn bool
+}
+
+// Describe the logged-in user, and how many of posts are theirs
+func newProfileContext(req *http.Request, posts []PostEntry) ProfileContext {
+	var id chatlib.Claims
+
+	// checkSession already made sure the access token is good
+	access, _ := verifyAccessToken(token)
+
+	v, _ := getVerifier()
+
+	if idToken != "" {
+		if v != nil {
+			id, _ = v.VerifyIDToken(idToken)
+		} else {
+			id, _ = chatlib.DecodeClaims(idToken)
+		}
+	}
+
+	c := currentConfiguration()
+
+	profile := chatlib.NewProfile(access, id, v != nil)
+	profile.HasRefreshToken = refreshToken != ""
+	profile.Region = c.Region
+	profile.Deployment = backend.Deployment()
+
+	mfa, err := backend.GetMFA(token)
+
+	if err != nil {
+		Debug.Println("Could not get MFA: " + err.Error())
+		mfa = chatlib.MFAUnknown
+	}
+
+	profile.MFA = mfa
+
+	window := 0
+
+	for _, post := range posts {
+		// Skip the date lines
+		if post.Alias == "" {
+			continue
+		}
+
+		window++
+
+		if post.Alias == profile.UserName {
+			profile.Posts++
+		}
+	}
+
+	now := time.Now()
+
+	return ProfileContext{
+		CSRFToken:  getSession(req).CSRFToken,
+		UserName:   profile.UserName,
+		Email:      profile.EmailStatus(),
+		Expiry:     profile.ExpiryStatus(now),
+		Refresh:    profile.RefreshStatus(now),
+		Region:     profile.Region,
+		UserPoolID: c.UserPoolID,
+		Deployment: profile.Deployment,
+		Posts:      profile.Posts,
+		Window:     window,
+		Verified:   profile.Verified,
+		MFA:        profile.MFAStatus(),
+		MFAOn:      profile.MFA != "" && profile.MFA != chatlib.MFAUnknown,
+	}
+}
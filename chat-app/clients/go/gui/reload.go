@@ -0,0 +1,287 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Reloading the configuration:
+
+  watchConfiguration reloads conf.json when the file changes
+  or when the server gets a SIGHUP.
+  A reload reads conf.json, re-applies any command-line options,
+  validates the result, and re-parses the templates.
+  Only if all of that succeeds does it swap in the new values,
+  so a bad file leaves the running server untouched.
+
+  The following take effect immediately:
+    MaxMessages, RefreshSeconds, Debug, LogLevel, Theme, Themes, StaticDir,
+    TOTPIssuer, MentionsFile, SearchIndexFile, and the templates.
+  The mention markers are read from MentionsFile each time they're used,
+  and a new SearchIndexFile is read in place of the search index we have,
+  so neither carries the old file's state into the new one.
+  Region, Timezone, Backend, Functions, PasswordPolicy, UserNamePolicy,
+  UserPoolID, ClientID, JWKSFile, HistoryFile, OutboxFile, and DefaultRoom
+  (which the backend uses to know which room is everyone's) require a restart.
+
+  Sessions are never touched by a reload.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+// How often we check whether conf.json has changed
+const configPollInterval = 2 * time.Second
+
+// Guards the values a reload can change (configuration and templates)
+var reloadMutex sync.RWMutex
+
+// Get a copy of the configuration;
+// safe to call while the configuration is being reloaded
+func currentConfiguration() Configuration {
+	reloadMutex.RLock()
+	defer reloadMutex.RUnlock()
+
+	return configuration
+}
+
+func validateConfiguration(c Configuration) error {
+	if c.Region == "" {
+		return errors.New("Region must not be empty")
+	}
+
+	if c.MaxMessages < 1 {
+		return errors.New("MaxMessages must be at least 1, not " + strconv.Itoa(c.MaxMessages))
+	}
+
+	if c.RefreshSeconds < 1 {
+		return errors.New("RefreshSeconds must be at least 1, not " + strconv.Itoa(c.RefreshSeconds))
+	}
+
+	switch c.Backend {
+	case "", chatlib.LambdaBackendName, chatlib.MemoryBackendName:
+	default:
+		return errors.New("Backend must be " + chatlib.LambdaBackendName + " or " + chatlib.MemoryBackendName + ", not " + c.Backend)
+	}
+
+	// Amazon Cognito allows 6 to 99; 0 means the default policy
+	if c.PasswordPolicy.MinimumLength != 0 && (c.PasswordPolicy.MinimumLength < 6 || c.PasswordPolicy.MinimumLength > 99) {
+		return errors.New("PasswordPolicy.MinimumLength must be from 6 to 99, not " + strconv.Itoa(c.PasswordPolicy.MinimumLength))
+	}
+
+	names := c.UserNamePolicy.OrDefault()
+
+	// Amazon Cognito allows user names of up to 128 characters
+	if names.MinimumLength < 1 || names.MaximumLength > 128 || names.MinimumLength > names.MaximumLength {
+		return errors.New("UserNamePolicy must have a MinimumLength of at least 1 and a MaximumLength of at most 128, not " +
+			strconv.Itoa(names.MinimumLength) + " and " + strconv.Itoa(names.MaximumLength))
+	}
+
+	switch c.LogLevel {
+	case "", "debug", "info", "error":
+	default:
+		return errors.New("LogLevel must be debug, info, or error, not " + c.LogLevel)
+	}
+
+	return validateThemes(c)
+}
+
+// Send Debug and Info output to the console, or nowhere, based on the log level.
+// Debug (-d) is the same as a log level of debug.
+func setLogLevel(c Configuration) {
+	level := c.LogLevel
+
+	if c.Debug {
+		level = "debug"
+	}
+
+	switch level {
+	case "debug":
+		Debug.SetOutput(os.Stderr)
+		Info.SetOutput(os.Stdout)
+	case "error":
+		Debug.SetOutput(ioutil.Discard)
+		Info.SetOutput(ioutil.Discard)
+	default:
+		Debug.SetOutput(ioutil.Discard)
+		Info.SetOutput(os.Stdout)
+	}
+}
+
+// Command-line options win over conf.json,
+// so re-apply the ones that were set
+func applyFlags(c *Configuration) {
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.(flag.Getter).Get()
+
+		switch f.Name {
+		case "r":
+			c.Region = value.(string)
+		case "t":
+			c.Timezone = value.(string)
+		case "n":
+			c.MaxMessages = value.(int)
+		case "f":
+			c.RefreshSeconds = value.(int)
+		case "d":
+			c.Debug = value.(bool)
+		case "backend":
+			c.Backend = value.(string)
+		}
+	})
+}
+
+// Read and validate a configuration file
+func loadConfiguration(filename string) (Configuration, error) {
+	var c Configuration
+
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return c, errors.New("Error opening " + filename + ": " + err.Error())
+	}
+
+	defer file.Close()
+
+	err = json.NewDecoder(file).Decode(&c)
+
+	if err != nil {
+		return c, errors.New("Error parsing " + filename + ": " + err.Error())
+	}
+
+	applyFlags(&c)
+
+	err = validateConfiguration(c)
+
+	if err != nil {
+		return c, errors.New("Invalid configuration in " + filename + ": " + err.Error())
+	}
+
+	return c, nil
+}
+
+// Load conf.json and the templates and, if both are good, start using them
+func reloadConfiguration() error {
+	newConfiguration, err := loadConfiguration(configFile)
+
+	if err != nil {
+		return err
+	}
+
+	newTemplates, err := parseTemplateFiles()
+
+	if err != nil {
+		return err
+	}
+
+	reloadMutex.Lock()
+
+	oldConfiguration := configuration
+
+	configuration.MaxMessages = newConfiguration.MaxMessages
+	configuration.RefreshSeconds = newConfiguration.RefreshSeconds
+	configuration.Debug = newConfiguration.Debug
+	configuration.LogLevel = newConfiguration.LogLevel
+	configuration.Theme = newConfiguration.Theme
+	configuration.Themes = newConfiguration.Themes
+	configuration.StaticDir = newConfiguration.StaticDir
+	configuration.TOTPIssuer = newConfiguration.TOTPIssuer
+	configuration.MentionsFile = newConfiguration.MentionsFile
+	configuration.SearchIndexFile = newConfiguration.SearchIndexFile
+	templates = newTemplates
+
+	reloadMutex.Unlock()
+
+	setLogLevel(newConfiguration)
+
+	if newConfiguration.Region != oldConfiguration.Region {
+		log.Println("Region changed to " + newConfiguration.Region + "; restart the server to use it")
+	}
+
+	if newConfiguration.Backend != oldConfiguration.Backend {
+		log.Println("Backend changed to " + newConfiguration.Backend + "; restart the server to use it")
+	}
+
+	if newConfiguration.SearchIndexFile != oldConfiguration.SearchIndexFile {
+		log.Println("SearchIndexFile changed to " + newConfiguration.SearchIndexFile + "; reading the search index from it")
+		loadSearchIndex()
+	}
+
+	if newConfiguration.DefaultRoom != oldConfiguration.DefaultRoom {
+		log.Println("DefaultRoom changed to " + newConfiguration.DefaultRoom + "; restart the server to use it")
+	}
+
+	if newConfiguration.Timezone != oldConfiguration.Timezone {
+		log.Println("Timezone changed to " + newConfiguration.Timezone + "; restart the server to use it")
+	}
+
+	log.Println("Reloaded configuration:" +
+		" MaxMessages=" + strconv.Itoa(newConfiguration.MaxMessages) +
+		" RefreshSeconds=" + strconv.Itoa(newConfiguration.RefreshSeconds) +
+		" Debug=" + strconv.FormatBool(newConfiguration.Debug) +
+		" LogLevel=" + newConfiguration.LogLevel +
+		" Theme=" + newConfiguration.Theme)
+
+	return nil
+}
+
+func modTime(filename string) time.Time {
+	info, err := os.Stat(filename)
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+// Reload the configuration whenever conf.json changes or we get a SIGHUP.
+// Never returns.
+func watchConfiguration() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	ticker := time.NewTicker(configPollInterval)
+	lastModified := modTime(configFile)
+
+	for {
+		select {
+		case <-hangups:
+			log.Println("Got SIGHUP, reloading " + configFile)
+
+		case <-ticker.C:
+			modified := modTime(configFile)
+
+			if modified.Equal(lastModified) {
+				continue
+			}
+
+			lastModified = modified
+			log.Println(configFile + " changed, reloading it")
+		}
+
+		err := reloadConfiguration()
+
+		if err != nil {
+			log.Println("Reload failed, still using the previous configuration: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,162 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Chat rooms:
+
+  Every page lists the rooms in a sidebar, from posts.tmpl.
+  Each room has its own URL, /rooms/NAME (RoomServer),
+  which shows that room's posts; posting goes to the room shown last.
+  The app starts in DefaultRoom from conf.json.
+
+  Once they log in, users can create a room, or join the one they're in,
+  so they can post to it, by posting to /rooms (RoomsServer).
+*/
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+// The room we show and post to; empty until they pick one
+var currentRoom string
+
+// The room the app starts in
+func defaultRoom() string {
+	room := chatlib.NormalizeRoomName(currentConfiguration().DefaultRoom)
+
+	if room == "" {
+		return chatlib.DefaultRoom
+	}
+
+	return room
+}
+
+// The room we show and post to
+func roomName() string {
+	if currentRoom == "" {
+		return defaultRoom()
+	}
+
+	return currentRoom
+}
+
+// A room in the sidebar
+type RoomEntry struct {
+	Name string
+	URL  string
+	// True for the room we're showing
+	Current bool
+	// True if the logged-in user can post to it
+	Joined bool
+}
+
+// Get the rooms for the sidebar; userName is empty unless they're logged in
+func roomEntries(userName string) []RoomEntry {
+	rooms, err := backend.ListRooms()
+
+	if err != nil {
+		Debug.Println("Could not get rooms: " + err.Error())
+		rooms = []chatlib.Room{{Name: roomName()}}
+	}
+
+	var entries []RoomEntry
+
+	for _, room := range rooms {
+		entries = append(entries, RoomEntry{
+			Name:    room.Name,
+			URL:     "/rooms/" + room.Name,
+			Current: room.Name == roomName(),
+			Joined:  userName != "" && room.HasMember(userName),
+		})
+	}
+
+	return entries
+}
+
+// Show a room's posts: /rooms/NAME
+func RoomServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("RoomServer called with status: " + getStatusValue())
+
+	name := chatlib.NormalizeRoomName(strings.TrimPrefix(req.URL.Path, "/rooms/"))
+
+	rooms, err := backend.ListRooms()
+
+	if err == nil {
+		if _, ok := chatlib.FindRoom(rooms, name); !ok {
+			err = errors.New("There is no room " + name)
+		}
+	}
+
+	if err != nil {
+		Debug.Println("Could not show room: " + err.Error())
+		setFailureMessage(err.Error())
+		w.WriteHeader(http.StatusNotFound)
+
+		// Don't lose their place if they're in the middle of something, such as registering
+		if status == NOT_LOGGED_IN || status == LOGGED_IN {
+			status = ROOM_FAILED
+		}
+	} else {
+		currentRoom = name
+	}
+
+	if token == "" {
+		StartServer(w, req)
+	} else {
+		HomeServer(w, req)
+	}
+}
+
+// Create a room, or join one, and show it
+func RoomsServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("RoomsServer called with status: " + getStatusValue())
+
+	if status == NOT_LOGGED_IN {
+		StartServer(w, req)
+		return
+	}
+
+	req.ParseForm() // Parses the request body
+
+	name := chatlib.NormalizeRoomName(req.PostForm.Get("room"))
+
+	var err error
+
+	if problems := chatlib.CheckRoomName(name); len(problems) > 0 {
+		err = errors.New(strings.Join(problems, "; "))
+	} else if req.PostForm.Get("action") == "create" {
+		Debug.Println("Calling CreateRoom")
+
+		err = backend.CreateRoom(token, name)
+		status = ROOM_CREATED
+	} else {
+		Debug.Println("Calling JoinRoom")
+
+		err = backend.JoinRoom(token, name)
+		status = ROOM_JOINED
+	}
+
+	if err != nil {
+		Debug.Println("Could not create or join room: " + err.Error())
+		setFailureMessage(err.Error())
+		status = ROOM_FAILED
+	} else {
+		currentRoom = name
+	}
+
+	HomeServer(w, req)
+}
@@ -0,0 +1,215 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Searching posts:
+
+  Every page of posts we get goes into a chatlib.SearchIndex,
+  which we keep in SearchIndexFile from conf.json,
+  so it has every post the app has shown, not just the latest ones.
+
+  The search box in the sidebar of posts.tmpl goes to /search?q=QUERY (SearchServer),
+  which adds the latest posts in every room to the index, searches it,
+  and shows what matched in <mark>s. Anyone can search, logged in or not.
+  See chatlib/search.go for what a query can have.
+*/
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+// Every post we've gotten, to search.
+// A reload can replace it, so get it with currentSearchIndex.
+var searchIndex = chatlib.NewSearchIndex()
+var searchIndexMutex sync.Mutex
+
+func currentSearchIndex() *chatlib.SearchIndex {
+	searchIndexMutex.Lock()
+	defer searchIndexMutex.Unlock()
+
+	return searchIndex
+}
+
+// Where we save the posts we search
+func searchIndexFile() string {
+	file := currentConfiguration().SearchIndexFile
+
+	if file == "" {
+		return "search.json"
+	}
+
+	return file
+}
+
+// Read the posts we saved the last time the app ran
+func loadSearchIndex() {
+	index, err := chatlib.LoadSearchIndex(searchIndexFile())
+
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	searchIndexMutex.Lock()
+	searchIndex = index
+	searchIndexMutex.Unlock()
+}
+
+// Add posts to the search index, and save it if they're new
+func indexPosts(posts []chatlib.Post) {
+	index := currentSearchIndex()
+
+	if index.Add(posts) == 0 {
+		return
+	}
+
+	err := index.Save(searchIndexFile())
+
+	if err != nil {
+		log.Println("Could not save search index: " + err.Error())
+	}
+}
+
+// Take a deleted post out of the search index
+func unindexPost(key chatlib.PostKey) {
+	index := currentSearchIndex()
+	index.Remove(key)
+
+	err := index.Save(searchIndexFile())
+
+	if err != nil {
+		log.Println("Could not save search index: " + err.Error())
+	}
+}
+
+// A post that matched
+type SearchResult struct {
+	Alias string
+	Date  string
+	Room  string
+	URL   string
+	// The message, escaped, with what matched in <mark>s
+	MessageHTML string
+}
+
+type SearchContext struct {
+	Query   string
+	Results []SearchResult
+	// Where to go back to: the start page or, once they log in, the home page
+	Back string
+	Room string
+}
+
+// Escape message, and put what q matched in <mark>s
+func searchHTML(q chatlib.Query, message string) string {
+	var html strings.Builder
+
+	for _, part := range q.Highlight(message) {
+		if part.Match {
+			html.WriteString("<mark>" + template.HTMLEscapeString(part.Text) + "</mark>")
+		} else {
+			html.WriteString(template.HTMLEscapeString(part.Text))
+		}
+	}
+
+	return html.String()
+}
+
+// Search the posts: /search?q=QUERY
+func SearchServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("SearchServer called with status: " + getStatusValue())
+
+	query := strings.TrimSpace(req.URL.Query().Get("q"))
+	context := SearchContext{Query: query, Back: "/", Room: roomName()}
+
+	if token != "" {
+		context.Back = "/home"
+	}
+
+	message := ""
+
+	q, err := chatlib.ParseQuery(query, time.Local)
+
+	if err != nil {
+		message = "<b>Could not search!</b> " + template.HTMLEscapeString(err.Error())
+	} else {
+		Debug.Println("Calling RefreshIndex")
+
+		index := currentSearchIndex()
+
+		_, err = chatlib.RefreshIndex(backend, index, currentConfiguration().MaxMessages)
+
+		if err != nil {
+			Debug.Println("Searching only the posts we already have: " + err.Error())
+		}
+
+		err = index.Save(searchIndexFile())
+
+		if err != nil {
+			log.Println("Could not save search index: " + err.Error())
+		}
+
+		posts := index.Search(q)
+		maxMessages := currentConfiguration().MaxMessages
+
+		switch {
+		case len(posts) > maxMessages:
+			message = "The newest " + strconv.Itoa(maxMessages) + " of " + strconv.Itoa(len(posts)) + " posts that match"
+			posts = posts[:maxMessages]
+		case len(posts) == 1:
+			message = "1 post matches"
+		default:
+			message = strconv.Itoa(len(posts)) + " posts match"
+		}
+
+		message += " (searched " + strconv.Itoa(index.Len()) + " posts)"
+
+		for _, p := range posts {
+			result := SearchResult{Alias: p.Alias, Room: p.Room, URL: "/rooms/" + p.Room, MessageHTML: searchHTML(q, p.Message)}
+
+			numTime, err := strconv.ParseInt(p.Timestamp, 10, 64)
+
+			if err == nil {
+				thisTime := time.Unix(numTime, 0)
+				result.Date = FormatAsDate(thisTime).String() + " " + FormatAsTime(thisTime).String()
+			} else {
+				result.Date = "???"
+			}
+
+			if p.Edited() {
+				result.Date += " (edited)"
+			}
+
+			context.Results = append(context.Results, result)
+		}
+	}
+
+	theme := requestTheme(req)
+	headerContext := HeaderContext{Message: message, Title: "Search - " + theme.Title, Theme: theme}
+
+	s1 := lookupTemplate("header.tmpl")
+	s1.Execute(w, headerContext)
+
+	// Instead of posts.tmpl and the forms
+	s2 := lookupTemplate("search.tmpl")
+	s2.Execute(w, context)
+
+	s3 := lookupTemplate("footer.tmpl")
+	s3.Execute(w, newFooterContext(req))
+}
@@ -0,0 +1,143 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Passwords:
+
+  We never keep a password after the request that sent it.
+  Passwords are []byte, not string, so we can zero them
+  (with chatlib.ZeroBytes) when we're done.
+
+  To log a user in once they finish registering
+  (which takes a second request, with the confirmation code),
+  we seal their password with a key that only this process knows
+  and give it to their browser in the pending cookie,
+  which expires after pendingTimeout.
+  If the cookie is gone when they finish, they have to log in themselves.
+*/
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The cookie with the sealed password of a pending registration
+const pendingCookie = "pending"
+
+// How long we can log someone in after they start registering
+const pendingTimeout = 15 * time.Minute
+
+// Seals pending registrations; new every time the server starts
+var pendingKey = newPendingKey()
+
+func newPendingKey() cipher.AEAD {
+	key := make([]byte, 32)
+
+	_, err := rand.Read(key)
+
+	if err != nil {
+		log.Fatal("Error creating key for pending registrations: " + err.Error())
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		log.Fatal("Error creating key for pending registrations: " + err.Error())
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		log.Fatal("Error creating key for pending registrations: " + err.Error())
+	}
+
+	return aead
+}
+
+// Tie a sealed password to the browser session, user, and expiration time
+func pendingData(req *http.Request, userName string, expires int64) []byte {
+	return []byte(getSession(req).ID + "\n" + userName + "\n" + strconv.FormatInt(expires, 10))
+}
+
+// Give the browser the sealed password of the user who started registering
+func setPendingRegistration(w http.ResponseWriter, req *http.Request, userName string, password []byte) error {
+	expires := time.Now().Add(pendingTimeout).Unix()
+
+	nonce := make([]byte, pendingKey.NonceSize())
+
+	_, err := rand.Read(nonce)
+
+	if err != nil {
+		return err
+	}
+
+	sealed := pendingKey.Seal(nonce, nonce, password, pendingData(req, userName, expires))
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingCookie,
+		Value:    strconv.FormatInt(expires, 10) + "." + base64.RawURLEncoding.EncodeToString(sealed),
+		Path:     "/register",
+		MaxAge:   int(pendingTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return nil
+}
+
+// Get the password of the user who is finishing registering, and delete the cookie.
+// Zero the password when you're done with it.
+func takePendingRegistration(w http.ResponseWriter, req *http.Request, userName string) ([]byte, error) {
+	http.SetCookie(w, &http.Cookie{Name: pendingCookie, Value: "", Path: "/register", MaxAge: -1})
+
+	cookie, err := req.Cookie(pendingCookie)
+
+	if err != nil {
+		return nil, errors.New("No pending registration")
+	}
+
+	parts := strings.SplitN(cookie.Value, ".", 2)
+
+	if len(parts) != 2 {
+		return nil, errors.New("Pending registration is not valid")
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+
+	if err != nil || time.Now().Unix() > expires {
+		return nil, errors.New("Pending registration has expired")
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil || len(sealed) < pendingKey.NonceSize() {
+		return nil, errors.New("Pending registration is not valid")
+	}
+
+	nonce := sealed[:pendingKey.NonceSize()]
+
+	password, err := pendingKey.Open(nil, nonce, sealed[pendingKey.NonceSize():], pendingData(req, userName, expires))
+
+	if err != nil {
+		return nil, errors.New("Pending registration is not valid")
+	}
+
+	return password, nil
+}
@@ -0,0 +1,218 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Security:
+
+  Every request goes through handle, which:
+  1. Adds security headers (CSP, X-Frame-Options, Referrer-Policy, and,
+     when we're using TLS, HSTS).
+  2. Rejects the request if it doesn't use the method the handler expects.
+  3. Finds the browser's session from the session cookie,
+     or starts a new one.
+  4. For a POST, rejects the request unless the csrf_token form field
+     matches the session's CSRF token.
+  5. Logs the user out if their access token has expired
+     or isn't valid (see auth.go).
+
+  Every form includes the session's CSRF token in a hidden csrf_token field;
+  the templates get it from FormContext.CSRFToken.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/awsdocs/aws-example-apps/chat-app/clients/go/chatlib"
+)
+
+type Session struct {
+	ID        string
+	CSRFToken string
+	LastUsed  time.Time
+}
+
+// The cookie with the session ID
+const sessionCookie = "session"
+
+// Sessions that haven't been used for this long are forgotten
+const sessionTimeout = 24 * time.Hour
+
+// The form field with the CSRF token
+const csrfField = "csrf_token"
+
+var sessions = make(map[string]*Session)
+var sessionsMutex sync.Mutex
+
+type sessionKey struct{}
+
+// Get a random string that's hard to guess
+func newRandomString() string {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		log.Fatal("Error getting random bytes: " + err.Error())
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Get the session for the request's session cookie,
+// or start a new session (and set the cookie) if there isn't one
+func findSession(w http.ResponseWriter, req *http.Request) *Session {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	now := time.Now()
+
+	cookie, err := req.Cookie(sessionCookie)
+
+	if err == nil {
+		session, ok := sessions[cookie.Value]
+
+		if ok && now.Sub(session.LastUsed) < sessionTimeout {
+			session.LastUsed = now
+			return session
+		}
+	}
+
+	// Forget old sessions while we're here
+	for id, session := range sessions {
+		if now.Sub(session.LastUsed) >= sessionTimeout {
+			delete(sessions, id)
+		}
+	}
+
+	session := &Session{ID: newRandomString(), CSRFToken: newRandomString(), LastUsed: now}
+	sessions[session.ID] = session
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session.ID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	Debug.Println("Started a new session")
+
+	return session
+}
+
+// Get the session that handle found for this request
+func getSession(req *http.Request) *Session {
+	session, _ := req.Context().Value(sessionKey{}).(*Session)
+
+	if session == nil {
+		// Only happens if a handler isn't wrapped by handle
+		session = &Session{}
+	}
+
+	return session
+}
+
+// The data every template with a form needs
+type FormContext struct {
+	CSRFToken string
+	// For the forms that set a password
+	PasswordPolicy chatlib.PasswordPolicy
+	// The problems with each field, and what was in it, by field name
+	Errors map[string][]string
+	Values map[string]string
+	// For challenge.tmpl, what the user pool wants before it signs the user in
+	Challenge *chatlib.Challenge
+	// For unregister.tmpl, what the user can type instead of their password,
+	// and true if they must type it, since they sign in with SMS MFA
+	DeletionPhrase     string
+	DeletionPhraseOnly bool
+}
+
+func newFormContext(req *http.Request) FormContext {
+	return FormContext{CSRFToken: getSession(req).CSRFToken, PasswordPolicy: passwordPolicy()}
+}
+
+func setSecurityHeaders(w http.ResponseWriter, req *http.Request) {
+	header := w.Header()
+
+	header.Set("Content-Security-Policy",
+		"default-src 'self'; "+
+			"script-src 'self'; "+
+			"style-src 'self' 'unsafe-inline'; "+
+			"img-src 'self' https: data:; "+
+			"form-action 'self'; "+
+			"frame-ancestors 'none'")
+	header.Set("X-Frame-Options", "DENY")
+	header.Set("Referrer-Policy", "same-origin")
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	if req.TLS != nil {
+		header.Set("Strict-Transport-Security", "max-age=31536000")
+	}
+}
+
+// Wrap handler so it only accepts method (GET also allows HEAD),
+// has a session, and, for a POST, has a valid CSRF token
+func handle(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		setSecurityHeaders(w, req)
+
+		if req.Method != method && !(method == http.MethodGet && req.Method == http.MethodHead) {
+			Debug.Println("Rejecting " + req.Method + " request for " + req.URL.Path)
+			w.Header().Set("Allow", method)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		session := findSession(w, req)
+
+		if method == http.MethodPost {
+			req.ParseForm()
+
+			token := req.PostForm.Get(csrfField)
+
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) != 1 {
+				log.Println("Rejecting POST to " + req.URL.Path + " with a missing or wrong CSRF token")
+				http.Error(w, "Your session has expired; go back, reload the page, and try again", http.StatusForbidden)
+				return
+			}
+		}
+
+		// Log them out if their token has expired
+		checkSession()
+
+		handler(w, req.WithContext(context.WithValue(req.Context(), sessionKey{}, session)))
+	}
+}
+
+// Wrap handler so it only adds the security headers;
+// for handlers that don't need a session, such as static files
+func handleStatic(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		setSecurityHeaders(w, req)
+
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler(w, req)
+	}
+}
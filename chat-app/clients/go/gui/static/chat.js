@@ -0,0 +1,179 @@
+/*
+  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+
+  Licensed under the Apache License, Version 2.0 (the "License").
+  You may not use this file except in compliance with the License.
+  A copy of the License is located at
+
+  http://aws.amazon.com/apache2.0/
+*/
+
+// Scripts live here, not in the pages, so the Content-Security-Policy
+// can forbid inline scripts
+
+// Copy the ID of the selected post into the form that deletes a post
+function SelectItem(i) {
+  var messageId = document.getElementById("message_id");
+
+  if (messageId) {
+    messageId.value = i;
+  }
+}
+
+// Fill in the form that edits a post with the selected post,
+// if the logged-in user wrote it; otherwise turn the form off
+function SelectForEdit(posts) {
+  var editId = document.getElementById("edit_id");
+  var editMessage = document.getElementById("edit_message");
+  var editButton = document.getElementById("edit_button");
+
+  if (!editId || !editMessage || !editButton) {
+    return;
+  }
+
+  var option = posts.options[posts.selectedIndex];
+  var mine = option && option.dataset.alias !== undefined && option.dataset.alias === posts.dataset.user;
+
+  editId.value = mine ? option.value : "";
+  editMessage.value = mine ? option.dataset.message : "";
+  editMessage.disabled = !mine;
+  editButton.disabled = !mine;
+}
+
+// The characters Amazon Cognito counts as symbols (chatlib.PasswordSymbols)
+var passwordSymbols = "^$*.[]{}()?\"!@#%&/\\,><':;|_~`=+- ";
+
+// Rate a password the way chatlib's PasswordPolicy.Strength does,
+// using the policy in the input's data- attributes
+function PasswordStrength(input) {
+  var policy = input.dataset;
+  var password = Array.from(input.value);
+  var has = { numbers: false, symbols: false, uppercase: false, lowercase: false, other: false };
+  var problems = [];
+
+  password.forEach(function (c) {
+    if (passwordSymbols.indexOf(c) >= 0) {
+      has.symbols = true;
+    } else if (/\p{Nd}/u.test(c)) {
+      has.numbers = true;
+    } else if (/\p{Lu}/u.test(c)) {
+      has.uppercase = true;
+    } else if (/\p{Ll}/u.test(c)) {
+      has.lowercase = true;
+    } else {
+      has.other = true;
+    }
+  });
+
+  if (password.length < Number(policy.minimumLength)) {
+    problems.push("at least " + policy.minimumLength + " characters");
+  }
+
+  if (policy.requireNumbers === "true" && !has.numbers) {
+    problems.push("a number");
+  }
+
+  if (policy.requireSymbols === "true" && !has.symbols) {
+    problems.push("a symbol");
+  }
+
+  if (policy.requireUppercase === "true" && !has.uppercase) {
+    problems.push("an uppercase letter");
+  }
+
+  if (policy.requireLowercase === "true" && !has.lowercase) {
+    problems.push("a lowercase letter");
+  }
+
+  if (problems.length > 0) {
+    return { strength: "weak", text: "Needs " + problems.join(", ") };
+  }
+
+  var score = Object.keys(has).filter(function (k) { return has[k]; }).length;
+
+  if (password.length >= 10) {
+    score++;
+  }
+
+  if (password.length >= 14) {
+    score++;
+  }
+
+  var strength = "weak";
+
+  if (score >= 5) {
+    strength = "strong";
+  } else if (score >= 4) {
+    strength = "good";
+  } else if (score >= 2) {
+    strength = "fair";
+  }
+
+  return { strength: strength, text: "Strength: " + strength };
+}
+
+// Show how strong the password is as they type it
+function ShowPasswordStrength(input) {
+  var indicator = input.parentNode.querySelector(".password-strength");
+
+  if (!indicator) {
+    return;
+  }
+
+  if (input.value === "") {
+    indicator.textContent = "";
+    indicator.removeAttribute("data-strength");
+    return;
+  }
+
+  var rating = PasswordStrength(input);
+
+  indicator.textContent = rating.text;
+  indicator.setAttribute("data-strength", rating.strength);
+}
+
+// Point the forms that reply to a post, react to it, and hide or show its replies,
+// at the selected post; turn them off if a date line is selected
+function SelectForThread(posts) {
+  var option = posts.options[posts.selectedIndex];
+  var isPost = option && option.dataset.alias !== undefined && option.dataset.alias !== "";
+  var ids = ["reply", "react", "thread"];
+
+  ids.forEach(function (id) {
+    var alias = document.getElementById(id + "_alias");
+    var timestamp = document.getElementById(id + "_timestamp");
+    var button = document.getElementById(id + "_button");
+
+    if (!alias || !timestamp || !button) {
+      return;
+    }
+
+    alias.value = isPost ? option.dataset.alias : "";
+    timestamp.value = isPost ? option.value : "";
+    button.disabled = !isPost || (id === "thread" && option.dataset.replies === "0");
+  });
+
+  ["reply_message", "react_reaction"].forEach(function (id) {
+    var input = document.getElementById(id);
+
+    if (input) {
+      input.disabled = !isPost;
+    }
+  });
+}
+
+document.addEventListener("DOMContentLoaded", function () {
+  var posts = document.getElementById("the_posts");
+
+  if (posts) {
+    posts.addEventListener("change", function () {
+      SelectItem(this.value);
+      SelectForEdit(this);
+      SelectForThread(this);
+    });
+  }
+
+  document.querySelectorAll("input.new-password").forEach(function (input) {
+    input.addEventListener("input", function () { ShowPasswordStrength(this); });
+  });
+});
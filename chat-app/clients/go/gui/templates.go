@@ -0,0 +1,213 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Templates:
+
+  The *.tmpl files in this folder are built into the binary,
+  so it runs from any folder.
+
+  To work on the templates without rebuilding,
+  start the server with -templates-dir FOLDER.
+  Any *.tmpl file in FOLDER replaces the built-in template of the same name
+  (templates that aren't in FOLDER still come from the binary),
+  and the templates are parsed again whenever a file in FOLDER changes.
+*/
+
+import (
+	"embed"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed *.tmpl
+var embeddedTemplates embed.FS
+
+// From -templates-dir; empty means only use the built-in templates
+var templatesDir string
+
+// Functions the templates can call. supports "EditPost" is false
+// if the backend can't edit posts, so the template hides that.
+var templateFuncs = template.FuncMap{
+	"supports": func(function string) bool { return backend.Supports(function) },
+}
+
+// Get the names of the *.tmpl files in dir
+func templateNames(dir string) ([]string, error) {
+	var names []string
+
+	files, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		return names, err
+	}
+
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".tmpl") {
+			names = append(names, file.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// Parse the built-in templates, replacing any with the same name in templatesDir
+func parseTemplateFiles() (*template.Template, error) {
+	sources := make(map[string][]byte)
+
+	entries, err := embeddedTemplates.ReadDir(".")
+
+	if err != nil {
+		return nil, errors.New("Error reading built-in templates: " + err.Error())
+	}
+
+	for _, entry := range entries {
+		content, err := embeddedTemplates.ReadFile(entry.Name())
+
+		if err != nil {
+			return nil, errors.New("Error reading built-in template " + entry.Name() + ": " + err.Error())
+		}
+
+		sources[entry.Name()] = content
+	}
+
+	if templatesDir != "" {
+		names, err := templateNames(templatesDir)
+
+		if err != nil {
+			return nil, errors.New("Error getting templates in " + templatesDir + ": " + err.Error())
+		}
+
+		for _, name := range names {
+			content, err := ioutil.ReadFile(filepath.Join(templatesDir, name))
+
+			if err != nil {
+				return nil, errors.New("Error reading template " + name + ": " + err.Error())
+			}
+
+			Debug.Println("Using " + name + " from " + templatesDir)
+			sources[name] = content
+		}
+	}
+
+	var names []string
+
+	for name := range sources {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	parsed := template.New("").Funcs(templateFuncs)
+
+	for _, name := range names {
+		_, err := parsed.New(name).Parse(string(sources[name]))
+
+		if err != nil {
+			return nil, errors.New("Error parsing templates: " + err.Error())
+		}
+	}
+
+	return parsed, nil
+}
+
+func ParseTemplates() {
+	var err error
+
+	templates, err = parseTemplateFiles()
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+// Get a template by name;
+// safe to call while the templates are being reloaded
+func lookupTemplate(name string) *template.Template {
+	reloadMutex.RLock()
+	defer reloadMutex.RUnlock()
+
+	return templates.Lookup(name)
+}
+
+// Summarize the *.tmpl files in templatesDir
+// so we can tell when one is added, removed, or changed
+func templatesDirState() string {
+	names, err := templateNames(templatesDir)
+
+	if err != nil {
+		return ""
+	}
+
+	state := ""
+
+	for _, name := range names {
+		state += name + "@" + modTime(filepath.Join(templatesDir, name)).String() + ";"
+	}
+
+	return state
+}
+
+// Parse the templates again whenever a file in templatesDir changes.
+// Never returns.
+func watchTemplates() {
+	ticker := time.NewTicker(configPollInterval)
+	lastState := templatesDirState()
+
+	for range ticker.C {
+		state := templatesDirState()
+
+		if state == lastState {
+			continue
+		}
+
+		lastState = state
+
+		newTemplates, err := parseTemplateFiles()
+
+		if err != nil {
+			log.Println("Templates in " + templatesDir + " changed but did not parse, still using the previous templates: " + err.Error())
+			continue
+		}
+
+		reloadMutex.Lock()
+		templates = newTemplates
+		reloadMutex.Unlock()
+
+		log.Println("Templates in " + templatesDir + " changed, reloaded them")
+	}
+}
+
+// Make sure -templates-dir names a folder
+func checkTemplatesDir() error {
+	if templatesDir == "" {
+		return nil
+	}
+
+	info, err := os.Stat(templatesDir)
+
+	if err != nil {
+		return errors.New("Cannot use templates folder: " + err.Error())
+	}
+
+	if !info.IsDir() {
+		return errors.New("Cannot use templates folder: " + templatesDir + " is not a folder")
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+/*  Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License").
+ *  You may not use this file except in compliance with the License.
+ *  A copy of the License is located at
+ *
+ *  http://aws.amazon.com/apache2.0/
+ */
+
+package main
+
+/*
+  Themes:
+
+  A theme sets the title of the app, its logo,
+  whether it's light or dark, and any of the CSS variables in static/chat.css.
+  There are two built-in themes, light and dark.
+  You can add themes, or replace the built-in ones, in the Themes entry of conf.json,
+  and pick the default theme with the Theme entry.
+
+  A user can pick another theme from the list at the bottom of every page;
+  we remember their choice in the theme cookie.
+
+  Static files:
+
+  /static/NAME serves NAME from the StaticDir folder, if it exists there,
+  otherwise from the files in the static folder that are built into the app.
+  That's where a theme gets its logo and the app gets its style sheet.
+*/
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Theme struct {
+	// Set from the key in Themes
+	Name string
+	// Replaces "Chat App" in page titles
+	Title string
+	// URL of the logo shown next to the title; no logo if empty
+	Logo string
+	// light (the default) or dark
+	Mode string
+	// CSS variables, such as "heading": "#ff9900", without the leading --
+	Colors map[string]string
+}
+
+const defaultTheme = "light"
+
+var builtinThemes = map[string]Theme{
+	"light": {Title: "Chat App", Logo: "/static/logo.svg", Mode: "light"},
+	"dark":  {Title: "Chat App", Logo: "/static/logo.svg", Mode: "dark"},
+}
+
+// The cookie with the theme the user picked
+const themeCookie = "theme"
+
+// How long browsers can cache static files
+const staticMaxAge = time.Hour
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// Get the built-in themes and the ones in the configuration
+func allThemes(c Configuration) map[string]Theme {
+	themes := make(map[string]Theme)
+
+	for name, theme := range builtinThemes {
+		themes[name] = theme
+	}
+
+	for name, theme := range c.Themes {
+		themes[name] = theme
+	}
+
+	for name, theme := range themes {
+		theme.Name = name
+
+		if theme.Title == "" {
+			theme.Title = "Chat App"
+		}
+
+		if theme.Mode == "" {
+			theme.Mode = "light"
+		}
+
+		themes[name] = theme
+	}
+
+	return themes
+}
+
+func themeNames(c Configuration) []string {
+	var names []string
+
+	for name := range allThemes(c) {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func validateThemes(c Configuration) error {
+	themes := allThemes(c)
+
+	for name, theme := range themes {
+		if theme.Mode != "light" && theme.Mode != "dark" {
+			return errors.New("Mode of theme " + name + " must be light or dark, not " + theme.Mode)
+		}
+
+		for variable, value := range theme.Colors {
+			// These end up in a style element, so don't let them end it
+			if strings.ContainsAny(variable+value, ";{}<>") {
+				return errors.New("Color " + variable + " of theme " + name + " has a character that isn't allowed")
+			}
+		}
+	}
+
+	if c.Theme != "" {
+		if _, ok := themes[c.Theme]; !ok {
+			return errors.New("Theme " + c.Theme + " is not a built-in theme or in Themes")
+		}
+	}
+
+	return nil
+}
+
+// Get the theme the user picked or, if they haven't, the configured one
+func requestTheme(req *http.Request) Theme {
+	c := currentConfiguration()
+	themes := allThemes(c)
+
+	cookie, err := req.Cookie(themeCookie)
+
+	if err == nil {
+		if theme, ok := themes[cookie.Value]; ok {
+			return theme
+		}
+	}
+
+	if theme, ok := themes[c.Theme]; ok {
+		return theme
+	}
+
+	return themes[defaultTheme]
+}
+
+type FooterContext struct {
+	Themes    []string
+	Theme     string
+	CSRFToken string
+}
+
+func newFooterContext(req *http.Request) FooterContext {
+	return FooterContext{Themes: themeNames(currentConfiguration()), Theme: requestTheme(req).Name, CSRFToken: getSession(req).CSRFToken}
+}
+
+// Remember the theme the user picked and take them back to where they were
+func ThemeServer(w http.ResponseWriter, req *http.Request) {
+	Debug.Println("")
+	Debug.Println("ThemeServer called")
+
+	req.ParseForm()
+
+	name := req.PostForm.Get("theme")
+
+	if _, ok := allThemes(currentConfiguration())[name]; ok {
+		http.SetCookie(w, &http.Cookie{
+			Name:     themeCookie,
+			Value:    name,
+			Path:     "/",
+			MaxAge:   365 * 24 * 60 * 60,
+			HttpOnly: true,
+			Secure:   req.TLS != nil,
+		})
+	}
+
+	http.Redirect(w, req, "/", http.StatusSeeOther)
+}
+
+// Get a static file from StaticDir or, if it's not there, from the built-in ones
+func readStatic(name string) ([]byte, time.Time, error) {
+	staticDir := currentConfiguration().StaticDir
+
+	if staticDir != "" {
+		filename := filepath.Join(staticDir, filepath.FromSlash(name))
+		content, err := ioutil.ReadFile(filename)
+
+		if err == nil {
+			return content, modTime(filename), nil
+		}
+	}
+
+	content, err := embeddedStatic.ReadFile("static/" + name)
+
+	return content, time.Time{}, err
+}
+
+func StaticServer(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(path.Clean(req.URL.Path), "/static/")
+
+	if name == "" || strings.HasPrefix(name, ".") || strings.Contains(name, "..") {
+		http.NotFound(w, req)
+		return
+	}
+
+	content, modified, err := readStatic(name)
+
+	if err != nil {
+		Debug.Println("Could not find static file " + name)
+		http.NotFound(w, req)
+		return
+	}
+
+	sum := sha256.Sum256(content)
+
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(staticMaxAge.Seconds())))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+
+	// Sets Content-Type from the extension and handles If-None-Match
+	http.ServeContent(w, req, name, modified, bytes.NewReader(content))
+}